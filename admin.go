@@ -0,0 +1,97 @@
+package iqfeed
+
+import (
+	"net"
+	"strings"
+)
+
+// defaultAdminPort is the IQFeed admin port, used when AdminAddress doesn't give one.
+const defaultAdminPort = "9300"
+
+// ClientStats are the connection statistics IQConnect reports with S,STATS messages: the server in use, the symbols watched, bandwidth and whether it is connected to the DTN servers at all.
+type ClientStats = SystemStats
+
+// ConnectAdmin dials the admin port, which reports ClientStats on the Stats channel once a second. The client must have been started.
+func (c *IQC) ConnectAdmin() error {
+	if c.stop == nil {
+		return ErrNotStarted
+	}
+	c.adminMu.Lock()
+	defer c.adminMu.Unlock()
+	_, err := c.service(&c.adminConn, c.AdminAddress, defaultAdminPort, c.readAdmin)
+	return err
+}
+
+// SetLoginID sets the DTN login IQConnect uses to connect to the servers (the S,SET LOGINID admin command), see SaveLoginInfo to keep it for the next launch.
+func (c *IQC) SetLoginID(id string) error {
+	return c.sendAdmin("S,SET LOGINID," + id + "\r\n")
+}
+
+// SetPassword sets the password of the DTN login IQConnect uses (the S,SET PASSWORD admin command).
+func (c *IQC) SetPassword(password string) error {
+	return c.sendAdmin("S,SET PASSWORD," + password + "\r\n")
+}
+
+// SaveLoginInfo tells IQConnect whether to remember the login and password for its next launch (the S,SET SAVE LOGIN INFO admin command).
+func (c *IQC) SaveLoginInfo(save bool) error {
+	return c.sendAdmin("S,SET SAVE LOGIN INFO," + onOff(save) + "\r\n")
+}
+
+// SetAutoconnect tells IQConnect whether to connect to the servers on launch without showing its login dialog (the S,SET AUTOCONNECT admin command).
+func (c *IQC) SetAutoconnect(auto bool) error {
+	return c.sendAdmin("S,SET AUTOCONNECT," + onOff(auto) + "\r\n")
+}
+
+// onOff formats a flag of the admin commands.
+func onOff(b bool) string {
+	if b {
+		return "On"
+	}
+	return "Off"
+}
+
+// sendAdmin writes a command to the admin port, dialling it first if needed as ConnectAdmin does.
+func (c *IQC) sendAdmin(cmd string) error {
+	if c.stop == nil {
+		return ErrNotStarted
+	}
+	if c.stopped() {
+		return c.stopErr(ErrClientStopped)
+	}
+	c.adminMu.Lock()
+	defer c.adminMu.Unlock()
+	conn, err := c.service(&c.adminConn, c.AdminAddress, defaultAdminPort, c.readAdmin)
+	if err != nil {
+		return err
+	}
+	_, err = conn.Write([]byte(cmd))
+	return err
+}
+
+// readAdmin reads the admin connection until it is closed.
+func (c *IQC) readAdmin(conn net.Conn) {
+	c.readService(conn, &c.adminConn, "Admin", c.processAdmin)
+}
+
+// processAdmin handles a single line from the admin port, only the stats messages are of interest.
+func (c *IQC) processAdmin(d []byte) {
+	if !strings.HasPrefix(string(d), "S,STATS,") {
+		return
+	}
+	st := &ClientStats{Received: c.stamp(d)}
+	st.UnMarshall(strings.Split(string(d[len("S,STATS,"):]), ","), c.TimeLoc)
+	c.sendStats(st)
+}
+
+// sendStats sends stats on the Stats channel, clients without one (ex: offline parsing) drop them.
+func (c *IQC) sendStats(st *ClientStats) {
+	if c.Stats == nil {
+		return
+	}
+	if !c.divert("Stats", c.Stats, st) {
+		select {
+		case c.Stats <- st:
+		case <-c.stop:
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package iqfeed
+
+import (
+	"strconv"
+	"time"
+)
+
+// AssetClass is the IQFeed security type ID of a symbol, See: Security Types (http://www.iqfeed.net/dev/api/docs/SecurityTypes.cfm).
+type AssetClass int
+
+// Security type IDs as listed by IQFeed.
+const (
+	AssetUnknown           AssetClass = iota // Not sent or not recognised.
+	AssetEquity                              // EQUITY
+	AssetIndexOption                         // IEOPTION, index and equity options.
+	AssetMutualFund                          // MUTUAL
+	AssetMoneyMarket                         // MONEY
+	AssetBond                                // BONDS
+	AssetIndex                               // INDEX
+	AssetMarketStats                         // MKTSTATS
+	AssetFuture                              // FUTURE
+	AssetFutureOption                        // FOPTION
+	AssetFutureSpread                        // SPREAD
+	AssetSpot                                // SPOT
+	AssetForward                             // FORWARD
+	AssetCalculated                          // CALC
+	AssetStrip                               // STRIP
+	AssetSingleStockFuture                   // SSFUTURE
+	AssetForex                               // FOREX
+)
+
+var assetClassNames = map[AssetClass]string{
+	AssetEquity:            "EQUITY",
+	AssetIndexOption:       "IEOPTION",
+	AssetMutualFund:        "MUTUAL",
+	AssetMoneyMarket:       "MONEY",
+	AssetBond:              "BONDS",
+	AssetIndex:             "INDEX",
+	AssetMarketStats:       "MKTSTATS",
+	AssetFuture:            "FUTURE",
+	AssetFutureOption:      "FOPTION",
+	AssetFutureSpread:      "SPREAD",
+	AssetSpot:              "SPOT",
+	AssetForward:           "FORWARD",
+	AssetCalculated:        "CALC",
+	AssetStrip:             "STRIP",
+	AssetSingleStockFuture: "SSFUTURE",
+	AssetForex:             "FOREX",
+}
+
+// String returns the IQFeed short name of the security type (ex: EQUITY, FUTURE).
+func (a AssetClass) String() string {
+	if n, ok := assetClassNames[a]; ok {
+		return n
+	}
+	return "UNKNOWN(" + strconv.Itoa(int(a)) + ")"
+}
+
+// HasOpenInterest reports whether symbols of this class carry open interest, expiration and settlement fields.
+func (a AssetClass) HasOpenInterest() bool {
+	switch a {
+	case AssetIndexOption, AssetFuture, AssetFutureOption, AssetFutureSpread, AssetSingleStockFuture:
+		return true
+	}
+	return false
+}
+
+// AssetClassOf returns the security type of symbol, from the fundamental message received when it was watched. The boolean is false until that has arrived.
+func (c *IQC) AssetClassOf(symbol string) (AssetClass, bool) {
+	c.precisionMu.Lock()
+	defer c.precisionMu.Unlock()
+	a, ok := c.assetClasses[symbol]
+	return a, ok
+}
+
+// setAssetClass remembers the security type of symbol for AssetClassOf.
+func (c *IQC) setAssetClass(symbol string, a AssetClass) {
+	if symbol == "" {
+		return
+	}
+	c.precisionMu.Lock()
+	defer c.precisionMu.Unlock()
+	if c.assetClasses == nil {
+		c.assetClasses = make(map[string]AssetClass)
+	}
+	c.assetClasses[symbol] = a
+}
+
+// classify sets the AssetClass of a summary or update message, clearing the open interest and settlement fields of classes that don't have them (see HasOpenInterest) so only those of futures and options are ever set.
+func (c *IQC) classify(u *UpdSummaryMsg) {
+	a, ok := c.AssetClassOf(u.Symbol)
+	if !ok {
+		return
+	}
+	u.AssetClass = a
+	if !a.HasOpenInterest() {
+		u.OpenInterest, u.Settle, u.SettleDate = 0, 0, time.Time{}
+	}
+}
+
+// DefaultPrecision returns the number of decimals prices of this class are usually quoted with.
+func (a AssetClass) DefaultPrecision() int {
+	switch a {
+	case AssetForex:
+		return 5
+	case AssetBond:
+		return 3
+	}
+	return 2
+}
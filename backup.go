@@ -0,0 +1,222 @@
+package iqfeed
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// backupFlushInterval is how often buffered backup lines are written out to the file.
+const backupFlushInterval = time.Second
+
+// maxBackupFailures is how many consecutive backup failures turn CreateBackup off.
+const maxBackupFailures = 3
+
+// backupState is the open backup file along with what is needed to decide when to rotate it.
+type backupState struct {
+	f        *os.File
+	w        *bufio.Writer
+	size     int64
+	opened   time.Time // When the segment was started, names the file once it is rotated.
+	flushed  time.Time
+	failures int // Consecutive failures, reset by a successful write.
+}
+
+// backupLine appends a raw feed line to the backup file, reporting failures to OnBackupError.
+// Backups are turned off (CreateBackup is cleared) with a single warning after maxBackupFailures failures in a row so a full disk doesn't stall or flood the feed.
+func (c *IQC) backupLine(d []byte) {
+	err := c.writeBackup(d)
+	if err == nil {
+		c.backup.failures = 0
+		return
+	}
+	if c.OnBackupError != nil {
+		c.OnBackupError(err)
+	}
+	// The buffered writer keeps failing once it has failed, the file is reopened on the next line in case the problem was temporary.
+	c.closeBackup()
+	if c.backup.failures++; c.backup.failures >= maxBackupFailures {
+		c.log().Warnf("Disabling backups after %d failures: %s", c.backup.failures, err)
+		c.CreateBackup = false
+	}
+}
+
+// writeBackup appends a raw feed line to BackupFile, rotating it first when MaxBackupBytes or RotateDaily call for it.
+// Lines are buffered and written out every backupFlushInterval, when the file is rotated and by Stop.
+func (c *IQC) writeBackup(d []byte) error {
+	if !c.CreateBackup {
+		return nil
+	}
+	now := time.Now()
+	if c.backup.f != nil && c.backupDue(len(d), now) {
+		if err := c.rotateBackup(); err != nil {
+			return err
+		}
+	}
+	if c.backup.f == nil {
+		if err := c.openBackup(now); err != nil {
+			return fmt.Errorf("iqfeed: could not open backup file: %w", err)
+		}
+	}
+	n, err := c.backup.w.Write(d)
+	c.backup.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("iqfeed: could not write backup file: %w", err)
+	}
+	if now.Sub(c.backup.flushed) >= backupFlushInterval {
+		c.backup.flushed = now
+		if err := c.backup.w.Flush(); err != nil {
+			return fmt.Errorf("iqfeed: could not write backup file: %w", err)
+		}
+	}
+	return nil
+}
+
+// openBackup opens BackupFile for appending, a file left over from an earlier run is continued and rotated like one written by this client.
+func (c *IQC) openBackup(now time.Time) error {
+	f, err := os.OpenFile(c.BackupFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	c.backup = backupState{f: f, w: bufio.NewWriterSize(f, 64*1024), opened: now, flushed: now, failures: c.backup.failures}
+	if fi, err := f.Stat(); err == nil && fi.Size() > 0 {
+		c.backup.size = fi.Size()
+		c.backup.opened = fi.ModTime()
+	}
+	return nil
+}
+
+// backupDue reports whether the open segment has to be rotated before n more bytes are written to it at now.
+func (c *IQC) backupDue(n int, now time.Time) bool {
+	// A single line larger than the limit still has to go somewhere, it is written to an empty segment.
+	if c.MaxBackupBytes > 0 && c.backup.size > 0 && c.backup.size+int64(n) > c.MaxBackupBytes {
+		return true
+	}
+	if c.RotateDaily {
+		loc := c.backupLoc()
+		return c.backup.opened.In(loc).Format("20060102") != now.In(loc).Format("20060102")
+	}
+	return false
+}
+
+// rotateBackup closes the open segment and renames it after the time it was started, compressing it in the background when CompressBackups is set.
+func (c *IQC) rotateBackup() error {
+	if err := c.closeBackup(); err != nil {
+		return err
+	}
+	name := rotatedName(c.BackupFile, c.backup.opened.In(c.backupLoc()))
+	if err := os.Rename(c.BackupFile, name); err != nil {
+		return fmt.Errorf("iqfeed: could not rotate backup file: %w", err)
+	}
+	if c.CompressBackups {
+		c.workers.Add(1)
+		go func() {
+			defer c.workers.Done()
+			if err := compressFile(name); err != nil {
+				c.log().Warnf("Could not compress backup file %s: %s", name, err)
+			}
+		}()
+	}
+	return nil
+}
+
+// closeBackup flushes and closes the open segment, if any.
+func (c *IQC) closeBackup() error {
+	if c.backup.f == nil {
+		return nil
+	}
+	err := c.backup.w.Flush()
+	if cerr := c.backup.f.Close(); err == nil {
+		err = cerr
+	}
+	c.backup.f, c.backup.w = nil, nil
+	if err != nil {
+		return fmt.Errorf("iqfeed: could not close backup file: %w", err)
+	}
+	return nil
+}
+
+// backupLoc returns the location days are counted in for RotateDaily.
+func (c *IQC) backupLoc() *time.Location {
+	if c.TimeLoc != nil {
+		return c.TimeLoc
+	}
+	return time.Local
+}
+
+// rotatedName returns the name a segment of path started at t is rotated to (ex: feed.txt becomes feed-20160314-093000.txt), with a counter added if that name is taken.
+func rotatedName(path string, t time.Time) string {
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext) + "-" + t.Format("20060102-150405")
+	name := base + ext
+	for i := 1; exists(name) || exists(name+".gz"); i++ {
+		name = fmt.Sprintf("%s.%d%s", base, i, ext)
+	}
+	return name
+}
+
+// exists reports whether a file exists at path.
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// compressFile gzips path to path.gz and removes the original once the copy is complete.
+func compressFile(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(path + ".gz")
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	_, err = io.Copy(zw, in)
+	if cerr := zw.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path + ".gz")
+		return err
+	}
+	in.Close()
+	return os.Remove(path)
+}
+
+// openBackupFile opens a backup file for reading, transparently decompressing rotated segments ending in .gz.
+func openBackupFile(path string) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(path, ".gz") {
+		return f, nil
+	}
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &gzipFile{Reader: zr, f: f}, nil
+}
+
+// gzipFile closes both the gzip reader and the underlying file.
+type gzipFile struct {
+	*gzip.Reader
+	f *os.File
+}
+
+func (g *gzipFile) Close() error {
+	g.Reader.Close()
+	return g.f.Close()
+}
@@ -0,0 +1,148 @@
+package iqfeed
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultDerivPort is the IQFeed derivative port streaming interval bars, used when DerivAddress doesn't give one.
+const defaultDerivPort = "9400"
+
+// IntervalBar is a live interval bar from the derivative port, field definitions are available here: http://www.iqfeed.net/dev/api/docs/Derivatives_StreamingIntervalBars_TCPIP.cfm.
+type IntervalBar struct {
+	Symbol      string    // The Symbol ID to match with watch request
+	Interval    int       // The bar length in seconds, as passed to WatchIntervalBars.
+	Complete    bool      // False while the bar is still forming (BU), true once it is complete (BC) or for a historical bar (BH).
+	Historical  bool      // True for bars sent from history (BH) when the watch starts.
+	Time        time.Time // Start of the bar (CCYY-MM-DD HH:MM:SS), interpreted in TimeLoc.
+	Open        float64   // Price of the first trade of the bar.
+	High        float64   // Highest trade price of the bar.
+	Low         float64   // Lowest trade price of the bar.
+	Close       float64   // Price of the last trade of the bar so far.
+	TotalVolume int       // Today's cumulative volume as of the bar.
+	Volume      int       // Volume traded within the bar.
+	Trades      int       // Number of trades within the bar.
+
+	Received
+}
+
+// UnMarshall sends the data into the usable struct for consumption by the application, items are the fields following the BH, BC or BU marker.
+func (b *IntervalBar) UnMarshall(items []string, loc *time.Location) {
+	for len(items) < 9 {
+		items = append(items, "")
+	}
+	b.Symbol = items[0]
+	b.Time, _ = time.ParseInLocation("2006-01-02 15:04:05", items[1], loc)
+	b.Open = GetFloatFromStr(items[2])
+	b.High = GetFloatFromStr(items[3])
+	b.Low = GetFloatFromStr(items[4])
+	b.Close = GetFloatFromStr(items[5])
+	b.TotalVolume = GetIntFromStr(items[6])
+	b.Volume = GetIntFromStr(items[7])
+	b.Trades = GetIntFromStr(items[8])
+}
+
+// inLoc converts the bar timestamp to loc.
+func (b *IntervalBar) inLoc(loc *time.Location) {
+	inLoc(loc, &b.Time)
+}
+
+// WatchIntervalBars starts streaming intervalSeconds long bars for symbol on the Bars channel (the BW command), the derivative port connection is dialled on first use. The client must have been started.
+// Several intervals may be watched for the same symbol, Interval tells their bars apart. The port uses the protocol 6 bar layout.
+func (c *IQC) WatchIntervalBars(symbol string, intervalSeconds int) error {
+	if intervalSeconds <= 0 {
+		return fmt.Errorf("iqfeed: invalid bar interval %d", intervalSeconds)
+	}
+	id := c.incr()
+	c.barsMu.Lock()
+	if c.barIntervals == nil {
+		c.barIntervals = make(map[string]int)
+	}
+	c.barIntervals[id] = intervalSeconds
+	c.barsMu.Unlock()
+	if err := c.sendDeriv(fmt.Sprintf("BW,%s,%d,,,,,,%s,s,,\r\n", symbol, intervalSeconds, id)); err != nil {
+		c.barsMu.Lock()
+		delete(c.barIntervals, id)
+		c.barsMu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// UnwatchIntervalBars stops every interval bar watch of symbol (the BR command).
+func (c *IQC) UnwatchIntervalBars(symbol string) error {
+	return c.sendDeriv("BR," + symbol + "\r\n")
+}
+
+// sendDeriv writes a command to the derivative port, dialling it and starting its reader first if needed.
+func (c *IQC) sendDeriv(cmd string) error {
+	if c.stop == nil {
+		return ErrNotStarted
+	}
+	if c.stopped() {
+		return c.stopErr(ErrClientStopped)
+	}
+	c.derivMu.Lock()
+	defer c.derivMu.Unlock()
+	conn, err := c.service(&c.derivConn, c.DerivAddress, defaultDerivPort, c.readDeriv)
+	if err != nil {
+		return err
+	}
+	_, err = conn.Write([]byte(cmd))
+	return err
+}
+
+// readDeriv reads the derivative port connection until it is closed. Bars go to Bars, errors and not found symbols to Errors, everything else is ignored.
+func (c *IQC) readDeriv(conn net.Conn) {
+	c.readService(conn, &c.derivConn, "Derivative", c.processDeriv)
+}
+
+// processDeriv handles a single line from the derivative port, lines answering a BW request start with its request id.
+func (c *IQC) processDeriv(d []byte) {
+	received := c.stamp(d)
+	items := strings.Split(strings.TrimSuffix(string(d), ","), ",")
+	id := ""
+	if len(items) > 1 {
+		if _, err := strconv.Atoi(items[0]); err == nil {
+			id, items = items[0], items[1:]
+		}
+	}
+	switch items[0] {
+	case "BH", "BC", "BU":
+		b := &IntervalBar{Complete: items[0] != "BU", Historical: items[0] == "BH", Received: received}
+		b.UnMarshall(items[1:], c.TimeLoc)
+		c.barsMu.Lock()
+		b.Interval = c.barIntervals[id]
+		c.barsMu.Unlock()
+		if loc := c.outputLoc(); loc != nil {
+			b.inLoc(loc)
+		}
+		if !c.divert("Bars", c.Bars, b) {
+			select {
+			case c.Bars <- b:
+			case <-c.stop:
+			}
+		}
+	case "n":
+		e := &ErrorMsg{Raw: string(d), Received: received}
+		e.UnMarshall(true, []byte(strings.Join(items[1:], ",")), 404)
+		if !c.divert("Errors", c.Errors, e) {
+			select {
+			case c.Errors <- e:
+			case <-c.stop:
+			}
+		}
+	case "E":
+		e := &ErrorMsg{Raw: string(d), Received: received}
+		e.UnMarshall(false, []byte(strings.Join(items[1:], ",")), 500)
+		if !c.divert("Errors", c.Errors, e) {
+			select {
+			case c.Errors <- e:
+			case <-c.stop:
+			}
+		}
+	}
+}
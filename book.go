@@ -0,0 +1,134 @@
+package iqfeed
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// PriceLevel is the aggregated size quoted at one price of a Book.
+type PriceLevel struct {
+	Price float64
+	Size  int // Total size of every market maker quoting the price.
+	Count int // Number of market makers quoting the price.
+}
+
+// bookEntry is the last bid and ask of one market maker (or price level for depth of market symbols).
+type bookEntry struct {
+	bid, ask         float64
+	bidSize, askSize int
+	bidTime, askTime time.Time
+	bidOK, askOK     bool
+}
+
+// Book rebuilds the order book of every symbol from the Level 2 messages on Depth, it is safe for concurrent use.
+// Each message replaces the bid and ask of its market maker, a side sent as not valid removes it from the book. A side older than the one already held is ignored so late messages can't roll the book back.
+type Book struct {
+	mu      sync.RWMutex
+	symbols map[string]map[string]*bookEntry
+}
+
+// NewBook returns an empty Book, feed it with Apply.
+func NewBook() *Book {
+	return &Book{symbols: make(map[string]map[string]*bookEntry)}
+}
+
+// Apply updates the book with a Level 2 message. A summary message replaces whatever was held for its market maker.
+func (b *Book) Apply(m *L2Msg) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	mms := b.symbols[m.Symbol]
+	if mms == nil {
+		mms = make(map[string]*bookEntry)
+		b.symbols[m.Symbol] = mms
+	}
+	e := mms[m.MarketMaker]
+	if e == nil || m.Summary {
+		e = &bookEntry{}
+		mms[m.MarketMaker] = e
+	}
+	if !m.BidTime.Before(e.bidTime) {
+		e.bid, e.bidSize, e.bidTime, e.bidOK = m.Bid, m.BidSize, m.BidTime, m.BidValid && m.BidSize > 0
+	}
+	if !m.AskTime.Before(e.askTime) {
+		e.ask, e.askSize, e.askTime, e.askOK = m.Ask, m.AskSize, m.AskTime, m.AskValid && m.AskSize > 0
+	}
+	if !e.bidOK && !e.askOK {
+		// Removing a level that doesn't exist is a no-op.
+		delete(mms, m.MarketMaker)
+	}
+}
+
+// Clear drops everything held for symbol, ex: after unwatching it with UnwatchL2.
+func (b *Book) Clear(symbol string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.symbols, symbol)
+}
+
+// Snapshot returns the price levels of symbol, bids from the highest price down and asks from the lowest price up.
+func (b *Book) Snapshot(symbol string) (bids, asks []PriceLevel) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	bidLevels := make(map[float64]*PriceLevel)
+	askLevels := make(map[float64]*PriceLevel)
+	for _, e := range b.symbols[symbol] {
+		if e.bidOK {
+			addLevel(bidLevels, e.bid, e.bidSize)
+		}
+		if e.askOK {
+			addLevel(askLevels, e.ask, e.askSize)
+		}
+	}
+	bids, asks = sortedLevels(bidLevels), sortedLevels(askLevels)
+	// Bids are best at the highest price.
+	for i, j := 0, len(bids)-1; i < j; i, j = i+1, j-1 {
+		bids[i], bids[j] = bids[j], bids[i]
+	}
+	return bids, asks
+}
+
+// Top returns the best n price levels of each side of symbol, as Snapshot orders them. All of them are returned when n is 0.
+func (b *Book) Top(symbol string, n int) (bids, asks []PriceLevel) {
+	bids, asks = b.Snapshot(symbol)
+	if n > 0 && len(bids) > n {
+		bids = bids[:n]
+	}
+	if n > 0 && len(asks) > n {
+		asks = asks[:n]
+	}
+	return bids, asks
+}
+
+// withinLevels reports whether the bid or ask of market maker mm is at one of the best n price levels of its side of symbol.
+func (b *Book) withinLevels(symbol, mm string, n int) bool {
+	bids, asks := b.Top(symbol, n)
+	b.mu.RLock()
+	e := b.symbols[symbol][mm]
+	b.mu.RUnlock()
+	if e == nil {
+		return false
+	}
+	return (e.bidOK && len(bids) > 0 && e.bid >= bids[len(bids)-1].Price) || (e.askOK && len(asks) > 0 && e.ask <= asks[len(asks)-1].Price)
+}
+
+// addLevel adds size at price to levels.
+func addLevel(levels map[float64]*PriceLevel, price float64, size int) {
+	l := levels[price]
+	if l == nil {
+		l = &PriceLevel{Price: price}
+		levels[price] = l
+	}
+	l.Size += size
+	l.Count++
+}
+
+// sortedLevels returns the levels by increasing price.
+func sortedLevels(levels map[float64]*PriceLevel) []PriceLevel {
+	out := make([]PriceLevel, 0, len(levels))
+	for _, l := range levels {
+		out = append(out, *l)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Price < out[j].Price })
+	return out
+}
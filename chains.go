@@ -0,0 +1,93 @@
+package iqfeed
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// RequestEquityOptionChain returns the option symbols for underlying from the lookup port (the CEO command), ready to be passed to WatchSymbol.
+// side is c for calls, p for puts or pc for both. month is a month name (ex: March or Mar) which is turned into the option month codes with OptionMonthCode, when it is empty the nearMonths closest expirations are returned instead.
+// When year is not 0 only contracts expiring in that year are returned.
+func (c *IQC) RequestEquityOptionChain(underlying string, side string, month string, year int, nearMonths int) ([]string, error) {
+	codes := ""
+	near := fmt.Sprintf("%d", nearMonths)
+	if month != "" {
+		m, err := parseMonth(month)
+		if err != nil {
+			return nil, err
+		}
+		if strings.Contains(side, "c") {
+			code, _ := OptionMonthCode(m, true)
+			codes += string(code)
+		}
+		if strings.Contains(side, "p") {
+			code, _ := OptionMonthCode(m, false)
+			codes += string(code)
+		}
+		near = ""
+	}
+	id := c.incr()
+	symbols, err := c.requestChain(fmt.Sprintf("CEO,%s,%s,%s,%s,0,,,,%s\r\n", underlying, side, codes, near, id), id)
+	if err != nil || year == 0 {
+		return symbols, err
+	}
+	// Option symbols are the root followed by the 2 digit expiration year and day, see WatchOptionSymbol.
+	yy := fmt.Sprintf("%02d", year%100)
+	var filtered []string
+	for _, s := range symbols {
+		if strings.HasPrefix(s, underlying+yy) {
+			filtered = append(filtered, s)
+		}
+	}
+	return filtered, nil
+}
+
+// parseMonth accepts a full or abbreviated English month name.
+func parseMonth(month string) (time.Month, error) {
+	for _, layout := range []string{"January", "Jan"} {
+		if t, err := time.Parse(layout, month); err == nil {
+			return t.Month(), nil
+		}
+	}
+	return 0, fmt.Errorf("iqfeed: invalid month %q", month)
+}
+
+// requestChain runs a chain lookup and returns the symbols listed before the terminator, the : separating calls from puts is dropped.
+func (c *IQC) requestChain(cmd, id string) ([]string, error) {
+	var symbols []string
+	err := c.lookup(cmd, id, func(items []string) error {
+		for _, s := range items {
+			if s != "" && s != ":" {
+				symbols = append(symbols, s)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return symbols, nil
+}
+
+// RequestFutureChain returns the futures contracts for underlying from the lookup port (the CFU command).
+// months is a string of futures month codes (ex: HMUZ), years a string of last digits of the years (ex: 67 for 2016 and 2017), when both are empty the nearMonths closest contracts are returned instead.
+func (c *IQC) RequestFutureChain(underlying string, months string, years string, nearMonths int) ([]string, error) {
+	id := c.incr()
+	return c.requestChain(fmt.Sprintf("CFU,%s,%s,%s,%s,%s\r\n", underlying, months, years, nearMonthsFilter(months, years, nearMonths), id), id)
+}
+
+// RequestFutureOptionChain returns the future option contracts for underlying from the lookup port (the CFO command), side is c for calls, p for puts or pc for both.
+// months uses the option month codes (calls A-L, puts M-X), years and nearMonths behave like RequestFutureChain.
+func (c *IQC) RequestFutureOptionChain(underlying string, side string, months string, years string, nearMonths int) ([]string, error) {
+	id := c.incr()
+	return c.requestChain(fmt.Sprintf("CFO,%s,%s,%s,%s,%s,%s\r\n", underlying, side, months, years, nearMonthsFilter(months, years, nearMonths), id), id)
+}
+
+// nearMonthsFilter returns the near months field, which IQFeed ignores unless it's the only filter given.
+func nearMonthsFilter(months, years string, nearMonths int) string {
+	if months != "" || years != "" {
+		return ""
+	}
+	return fmt.Sprintf("%d", nearMonths)
+}
@@ -0,0 +1,127 @@
+package iqfeed
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// TradeCondition is a single trade condition code from the Most Recent Trade Conditions field, the meaning of every code is available from RequestTradeConditions.
+type TradeCondition uint8
+
+// TradeConditionRegular is the condition of a normal trade.
+const TradeConditionRegular TradeCondition = 0x01
+
+// String returns the code in the 2 digit hex form IQFeed sends it in.
+func (t TradeCondition) String() string {
+	return fmt.Sprintf("%02X", uint8(t))
+}
+
+// parseTradeConditions decodes a Most Recent Trade Conditions field, up to 4 concatenated 2 digit hex codes. Malformed codes are skipped.
+func parseTradeConditions(v string) []TradeCondition {
+	if len(v) < 2 {
+		return nil
+	}
+	conds := make([]TradeCondition, 0, len(v)/2)
+	for i := 0; i+2 <= len(v); i += 2 {
+		n, err := strconv.ParseUint(v[i:i+2], 16, 8)
+		if err != nil {
+			continue
+		}
+		conds = append(conds, TradeCondition(n))
+	}
+	return conds
+}
+
+// MessageContents is the decoded Message Contents field of an update, every flag is set when its code is present.
+type MessageContents struct {
+	LastQualified bool // C - a last qualified trade.
+	Extended      bool // E - an extended (Form T) trade.
+	OtherTrade    bool // O - any trade not accounted for by C or E.
+	Bid           bool // b - a bid update.
+	Ask           bool // a - an ask update.
+	Open          bool // o - an open occurred.
+	High          bool // h - a high occurred.
+	Low           bool // l - a low occurred.
+	Close         bool // c - a close occurred.
+	Settlement    bool // s - a settlement occurred.
+	Volume        bool // v - a volume update.
+}
+
+// Trade reports whether the message was caused by a trade of any kind.
+func (m MessageContents) Trade() bool {
+	return m.LastQualified || m.Extended || m.OtherTrade
+}
+
+// Any reports whether any of the flags set in want are also set in m.
+func (m MessageContents) Any(want MessageContents) bool {
+	return m.LastQualified && want.LastQualified || m.Extended && want.Extended || m.OtherTrade && want.OtherTrade ||
+		m.Bid && want.Bid || m.Ask && want.Ask || m.Open && want.Open || m.High && want.High || m.Low && want.Low ||
+		m.Close && want.Close || m.Settlement && want.Settlement || m.Volume && want.Volume
+}
+
+// parseMessageContents decodes a Message Contents field, unknown codes are ignored.
+func parseMessageContents(v string) MessageContents {
+	var m MessageContents
+	for _, r := range v {
+		switch r {
+		case 'C':
+			m.LastQualified = true
+		case 'E':
+			m.Extended = true
+		case 'O':
+			m.OtherTrade = true
+		case 'b':
+			m.Bid = true
+		case 'a':
+			m.Ask = true
+		case 'o':
+			m.Open = true
+		case 'h':
+			m.High = true
+		case 'l':
+			m.Low = true
+		case 'c':
+			m.Close = true
+		case 's':
+			m.Settlement = true
+		case 'v':
+			m.Volume = true
+		}
+	}
+	return m
+}
+
+// TradeConditionInfo describes a trade condition code as returned by RequestTradeConditions.
+type TradeConditionInfo struct {
+	Condition   TradeCondition
+	Name        string // Short name of the condition (ex: REGULAR).
+	Description string // Long description of the condition.
+}
+
+// RequestTradeConditions returns the table of trade condition codes from the lookup port (the STC command).
+func (c *IQC) RequestTradeConditions() ([]TradeConditionInfo, error) {
+	id := c.incr()
+	var conds []TradeConditionInfo
+	err := c.lookup(fmt.Sprintf("STC,%s\r\n", id), id, func(items []string) error {
+		n, err := strconv.ParseUint(items[0], 16, 8)
+		if err != nil && len(items) > 1 {
+			// Newer protocols start the row with a two letter message marker.
+			items = items[1:]
+			n, err = strconv.ParseUint(items[0], 16, 8)
+		}
+		if err != nil {
+			return nil
+		}
+		for len(items) < 3 {
+			items = append(items, "")
+		}
+		// Descriptions may contain commas of their own.
+		conds = append(conds, TradeConditionInfo{Condition: TradeCondition(n), Name: items[1], Description: strings.Join(items[2:], ",")})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return conds, nil
+}
@@ -0,0 +1,86 @@
+package iqfeed
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// defaultBufferSize is the number of slots of every output channel when Config.BufferSize is not set.
+const defaultBufferSize = 1024
+
+// Config gathers the settings of a client started with NewClient, the zero value of every field is its documented default.
+// Settings not covered here can still be set on the returned IQC before it is used, like any other client.
+type Config struct {
+	Host           string         // Host running IQConnect, used by every port whose address doesn't name one, defaults to localhost.
+	Address        string         // Address of the IQFeed Level 1 port, defaults to port 5009 on Host.
+	TimeZone       string         // Timezone the feed's timestamps are interpreted in, defaults to America/New_York.
+	BufferSize     int            // Number of slots of every output channel, defaults to 1024.
+	Protocol       string         // Protocol version negotiated before anything else (ex: 6.2), the feed's default when empty.
+	Fields         []string       // Summary / update fields selected with SelectUpdateFields once started, the feed's current layout when empty.
+	StrictFields   bool           // See IQC.StrictFields.
+	TimestampsOff  bool           // Turn the once per second timestamp messages off, see DisableTimestamps.
+	NormalizeToUTC bool           // See IQC.NormalizeToUTC.
+	OutputLoc      *time.Location // See IQC.OutputLoc.
+	ConfirmTimeout time.Duration  // How long to wait for the feed to confirm commands, defaults to 5 seconds.
+	ClientName     string         // Name of the connection in IQConnect's diagnostics, see IQC.ClientName.
+
+	// Backup of every line received, see IQC.CreateBackup.
+	BackupFile      string // Write every line received to this file, no backup is made when empty.
+	MaxBackupBytes  int64  // Rotate BackupFile once it would grow past this size, 0 disables size based rotation.
+	RotateDaily     bool   // Rotate BackupFile when the day rolls over.
+	CompressBackups bool   // Gzip rotated backup files.
+
+	// Reconnection policy, see IQC.ReconnectEnabled.
+	Reconnect            bool          // Re-dial IQFeed when the connection is lost, replaying the field selection and watched symbols.
+	MaxReconnectAttempts int           // Give up reconnecting after this many failed attempts, 0 retries forever.
+	InitialBackoff       time.Duration // Delay before the first reconnection attempt, doubled after every attempt, defaults to 1 second.
+	MaxBackoff           time.Duration // Cap on the delay between reconnection attempts, defaults to 1 minute.
+
+	Logger    Logger // Receives the client's diagnostic messages, defaults to the standard log package.
+	LogErrors bool   // Log the messages sent on Errors with Logger instead of leaving them to the application, see IQC.LogErrors.
+}
+
+// NewClient configures a client from cfg and starts it like Start, selecting cfg.Fields once connected. It returns an error if the client can't be started or the field selection isn't confirmed, in which case the client is stopped.
+func NewClient(cfg Config) (*IQC, error) {
+	return NewClientContext(context.Background(), cfg)
+}
+
+// NewClientContext is NewClient bound to ctx, see StartContext.
+func NewClientContext(ctx context.Context, cfg Config) (*IQC, error) {
+	c := &IQC{
+		TimeZone:             cfg.TimeZone,
+		Host:                 cfg.Host,
+		TimestampsOff:        cfg.TimestampsOff,
+		NormalizeToUTC:       cfg.NormalizeToUTC,
+		OutputLoc:            cfg.OutputLoc,
+		ConfirmTimeout:       cfg.ConfirmTimeout,
+		StrictFields:         cfg.StrictFields,
+		ClientName:           cfg.ClientName,
+		CreateBackup:         cfg.BackupFile != "",
+		BackupFile:           cfg.BackupFile,
+		MaxBackupBytes:       cfg.MaxBackupBytes,
+		RotateDaily:          cfg.RotateDaily,
+		CompressBackups:      cfg.CompressBackups,
+		ReconnectEnabled:     cfg.Reconnect,
+		MaxReconnectAttempts: cfg.MaxReconnectAttempts,
+		InitialBackoff:       cfg.InitialBackoff,
+		MaxBackoff:           cfg.MaxBackoff,
+		Logger:               cfg.Logger,
+		LogErrors:            cfg.LogErrors,
+	}
+	size := cfg.BufferSize
+	if size <= 0 {
+		size = defaultBufferSize
+	}
+	if _, err := c.StartContext(ctx, cfg.Address, size, cfg.Protocol); err != nil {
+		return nil, err
+	}
+	if len(cfg.Fields) > 0 {
+		if err := c.SelectUpdateFields(cfg.Fields...); err != nil {
+			c.Stop()
+			return nil, fmt.Errorf("iqfeed: could not select the update fields: %w", err)
+		}
+	}
+	return c, nil
+}
@@ -0,0 +1,59 @@
+package iqfeed
+
+import (
+	"io"
+	"net"
+	"time"
+)
+
+// wrapConn returns conn as a net.Conn, wrapping it in a streamConn unless it is one already.
+func wrapConn(conn io.ReadWriteCloser) net.Conn {
+	if nc, ok := conn.(net.Conn); ok {
+		return nc
+	}
+	return &streamConn{rwc: conn, name: "stream"}
+}
+
+// streamConn adapts a plain io.ReadWriteCloser given to StartConn or ReplayFile to the net.Conn the client holds, deadlines are not supported.
+type streamConn struct {
+	rwc  io.ReadWriteCloser
+	name string // Reported as the address of both ends.
+}
+
+func (s *streamConn) Read(b []byte) (int, error)         { return s.rwc.Read(b) }
+func (s *streamConn) Write(b []byte) (int, error)        { return s.rwc.Write(b) }
+func (s *streamConn) Close() error                       { return s.rwc.Close() }
+func (s *streamConn) LocalAddr() net.Addr                { return streamAddr(s.name) }
+func (s *streamConn) RemoteAddr() net.Addr               { return streamAddr(s.name) }
+func (s *streamConn) SetDeadline(t time.Time) error      { return nil }
+func (s *streamConn) SetReadDeadline(t time.Time) error  { return nil }
+func (s *streamConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// splitStream joins a separate reader and writer given to StartStreams into one io.ReadWriteCloser.
+type splitStream struct {
+	r io.Reader
+	w io.Writer
+}
+
+func (s *splitStream) Read(b []byte) (int, error)  { return s.r.Read(b) }
+func (s *splitStream) Write(b []byte) (int, error) { return s.w.Write(b) }
+
+// Close closes the reader and the writer when they can be closed, so a read blocked on a pipe returns.
+func (s *splitStream) Close() error {
+	var err error
+	if c, ok := s.r.(io.Closer); ok {
+		err = c.Close()
+	}
+	if c, ok := s.w.(io.Closer); ok && interface{}(s.w) != interface{}(s.r) {
+		if werr := c.Close(); err == nil {
+			err = werr
+		}
+	}
+	return err
+}
+
+// streamAddr is the address reported by a streamConn, the path of the file being replayed or "stream".
+type streamAddr string
+
+func (a streamAddr) Network() string { return "stream" }
+func (a streamAddr) String() string  { return string(a) }
@@ -1,7 +1,9 @@
 package iqfeed
 
 import (
+	"encoding/csv"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -35,9 +37,50 @@ func GetTimeInHMSmicro(d string, loc *time.Location) time.Time {
 	return t
 }
 
-// GetDateMMDDCCYY returns a time object after parsing the MM/DD/CCYY layout in iqfeed.
+// splitFields splits a message into its comma separated fields. Fields may be quoted to contain commas of their own, a line with unbalanced quotes is split on every comma.
+func splitFields(d string) []string {
+	if !strings.Contains(d, `"`) {
+		return strings.Split(d, ",")
+	}
+	r := csv.NewReader(strings.NewReader(d))
+	r.LazyQuotes = true
+	r.FieldsPerRecord = -1
+	items, err := r.Read()
+	if err != nil {
+		return strings.Split(d, ",")
+	}
+	return items
+}
+
+// inLoc converts each of the given times to loc in place, keeping the instant they represent. Zero times are left as they are so IsZero still reports them.
+func inLoc(loc *time.Location, ts ...*time.Time) {
+	for _, t := range ts {
+		if !t.IsZero() {
+			*t = t.In(loc)
+		}
+	}
+}
+
+// inLocClock converts each of the given times to loc in place like inLoc, times of day parsed without a date are first placed on day so the offset in effect on that day (daylight saving included) is used.
+func inLocClock(day time.Time, loc *time.Location, ts ...*time.Time) {
+	y, m, d := day.Date()
+	for _, t := range ts {
+		if t.IsZero() {
+			continue
+		}
+		if t.Year() == 0 {
+			*t = time.Date(y, m, d, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+		}
+		*t = t.In(loc)
+	}
+}
+
+// GetDateMMDDCCYY returns a time object after parsing the MM/DD/CCYY layout in iqfeed, two digit years (MM/DD/YY) are accepted as well.
 func GetDateMMDDCCYY(d string, loc *time.Location) time.Time {
-	t, _ := time.ParseInLocation("01/02/06", d, loc)
+	t, err := time.ParseInLocation("01/02/2006", d, loc)
+	if err != nil {
+		t, _ = time.ParseInLocation("01/02/06", d, loc)
+	}
 
 	return t
 }
@@ -0,0 +1,79 @@
+package iqfeed
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+)
+
+// Decimal is an exact decimal number, Units scaled down by 10^Scale (ex: 95.015625 is {95015625, 6}). Use it instead of the float64 fields where rounding matters.
+type Decimal struct {
+	Units int64
+	Scale int
+}
+
+// ParseDecimal parses a price as sent by IQFeed (an optional sign, digits and an optional fraction) into an exact Decimal.
+func ParseDecimal(s string) (Decimal, error) {
+	var d Decimal
+	v := strings.TrimSpace(s)
+	neg := strings.HasPrefix(v, "-")
+	v = strings.TrimLeft(v, "+-")
+	whole, frac := v, ""
+	if i := strings.IndexByte(v, '.'); i >= 0 {
+		whole, frac = v[:i], v[i+1:]
+	}
+	if whole == "" && frac == "" {
+		return d, fmt.Errorf("iqfeed: invalid decimal %q", s)
+	}
+	for _, r := range whole + frac {
+		if r < '0' || r > '9' {
+			return d, fmt.Errorf("iqfeed: invalid decimal %q", s)
+		}
+		if d.Units > (math.MaxInt64-9)/10 {
+			return d, fmt.Errorf("iqfeed: decimal %q out of range", s)
+		}
+		d.Units = d.Units*10 + int64(r-'0')
+	}
+	d.Scale = len(frac)
+	if neg {
+		d.Units = -d.Units
+	}
+	return d, nil
+}
+
+// Float64 returns the nearest float64 to the decimal.
+func (d Decimal) Float64() float64 {
+	f, _ := d.Rat().Float64()
+	return f
+}
+
+// Rat returns the decimal as an exact rational number.
+func (d Decimal) Rat() *big.Rat {
+	den := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(d.Scale)), nil)
+	return new(big.Rat).SetFrac(big.NewInt(d.Units), den)
+}
+
+// String formats the decimal with its Scale digits after the point.
+func (d Decimal) String() string {
+	return d.Rat().FloatString(d.Scale)
+}
+
+// RawValue returns the named field (ex: "Bid") exactly as it was sent, the boolean is false when the field isn't part of the layout the message was parsed with.
+func (u *UpdSummaryMsg) RawValue(name string) (string, bool) {
+	for i, n := range u.fields {
+		if n == name && i < len(u.Raw) {
+			return u.Raw[i], true
+		}
+	}
+	return "", false
+}
+
+// DecimalValue parses the named price field (ex: "Bid") exactly, without the rounding of the float64 fields.
+func (u *UpdSummaryMsg) DecimalValue(name string) (Decimal, error) {
+	v, ok := u.RawValue(name)
+	if !ok {
+		return Decimal{}, fmt.Errorf("iqfeed: field %s not in the update: %w", name, ErrUnknownField)
+	}
+	return ParseDecimal(v)
+}
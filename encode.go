@@ -0,0 +1,85 @@
+package iqfeed
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+)
+
+// FieldNames returns the names of the fields the message was parsed with, in the order of the layout. It is nil for a message that wasn't parsed by the client.
+func (u *UpdSummaryMsg) FieldNames() []string {
+	names := make([]string, 0, len(u.fields))
+	for i := 0; i < len(u.fields); i++ {
+		names = append(names, u.fields[i])
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	return names
+}
+
+// MarshalCSV returns the named fields of the message exactly as they were sent as a single CSV record terminated by a newline, fields may be nil for every field of the layout (see FieldNames).
+// Fields that aren't part of the layout are left empty so every record written with the same fields has the same columns.
+func (u *UpdSummaryMsg) MarshalCSV(fields []string) ([]byte, error) {
+	if fields == nil {
+		fields = u.FieldNames()
+	}
+	record := make([]string, len(fields))
+	for i, name := range fields {
+		record[i], _ = u.RawValue(name)
+	}
+	var b bytes.Buffer
+	w := csv.NewWriter(&b)
+	if err := w.Write(record); err != nil {
+		return nil, err
+	}
+	w.Flush()
+	return b.Bytes(), w.Error()
+}
+
+// MarshalJSON encodes the message as a JSON object with one member per field of the layout, in the layout's order and keyed by field name (ex: {"Symbol":"AAPL","Bid":95.01}).
+// Values that are valid JSON numbers are written as numbers exactly as sent, empty fields as null and everything else (times, dates, codes with leading zeros) as strings.
+// A message that wasn't parsed by the client has no layout and is encoded with its struct fields instead.
+func (u *UpdSummaryMsg) MarshalJSON() ([]byte, error) {
+	names := u.FieldNames()
+	if names == nil {
+		type plain UpdSummaryMsg
+		return json.Marshal((*plain)(u))
+	}
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		key, err := json.Marshal(name)
+		if err != nil {
+			return nil, err
+		}
+		b.Write(key)
+		b.WriteByte(':')
+		v, _ := u.RawValue(name)
+		switch {
+		case v == "":
+			b.WriteString("null")
+		case isJSONNumber(v):
+			b.WriteString(v)
+		default:
+			s, err := json.Marshal(v)
+			if err != nil {
+				return nil, err
+			}
+			b.Write(s)
+		}
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+// isJSONNumber reports whether v can be written as a JSON number as it is.
+func isJSONNumber(v string) bool {
+	if v[0] != '-' && (v[0] < '0' || v[0] > '9') {
+		return false
+	}
+	return json.Valid([]byte(v))
+}
@@ -1,20 +1,129 @@
 package iqfeed
 
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Sentinel errors that an ErrorMsg (or any error returned by the client) can be matched against with errors.Is.
+var (
+	ErrSymbolNotFound        = errors.New("iqfeed: symbol not found")
+	ErrNotAuthorized         = errors.New("iqfeed: not authorized")
+	ErrServerDisconnected    = errors.New("iqfeed: server disconnected")
+	ErrServerReconnectFailed = errors.New("iqfeed: server reconnect failed")
+	ErrSyntaxError           = errors.New("iqfeed: syntax error")
+	ErrTimeout               = errors.New("iqfeed: timed out waiting for the feed")
+	ErrUnknownField          = errors.New("iqfeed: unknown update field")
+	ErrNoData                = errors.New("iqfeed: no data")
+	ErrClientStopped         = errors.New("iqfeed: client stopped")
+	ErrNotStarted            = errors.New("iqfeed: client not started")
+	ErrSymbolLimit           = errors.New("iqfeed: symbol limit reached")
+	ErrMalformedMessage      = errors.New("iqfeed: malformed message")
+	ErrTruncated             = errors.New("iqfeed: more data available than requested")
+	ErrIQConnectNotRunning   = errors.New("iqfeed: IQConnect is not running")
+	ErrInvalidSymbol         = errors.New("iqfeed: invalid symbol")
+	ErrFieldLayout           = errors.New("iqfeed: message doesn't match the update field layout")
+)
+
 // ErrorMsg contains error messages reported to the client including symbol not found messages
 type ErrorMsg struct {
-	Symbol  string // Symbol is set on 404 messages to indicate the missing symbol
-	Message string // The error message
-	Code    int    // The http status representation of the error.
+	Symbol    string // Symbol is set on 404 messages to indicate the missing symbol
+	Message   string // The error message
+	Code      int    // The http status representation of the error.
+	Err       error  // The sentinel error this message was classified as, nil when the message is not recognised.
+	Command   string // For syntax errors, the last command written to the feed which is the one IQFeed rejected.
+	Raw       string // The line as received from the feed, empty for errors raised by the client itself.
+	RequestID string // For lookup errors, the id the request was tagged with on the lookup port.
+
+	Received
 }
 
 // UnMarshall sends the data into the usable struct for consumption by the application.
+// For not found messages d is the symbol, otherwise the error text which IQFeed may end with an empty field.
 func (e *ErrorMsg) UnMarshall(notFound bool, d []byte, code int) {
 	if notFound {
-		e.Symbol = string(d)
+		e.Symbol = strings.TrimSuffix(string(d), ",")
 		e.Code = 404
 		e.Message = "Symbol not found"
+		e.Err = ErrSymbolNotFound
 		return
 	}
 	e.Code = 500
-	e.Message = string(d)
+	e.Message = strings.TrimSuffix(string(d), ",")
+	e.Err = classifyError(e.Message)
+}
+
+// Error implements the error interface so an ErrorMsg can be returned and wrapped like any other Go error.
+func (e *ErrorMsg) Error() string {
+	if e.Command != "" && e.RequestID != "" {
+		return fmt.Sprintf("iqfeed: %s: %q (request %s)", e.Message, e.Command, e.RequestID)
+	}
+	if e.Command != "" {
+		return fmt.Sprintf("iqfeed: %s: %q", e.Message, e.Command)
+	}
+	if e.Symbol != "" {
+		return fmt.Sprintf("iqfeed: %s: %s", e.Message, e.Symbol)
+	}
+	return "iqfeed: " + e.Message
+}
+
+// Unwrap exposes the classified sentinel error so errors.Is and errors.As work against the package sentinels.
+func (e *ErrorMsg) Unwrap() error {
+	return e.Err
+}
+
+// DrainErrors starts reading Errors in the background and logs every message with Logger until the client is stopped, so an application that has no use for them can't stall the feed by leaving Errors to fill up.
+// Call it once the client has been started, or set LogErrors to have Start call it. Nothing should read Errors besides it, and it does nothing when UnifiedMessages is set as errors are sent on Messages then.
+func (c *IQC) DrainErrors() {
+	errs := c.Errors
+	if errs == nil || c.UnifiedMessages {
+		return
+	}
+	go func() {
+		for e := range errs {
+			c.log().Warnf("%s", e)
+		}
+	}()
+}
+
+// classifyError maps the free form error text sent by IQFeed to one of the package sentinel errors.
+func classifyError(msg string) error {
+	m := strings.ToUpper(msg)
+	switch {
+	case strings.Contains(m, "!SYNTAX_ERROR!"):
+		return ErrSyntaxError
+	case strings.Contains(m, "!NO_DATA!"):
+		return ErrNoData
+	case strings.Contains(m, "NOT FOUND"):
+		return ErrSymbolNotFound
+	case strings.Contains(m, "NOT AUTHORIZED"):
+		return ErrNotAuthorized
+	case strings.Contains(m, "SERVER DISCONNECTED"):
+		return ErrServerDisconnected
+	case strings.Contains(m, "SERVER RECONNECT FAILED"):
+		return ErrServerReconnectFailed
+	case strings.Contains(m, "SYMBOL LIMIT REACHED"):
+		return ErrSymbolLimit
+	}
+	return nil
+}
+
+// commandSymbol returns the symbol a command written to the feed applies to, or an empty string for commands that aren't about a single symbol.
+func commandSymbol(cmd string) string {
+	if strings.HasPrefix(cmd, "S,") {
+		items := strings.Split(cmd, ",")
+		if len(items) == 3 && (items[1] == "REGON" || items[1] == "REGOFF") {
+			return items[2]
+		}
+		return ""
+	}
+	if len(cmd) < 2 || strings.Contains(cmd, ",") {
+		return ""
+	}
+	switch cmd[0] {
+	case 'w', 't', 'r', 'f':
+		return cmd[1:]
+	}
+	return ""
 }
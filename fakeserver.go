@@ -0,0 +1,293 @@
+package iqfeed
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"sync"
+)
+
+// defaultFakeFields is the field layout a FakeServer starts with, the default layout of IQFeed's protocol 6.
+var defaultFakeFields = []string{"Symbol", "Most Recent Trade", "Most Recent Trade Size", "Most Recent Trade TimeMS", "Most Recent Trade Market Center", "Total Volume",
+	"Bid", "Bid Size", "Ask", "Ask Size", "Open", "High", "Low", "Close", "Message Contents", "Most Recent Trade Conditions"}
+
+// lookupIDField is the position of the request id in the lookup commands that send more fields after it, every other command ends with it.
+var lookupIDField = map[string]int{"HIX": 5, "HID": 8, "HIT": 9}
+
+// FakeServer stands in for IQConnect in tests, it listens on a Level 1 and a lookup port on the loopback interface and answers the client with scripted lines, so code using IQC can be tested without IQFeed.
+// On the Level 1 port it sends Handshake to every new connection and answers the field name, field selection and protocol commands like IQFeed does, a watched symbol gets the lines scripted with Watch or a not found message.
+// On the lookup port the rows scripted with HandleLookup are tagged with the request id and terminated with !ENDMSG!, requests nothing was scripted for are answered with !NO_DATA!.
+// Start a client on it with c.Start(s.Addr(), n) after setting c.LookupAddress to s.LookupAddr(). Scripts may be changed at any time, they apply to the commands received afterwards.
+type FakeServer struct {
+	Handshake []string // Lines sent to every new Level 1 connection, a server connected message and a real time account by default. Set it before the client connects.
+
+	level1, lookup net.Listener
+	wg             sync.WaitGroup
+
+	mu       sync.Mutex
+	fields   []string
+	handlers map[string][]string
+	watches  map[string][]string
+	lookups  []fakeLookup
+	conns    map[*fakeConn]bool
+	commands []string
+	requests []string
+}
+
+// fakeLookup is the answer scripted by HandleLookup for the lookup commands starting with prefix.
+type fakeLookup struct {
+	prefix string
+	rows   []string
+	err    string
+}
+
+// fakeConn is a connection to a FakeServer, writes are serialized so scripted answers and Send can't interleave.
+type fakeConn struct {
+	mu     sync.Mutex
+	conn   net.Conn
+	level1 bool
+}
+
+// write sends lines on the connection with the IQFeed line terminator.
+func (f *fakeConn) write(lines ...string) {
+	if len(lines) == 0 {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.conn.Write([]byte(strings.Join(lines, "\r\n") + "\r\n"))
+}
+
+// NewFakeServer starts a FakeServer listening on free ports of 127.0.0.1, Close stops it.
+func NewFakeServer() (*FakeServer, error) {
+	level1, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	lookup, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		level1.Close()
+		return nil, err
+	}
+	s := &FakeServer{
+		Handshake: []string{"S,SERVER CONNECTED", "S,CUST,real_time,127.0.0.1,60002,0,6.1.0.20,0,AMEX NASDAQ NYSE OPRA ,,500,QT_API,,"},
+		level1:    level1,
+		lookup:    lookup,
+		fields:    defaultFakeFields,
+		handlers:  make(map[string][]string),
+		watches:   make(map[string][]string),
+		conns:     make(map[*fakeConn]bool),
+	}
+	s.wg.Add(2)
+	go s.accept(level1, true)
+	go s.accept(lookup, false)
+	return s, nil
+}
+
+// Addr returns the address of the Level 1 port, to be given to Start.
+func (s *FakeServer) Addr() string {
+	return s.level1.Addr().String()
+}
+
+// LookupAddr returns the address of the lookup port, to be set as LookupAddress.
+func (s *FakeServer) LookupAddr() string {
+	return s.lookup.Addr().String()
+}
+
+// Close stops listening and closes every connection, it returns once they have all been served.
+func (s *FakeServer) Close() error {
+	err := s.level1.Close()
+	s.lookup.Close()
+	s.mu.Lock()
+	for c := range s.conns {
+		c.conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return err
+}
+
+// Drop closes the Level 1 connections as IQConnect going away would, the server keeps listening so a client can reconnect.
+func (s *FakeServer) Drop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.conns {
+		if c.level1 {
+			c.conn.Close()
+		}
+	}
+}
+
+// SetFields replaces the field layout sent as the current update field names. A field selection made by the client replaces it as well.
+func (s *FakeServer) SetFields(fields ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fields = append([]string{"Symbol"}, fields...)
+}
+
+// Handle scripts the lines sent back when the Level 1 port receives cmd, given without its line terminator (ex: S,REQUEST LISTED MARKETS). It replaces the built in answer to the command if there is one.
+func (s *FakeServer) Handle(cmd string, lines ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[cmd] = lines
+}
+
+// Watch scripts the lines sent back when symbol is watched, in full or trades only (ex: its fundamental and summary messages).
+func (s *FakeServer) Watch(symbol string, lines ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.watches[symbol] = lines
+}
+
+// Send writes lines to every Level 1 connection, ex: update messages for a watched symbol.
+func (s *FakeServer) Send(lines ...string) {
+	s.mu.Lock()
+	conns := make([]*fakeConn, 0, len(s.conns))
+	for c := range s.conns {
+		if c.level1 {
+			conns = append(conns, c)
+		}
+	}
+	s.mu.Unlock()
+	for _, c := range conns {
+		c.write(lines...)
+	}
+}
+
+// HandleLookup scripts the rows answering the lookup commands starting with prefix (ex: HTX,AAPL), without the request id which is added to each of them. Later scripts take precedence over earlier ones.
+func (s *FakeServer) HandleLookup(prefix string, rows ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lookups = append(s.lookups, fakeLookup{prefix: prefix, rows: rows})
+}
+
+// LookupError scripts the lookup commands starting with prefix to be answered with an error, ex: !SYNTAX_ERROR! or Invalid symbol.
+func (s *FakeServer) LookupError(prefix, msg string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lookups = append(s.lookups, fakeLookup{prefix: prefix, err: msg})
+}
+
+// Commands returns the commands received on the Level 1 port so far, in order and without their line terminator.
+func (s *FakeServer) Commands() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.commands...)
+}
+
+// Lookups returns the commands received on the lookup port so far, in order and without their line terminator.
+func (s *FakeServer) Lookups() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.requests...)
+}
+
+// accept serves every connection made to l until it is closed.
+func (s *FakeServer) accept(l net.Listener, level1 bool) {
+	defer s.wg.Done()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		c := &fakeConn{conn: conn, level1: level1}
+		s.mu.Lock()
+		s.conns[c] = true
+		s.mu.Unlock()
+		s.wg.Add(1)
+		go s.serve(c)
+	}
+}
+
+// serve reads the commands sent on c until it is closed, a Level 1 connection is sent the handshake first.
+func (s *FakeServer) serve(c *fakeConn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.mu.Unlock()
+		c.conn.Close()
+	}()
+	if c.level1 {
+		s.mu.Lock()
+		handshake := s.Handshake
+		s.mu.Unlock()
+		c.write(handshake...)
+	}
+	r := bufio.NewReader(c.conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.TrimRight(line, "\r\n")
+		switch {
+		case cmd == "":
+		case c.level1:
+			s.serveLevel1(c, cmd)
+		default:
+			s.serveLookup(c, cmd)
+		}
+	}
+}
+
+// serveLevel1 answers a command received on the Level 1 port.
+func (s *FakeServer) serveLevel1(c *fakeConn, cmd string) {
+	s.mu.Lock()
+	s.commands = append(s.commands, cmd)
+	lines, scripted := s.handlers[cmd]
+	if !scripted {
+		lines = s.level1Answer(cmd)
+	}
+	s.mu.Unlock()
+	c.write(lines...)
+}
+
+// level1Answer returns IQFeed's answer to a Level 1 command that wasn't scripted with Handle, s.mu must be held.
+func (s *FakeServer) level1Answer(cmd string) []string {
+	switch {
+	case cmd == "S,REQUEST CURRENT UPDATE FIELDNAMES":
+		return []string{"S,CURRENT UPDATE FIELDNAMES," + strings.Join(s.fields, ",")}
+	case strings.HasPrefix(cmd, "S,SELECT UPDATE FIELDS,"):
+		s.fields = append([]string{"Symbol"}, strings.Split(strings.TrimPrefix(cmd, "S,SELECT UPDATE FIELDS,"), ",")...)
+		return []string{"S,CURRENT UPDATE FIELDNAMES," + strings.Join(s.fields, ",")}
+	case strings.HasPrefix(cmd, "S,SET PROTOCOL,"):
+		return []string{"S,CURRENT PROTOCOL," + strings.TrimPrefix(cmd, "S,SET PROTOCOL,")}
+	case strings.HasPrefix(cmd, "S,"):
+		return nil
+	case cmd[0] == 'w' || cmd[0] == 't':
+		if lines, ok := s.watches[cmd[1:]]; ok {
+			return lines
+		}
+		return []string{"n," + cmd[1:]}
+	}
+	return nil
+}
+
+// serveLookup answers a request received on the lookup port.
+func (s *FakeServer) serveLookup(c *fakeConn, cmd string) {
+	fields := strings.Split(cmd, ",")
+	id := fields[len(fields)-1]
+	if i, ok := lookupIDField[fields[0]]; ok && i < len(fields) {
+		id = fields[i]
+	}
+	s.mu.Lock()
+	s.requests = append(s.requests, cmd)
+	answer := fakeLookup{err: "!NO_DATA!"}
+	for i := len(s.lookups) - 1; i >= 0; i-- {
+		if strings.HasPrefix(cmd, s.lookups[i].prefix) {
+			answer = s.lookups[i]
+			break
+		}
+	}
+	s.mu.Unlock()
+
+	lines := make([]string, 0, len(answer.rows)+2)
+	for _, row := range answer.rows {
+		lines = append(lines, id+","+row)
+	}
+	if answer.err != "" {
+		lines = append(lines, id+",E,"+answer.err+",")
+	}
+	c.write(append(lines, id+",!ENDMSG!,")...)
+}
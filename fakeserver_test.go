@@ -0,0 +1,141 @@
+package iqfeed
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeClient starts a client on a new FakeServer, both are stopped when the test ends.
+func fakeClient(t *testing.T, c *IQC, protocol ...string) (*IQC, *FakeServer) {
+	t.Helper()
+	s, err := NewFakeServer()
+	if err != nil {
+		t.Skipf("cannot listen: %s", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	c.TimeZone = "UTC"
+	c.LookupAddress = s.LookupAddr()
+	if _, err := c.Start(s.Addr(), 16, protocol...); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Stop() })
+	return c, s
+}
+
+// waitCommands waits for the server to have received cmd n times.
+func waitCommands(t *testing.T, s *FakeServer, cmd string, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got := 0
+		for _, c := range s.Commands() {
+			if c == cmd {
+				got++
+			}
+		}
+		if got >= n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%q received %d times, expected %d: %q", cmd, got, n, s.Commands())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestFakeServerHandshake(t *testing.T) {
+	c, s := fakeClient(t, &IQC{}, "6.2")
+	for _, want := range []string{"SERVER CONNECTED", "CUST", "CURRENT PROTOCOL"} {
+		if m := <-c.System; m.Type != want {
+			t.Fatalf("expected a %s message, got %+v", want, m)
+		}
+	}
+
+	if err := c.SelectUpdateFields("Most Recent Trade", "Bid", "Ask"); err != nil {
+		t.Fatal(err)
+	}
+	s.Watch("AAPL", "P,AAPL,95.02,95.01,95.03")
+	if err := c.WatchSymbol("AAPL"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case u := <-c.Updates:
+		if u.Symbol != "AAPL" || u.MostRecentTrade != 95.02 || u.Bid != 95.01 || u.Ask != 95.03 {
+			t.Errorf("summary parsed against the selected fields = %+v", u)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no summary for the scripted watch")
+	}
+	s.Send("Q,AAPL,95.04,95.03,95.05")
+	select {
+	case u := <-c.Updates:
+		if u.MostRecentTrade != 95.04 {
+			t.Errorf("sent update = %+v", u)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no update for the sent line")
+	}
+
+	// A symbol nothing was scripted for isn't found.
+	c.WatchSymbol("ZZZZ")
+	select {
+	case e := <-c.Errors:
+		if !errors.Is(e, ErrSymbolNotFound) || e.Symbol != "ZZZZ" {
+			t.Errorf("unexpected error %+v", e)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no error for the unknown symbol")
+	}
+
+	got := strings.Join(s.Commands(), "|")
+	if want := "S,SET PROTOCOL,6.2|S,REQUEST CURRENT UPDATE FIELDNAMES|S,SELECT UPDATE FIELDS,Most Recent Trade,Bid,Ask|wAAPL|wZZZZ"; got != want {
+		t.Errorf("commands = %q, want %q", got, want)
+	}
+}
+
+func TestFakeServerLookups(t *testing.T) {
+	c, s := fakeClient(t, &IQC{})
+	s.HandleLookup("HTX,AAPL,",
+		"LH,2016-03-14 09:30:00.123456,95.02,100,1000,95.01,95.03,42,C,11,,",
+		"LH,2016-03-14 09:30:01,95.04,200,1200,95.03,95.05,43,E,11,,",
+	)
+	ticks, err := c.RequestTickData("AAPL", 2)
+	if err != nil || len(ticks) != 2 || ticks[1].Last != 95.04 {
+		t.Fatalf("unexpected ticks %+v, %v", ticks, err)
+	}
+
+	s.LookupError("HTX,BAD,", "Invalid symbol")
+	if _, err := c.RequestTickData("BAD", 2); err == nil || !strings.Contains(err.Error(), "Invalid symbol") {
+		t.Errorf("expected the scripted error, got %v", err)
+	}
+	// Requests nothing was scripted for have no data.
+	if _, err := c.RequestTickData("NONE", 2); !errors.Is(err, ErrNoData) {
+		t.Errorf("expected ErrNoData, got %v", err)
+	}
+
+	// The id of interval requests isn't their last field when the interval type follows it.
+	s.HandleLookup("HIX,AAPL,100,", "LH,2016-03-14 09:35:00,95.10,94.50,94.80,95.00,100000,5000,120,")
+	bars, err := c.Interval("AAPL").Volume(100).Max(1).Do()
+	if err != nil || len(bars) != 1 || bars[0].High != 95.10 {
+		t.Errorf("unexpected bars %+v, %v", bars, err)
+	}
+	if got := s.Lookups(); len(got) != 4 || !strings.HasPrefix(got[3], "HIX,AAPL,100,1,,") {
+		t.Errorf("lookups = %q", got)
+	}
+}
+
+func TestFakeServerDrop(t *testing.T) {
+	c, s := fakeClient(t, &IQC{ReconnectEnabled: true, InitialBackoff: 10 * time.Millisecond})
+	// Scripted so the symbol isn't reported as not found, which would drop the watch.
+	s.Watch("AAPL", "P,AAPL,95.02")
+	if err := c.WatchSymbol("AAPL"); err != nil {
+		t.Fatal(err)
+	}
+	waitCommands(t, s, "wAAPL", 1)
+	s.Drop()
+	// The watch is replayed on the new connection.
+	waitCommands(t, s, "wAAPL", 2)
+	waitCommands(t, s, "S,REQUEST CURRENT UPDATE FIELDNAMES", 2)
+}
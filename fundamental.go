@@ -45,7 +45,7 @@ type FundamentalMsg struct {
 	SplitFactor2       string    // A float a space, then MM/DD/YYYY
 	Reserved7          string    // Reserved field.
 	Reserved8          string    // Reserved field.
-	FormatCode         string    // Display format code, See: Price Format Codes http://www.iqfeed.net/dev/api/docs/PriceFormatCodes.cfm.
+	FormatCode         int       // Display format code, See: Price Format Codes http://www.iqfeed.net/dev/api/docs/PriceFormatCodes.cfm.
 	Precision          int       // Number of decimal digits.
 	SIC                int       // Federally designed numbering system identifying companies by industry. This 4 digit number corresponds to a specific industry.
 	HistVolatility     float64   // 30-trading day volatility that it is calculated using Black-Scholes (https://en.wikipedia.org/wiki/Black%E2%80%93Scholes_model).
@@ -62,11 +62,28 @@ type FundamentalMsg struct {
 	StrikePrice        float64   // IEOptions only
 	NAICS              int       // North American Industry Classification System (http://www.census.gov/eos/www/naics/)
 	ExchangeRoot       string    // The root symbol that you can find this symbol listed under at the exchange.
+	Raw                []string  // Every field of the message as sent, including the reserved ones and any the layout above doesn't map.
+	Refreshed          bool      // Set on the message answering RefreshFundamental, false for the one sent when the symbol was watched.
+
+	loc    *time.Location // The location the message was parsed in, the client's TimeLoc for messages from the feed.
+	outLoc *time.Location // The location inLoc converted the dates to, nil when they weren't converted.
+
+	Received
 }
 
+// fundamentalFields is the number of fields in the fundamental message layout mapped by UnMarshall.
+const fundamentalFields = 55
+
 // UnMarshall sends the data into the usable struct for consumption by the application.
+// Quoted fields, such as a company name containing a comma, are kept whole.
 func (f *FundamentalMsg) UnMarshall(d []byte, loc *time.Location) {
-	items := strings.Split(string(d), ",")
+	items := splitFields(string(d))
+	f.Raw = items
+	f.loc, f.outLoc = loc, nil
+	// Pad out short messages so a truncated line leaves the trailing fields empty rather than panicking.
+	if len(items) < fundamentalFields {
+		items = append(append([]string(nil), items...), make([]string, fundamentalFields-len(items))...)
+	}
 	f.Symbol = items[0]                                  // APL,
 	f.ExchaangeID = items[1]                             // 5,
 	f.PE = GetFloatFromStr(items[2])                     // 9.9,
@@ -105,7 +122,7 @@ func (f *FundamentalMsg) UnMarshall(d []byte, loc *time.Location) {
 	f.SplitFactor2 = items[35]                           // 0.50 02/28/2005,
 	f.Reserved7 = items[36]                              // ,
 	f.Reserved8 = items[37]                              // 0,
-	f.FormatCode = items[38]                             // 14,
+	f.FormatCode = GetIntFromStr(items[38])              // 14,
 	f.Precision = GetIntFromStr(items[39])               // 4,
 	f.SIC = GetIntFromStr(items[40])                     // 3571,
 	f.HistVolatility = GetFloatFromStr(items[41])        // 36.98,
@@ -123,3 +140,67 @@ func (f *FundamentalMsg) UnMarshall(d []byte, loc *time.Location) {
 	f.NAICS = GetIntFromStr(items[53])                   // 334220,
 	f.ExchangeRoot = items[54]                           // ,
 }
+
+// SplitEvent is a stock split reported in a fundamental message.
+type SplitEvent struct {
+	Date   time.Time // Date the split took effect.
+	Factor float64   // Split factor, the number of post split shares per pre split share inverted (ex: 0.50 for a 2 for 1 split).
+}
+
+// ParseSplitFactor parses a split factor field which is a float a space, then MM/DD/YYYY. The boolean is false when the field is empty or malformed.
+func ParseSplitFactor(d string, loc *time.Location) (SplitEvent, bool) {
+	parts := strings.Fields(d)
+	if len(parts) != 2 {
+		return SplitEvent{}, false
+	}
+	t, err := time.ParseInLocation("01/02/2006", parts[1], loc)
+	if err != nil {
+		return SplitEvent{}, false
+	}
+	return SplitEvent{Date: t, Factor: GetFloatFromStr(parts[0])}, true
+}
+
+// Splits returns the split history carried by the fundamental message (IQFeed sends the two most recent splits), most recent first. The dates are parsed in the location the message was, the client's TimeLoc, and converted like the other dates of the message when OutputLoc or NormalizeToUTC is set.
+// IQFeed's lookup port has no split history or earnings date request, so these two splits are all the feed provides and there is no EarningsDates counterpart.
+func (f *FundamentalMsg) Splits() []SplitEvent {
+	loc := f.loc
+	if loc == nil {
+		loc = time.UTC
+	}
+	var splits []SplitEvent
+	for _, sf := range []string{f.SplitFactor1, f.SplitFactor2} {
+		if s, ok := ParseSplitFactor(sf, loc); ok {
+			if f.outLoc != nil {
+				s.Date = s.Date.In(f.outLoc)
+			}
+			splits = append(splits, s)
+		}
+	}
+	return splits
+}
+
+// DisplayPrecision returns the number of decimal digits prices for the symbol should be shown with, falling back to the usual precision for its asset class when the feed doesn't send one.
+func (f *FundamentalMsg) DisplayPrecision() int {
+	if f.Precision > 0 {
+		return f.Precision
+	}
+	return f.AssetClass().DefaultPrecision()
+}
+
+// PriceFormat returns the display format code of the symbol as a PriceFormat.
+func (f *FundamentalMsg) PriceFormat() PriceFormat {
+	return PriceFormat(f.FormatCode)
+}
+
+// AssetClass returns the security type of the symbol as an AssetClass.
+func (f *FundamentalMsg) AssetClass() AssetClass {
+	return AssetClass(GetIntFromStr(f.SecurityType))
+}
+
+// inLoc converts every date on the message to loc.
+func (f *FundamentalMsg) inLoc(loc *time.Location) {
+	inLoc(loc, &f.PayDate, &f.ExDivDate, &f.BalSheetDate, &f.Fifty2WkHighDate, &f.Fifty2WkLowDate, &f.CalYearHighDate,
+		&f.CalYearLowDate, &f.MaturityDate, &f.ExpirationDate)
+	// The split dates are parsed by Splits, they are converted there.
+	f.outLoc = loc
+}
@@ -0,0 +1,213 @@
+package iqfeed
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Fundamental message fixtures, without the leading F, prefix.
+const (
+	equityFundamental = "AAPL,5,9.9,53599000,134.5400,92.0000,105.8500,92.3900,2.2100,0.5200,2.0800,02/11/2016,02/04/2016,,,,63543520,,9.46,,0.34,09,,APPLE,AAPL AAPL7,67.1,1.35,,89378.0,80610.0,12/31/2015,53463.0,5544583,334220,0.14 06/09/2014,0.50 02/28/2005,,0,14,4,3571,36.98,1,21,04/28/2015,08/24/2015,01/05/2016,01/28/2016,105.26,,,,,334220,,"
+	futureFundamental = "@ESM16,34,,,2134.75,1804.00,2045.25,1804.00,,,,,,,,,,,,,,,,E-MINI S&P 500 JUNE 2016,,,,,,,,,,,,,,0,12,2,,,8,34,11/03/2015,02/11/2016,01/04/2016,02/11/2016,,,,06/17/2016,,,ES,"
+	forexFundamental  = "EURUSD.FXCM,74,,,1.1495,1.0516,1.1376,1.0711,,,,,,,,,,,,,,,,EURO/US DOLLAR,,,,,,,,,,,,,,0,15,,,,16,74,,,,,,,,,,,EURUSD,"
+)
+
+func TestFundamentalFormatAndAssetClass(t *testing.T) {
+	for _, tc := range []struct {
+		line      string
+		symbol    string
+		class     AssetClass
+		format    int
+		precision int
+		oi        bool
+	}{
+		{equityFundamental, "AAPL", AssetEquity, 14, 4, false},
+		{futureFundamental, "@ESM16", AssetFuture, 12, 2, true},
+		{forexFundamental, "EURUSD.FXCM", AssetForex, 15, 5, false},
+	} {
+		if n := strings.Count(tc.line, ","); n != 55 {
+			t.Fatalf("%s fixture has %d fields, want 56", tc.symbol, n+1)
+		}
+		f := &FundamentalMsg{}
+		f.UnMarshall([]byte(tc.line), time.UTC)
+		if f.Symbol != tc.symbol || f.AssetClass() != tc.class || f.FormatCode != tc.format {
+			t.Errorf("%s: got symbol %s class %s format %d", tc.symbol, f.Symbol, f.AssetClass(), f.FormatCode)
+		}
+		if got := f.DisplayPrecision(); got != tc.precision {
+			t.Errorf("%s: display precision %d, want %d", tc.symbol, got, tc.precision)
+		}
+		if f.AssetClass().HasOpenInterest() != tc.oi {
+			t.Errorf("%s: HasOpenInterest should be %v", tc.symbol, tc.oi)
+		}
+	}
+}
+
+func TestLongFundamentalMessage(t *testing.T) {
+	// Every field populated, with a company description and option root list long enough to span several reads of the default 4096 byte buffer.
+	fields := strings.Split(equityFundamental, ",")
+	for i, f := range fields {
+		if f == "" {
+			fields[i] = "1"
+		}
+	}
+	fields[23] = strings.Repeat("APPLE INC DESIGNS MANUFACTURES AND MARKETS MOBILE COMMUNICATION AND MEDIA DEVICES ", 100)
+	fields[24] = strings.TrimSpace(strings.Repeat("AAPL AAPL7 ", 200))
+	line := "F," + strings.Join(fields, ",")
+	if len(line) <= 8*1024 {
+		t.Fatalf("fixture is only %d bytes", len(line))
+	}
+
+	c, server := pipeClient()
+	defer c.Conn.Close()
+	go fmt.Fprintf(server, "%s\r\nT,20160314 09:30:00\r\n", line)
+
+	select {
+	case f := <-c.Fundamental:
+		if f.CompanyName != fields[23] || len(f.RootOptionSymbol) != 400 || f.ExchangeRoot != "1" {
+			t.Errorf("long fundamental was not parsed whole: company %d bytes, %d roots, exchange root %q", len(f.CompanyName), len(f.RootOptionSymbol), f.ExchangeRoot)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no fundamental received")
+	}
+	select {
+	case <-c.Time:
+	case <-time.After(time.Second):
+		t.Fatal("the line after the long fundamental was lost")
+	}
+}
+
+func TestFundamentalSplits(t *testing.T) {
+	f := &FundamentalMsg{}
+	f.UnMarshall([]byte(equityFundamental), time.UTC)
+	want := []SplitEvent{
+		{Date: time.Date(2014, 6, 9, 0, 0, 0, 0, time.UTC), Factor: 0.14},
+		{Date: time.Date(2005, 2, 28, 0, 0, 0, 0, time.UTC), Factor: 0.50},
+	}
+	got := f.Splits()
+	if len(got) != len(want) {
+		t.Fatalf("got %d splits, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if !got[i].Date.Equal(want[i].Date) || got[i].Factor != want[i].Factor {
+			t.Errorf("split %d: got %+v, want %+v", i, got[i], want[i])
+		}
+	}
+
+	// The location the message was parsed in is used for the dates.
+	loc := time.FixedZone("EST", -5*3600)
+	f.UnMarshall([]byte(equityFundamental), loc)
+	if s := f.Splits(); len(s) != 2 || s[0].Date.Location() != loc || s[0].Date.Day() != 9 {
+		t.Errorf("expected the dates in the message location, got %+v", s)
+	}
+	// They are converted along with the other dates of the message.
+	f.inLoc(time.UTC)
+	if s := f.Splits(); len(s) != 2 || s[0].Date != time.Date(2014, 6, 9, 5, 0, 0, 0, time.UTC) {
+		t.Errorf("expected the dates converted to UTC, got %+v", s)
+	}
+
+	f.UnMarshall([]byte(futureFundamental), time.UTC)
+	if splits := f.Splits(); len(splits) != 0 {
+		t.Errorf("expected no splits for a future, got %+v", splits)
+	}
+}
+
+func TestFundamentalTypedFields(t *testing.T) {
+	f := &FundamentalMsg{}
+	f.UnMarshall([]byte(equityFundamental), time.UTC)
+	date := func(m time.Month, d, y int) time.Time { return time.Date(y, m, d, 0, 0, 0, 0, time.UTC) }
+	for name, tc := range map[string]struct{ got, want time.Time }{
+		"PayDate":          {f.PayDate, date(2, 11, 2016)},
+		"ExDivDate":        {f.ExDivDate, date(2, 4, 2016)},
+		"BalSheetDate":     {f.BalSheetDate, date(12, 31, 2015)},
+		"Fifty2WkHighDate": {f.Fifty2WkHighDate, date(4, 28, 2015)},
+		"CalYearLowDate":   {f.CalYearLowDate, date(1, 28, 2016)},
+	} {
+		if !tc.got.Equal(tc.want) {
+			t.Errorf("%s = %s, want %s", name, tc.got, tc.want)
+		}
+	}
+	if f.PE != 9.9 || f.DivYield != 2.21 || f.Fifty2WkHigh != 134.54 || f.Fifty2WkLow != 92 || f.AvgVolume != 53599000 || f.ComShrOutstanding != 5544583 {
+		t.Errorf("numeric fields = pe %v yield %v 52wk %v/%v avg vol %v shares %v", f.PE, f.DivYield, f.Fifty2WkHigh, f.Fifty2WkLow, f.AvgVolume, f.ComShrOutstanding)
+	}
+	if len(f.Raw) != 56 || f.Raw[33] != "334220" {
+		t.Errorf("raw fields not kept: %d fields", len(f.Raw))
+	}
+
+	// A truncated message leaves the missing fields empty.
+	short := &FundamentalMsg{}
+	short.UnMarshall([]byte("AAPL,5,9.9"), time.UTC)
+	if short.Symbol != "AAPL" || short.PE != 9.9 || len(short.Raw) != 3 {
+		t.Errorf("short message = %+v", short)
+	}
+}
+
+func TestQuotedFundamentalFields(t *testing.T) {
+	fields := strings.Split(equityFundamental, ",")
+	fields[23] = `"APPLE, INC."`
+	f := &FundamentalMsg{}
+	f.UnMarshall([]byte(strings.Join(fields, ",")), time.UTC)
+	if f.CompanyName != "APPLE, INC." || len(f.RootOptionSymbol) != 2 || f.FormatCode != 14 {
+		t.Errorf("quoted company name shifted the fields: name %q roots %q format %d", f.CompanyName, f.RootOptionSymbol, f.FormatCode)
+	}
+}
+
+func TestRefreshFundamental(t *testing.T) {
+	c := newTestClient()
+	c.WatchSymbol("AAPL")
+	c.processFndMsg([]byte(equityFundamental))
+	if err := c.RefreshFundamental(" AAPL "); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.Conn.(*recordConn).String(); got != "wAAPL\r\nfAAPL\r\n" {
+		t.Errorf("sent %q", got)
+	}
+	c.processFndMsg([]byte(equityFundamental))
+	c.processFndMsg([]byte(equityFundamental))
+	for i, want := range []bool{false, true, false} {
+		if f := <-c.Fundamental; f.Symbol != "AAPL" || f.Refreshed != want {
+			t.Errorf("fundamental %d: symbol %s refreshed %v, want %v", i, f.Symbol, f.Refreshed, want)
+		}
+	}
+	if err := c.RefreshFundamental("AA,PL"); !errors.Is(err, ErrInvalidSymbol) {
+		t.Errorf("expected ErrInvalidSymbol, got %v", err)
+	}
+}
+
+func TestOpenInterestAndSettlement(t *testing.T) {
+	c := newTestClient()
+	c.setDynFields([]string{"Symbol", "Last", "Open Interest", "Settle", "Settlement Date"})
+	// Before its fundamental the class of a symbol isn't known and the fields are left as sent.
+	c.processSummaryMsg([]byte("AAPL,95.02,12,95.00,03/11/2016"), Received{})
+	if u := <-c.Updates; u.AssetClass != AssetUnknown || u.OpenInterest != 12 {
+		t.Errorf("unclassified summary = %s %d", u.AssetClass, u.OpenInterest)
+	}
+	if _, ok := c.AssetClassOf("AAPL"); ok {
+		t.Error("expected no class before the fundamental")
+	}
+
+	c.processFndMsg([]byte(equityFundamental))
+	c.processFndMsg([]byte(futureFundamental))
+	<-c.Fundamental
+	<-c.Fundamental
+	if a, ok := c.AssetClassOf("@ESM16"); !ok || a != AssetFuture {
+		t.Errorf("AssetClassOf = %s, %v", a, ok)
+	}
+	c.processSummaryMsg([]byte("@ESM16,2010.25,2734512,2008.50,03/11/2016"), Received{})
+	c.processUpdMsg([]byte("@ESM16,2010.50,2734600,,"), Received{})
+	c.processSummaryMsg([]byte("AAPL,95.02,12,95.00,03/11/2016"), Received{})
+
+	s := <-c.Updates
+	settled := time.Date(2016, 3, 11, 0, 0, 0, 0, time.UTC)
+	if s.AssetClass != AssetFuture || s.OpenInterest != 2734512 || s.Settle != 2008.50 || !s.SettleDate.Equal(settled) {
+		t.Errorf("future summary = %s %d %v %s", s.AssetClass, s.OpenInterest, s.Settle, s.SettleDate)
+	}
+	if u := <-c.Updates; u.AssetClass != AssetFuture || u.OpenInterest != 2734600 {
+		t.Errorf("future update = %s %d", u.AssetClass, u.OpenInterest)
+	}
+	if e := <-c.Updates; e.AssetClass != AssetEquity || e.OpenInterest != 0 || e.Settle != 0 || !e.SettleDate.IsZero() {
+		t.Errorf("equity summary = %s %d %v %s", e.AssetClass, e.OpenInterest, e.Settle, e.SettleDate)
+	}
+}
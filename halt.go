@@ -0,0 +1,40 @@
+package iqfeed
+
+import "strings"
+
+// SetHaltConditions sets the trade condition codes that mark a trading halt, a summary or update whose Most Recent Trade Conditions contain one of them has Halted set.
+// The codes differ between exchanges and protocol versions, LoadHaltConditions picks them from the feed's own table.
+func (c *IQC) SetHaltConditions(conds ...TradeCondition) {
+	set := make(map[TradeCondition]bool, len(conds))
+	for _, t := range conds {
+		set[t] = true
+	}
+	c.haltConditions.Store(set)
+}
+
+// LoadHaltConditions sets the halt conditions (see SetHaltConditions) to every code of the trade condition table whose name or description mentions a halt, and returns them.
+func (c *IQC) LoadHaltConditions() ([]TradeCondition, error) {
+	table, err := c.RequestTradeConditions()
+	if err != nil {
+		return nil, err
+	}
+	var conds []TradeCondition
+	for _, t := range table {
+		if strings.Contains(strings.ToUpper(t.Name+" "+t.Description), "HALT") {
+			conds = append(conds, t.Condition)
+		}
+	}
+	c.SetHaltConditions(conds...)
+	return conds, nil
+}
+
+// markHalt sets Halted on a parsed summary or update.
+func (c *IQC) markHalt(u *UpdSummaryMsg) {
+	set, _ := c.haltConditions.Load().(map[TradeCondition]bool)
+	for _, t := range u.Conditions {
+		if set[t] {
+			u.Halted = true
+			return
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package iqfeed
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// defaultLaunchTimeout is used when LaunchOptions.Timeout is not set, IQConnect takes a while to log in to the DTN servers.
+const defaultLaunchTimeout = 30 * time.Second
+
+// LaunchOptions are the arguments IQConnect is launched with by LaunchIQConnect, see the IQConnect command line parameters in the IQFeed developer documentation.
+type LaunchOptions struct {
+	Path      string        // Path of iqconnect.exe, found on the PATH or in the IQFeed install directory when empty.
+	ProductID string        // The product id DTN registered your application under.
+	Version   string        // Version of your application, reported to DTN.
+	Login     string        // DTN login, IQConnect shows its login dialog when empty.
+	Password  string        // Password of Login.
+	Timeout   time.Duration // How long to wait for IQConnect to answer on the admin port once launched, defaults to 30 seconds.
+}
+
+// args returns the IQConnect command line for the options.
+func (o LaunchOptions) args() []string {
+	var args []string
+	if o.ProductID != "" {
+		args = append(args, "-product", o.ProductID)
+	}
+	if o.Version != "" {
+		args = append(args, "-version", o.Version)
+	}
+	if o.Login != "" {
+		args = append(args, "-login", o.Login, "-password", o.Password, "-autoconnect")
+	}
+	return args
+}
+
+// dialError returns the error for a failed dial, wrapping ErrIQConnectNotRunning when nothing was listening so the usual first run mistake is easy to recognise.
+func dialError(err error) error {
+	if connRefused(err) {
+		return fmt.Errorf("%w, launch it (see LaunchIQConnect) and log in first: %v", ErrIQConnectNotRunning, err)
+	}
+	return err
+}
+
+// waitForPort dials addr until it accepts a connection or timeout has elapsed, it is how LaunchIQConnect knows IQConnect is ready.
+func (c *IQC) waitForPort(addr string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		conn, err := net.DialTimeout("tcp", addr, time.Second)
+		if err == nil {
+			conn.Close()
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("iqfeed: IQConnect did not answer on %s within %s: %w", addr, timeout, ErrTimeout)
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+}
@@ -0,0 +1,18 @@
+//go:build !windows
+
+package iqfeed
+
+import (
+	"errors"
+	"syscall"
+)
+
+// connRefused reports whether err is a dial failing because nothing listens on the port.
+func connRefused(err error) bool {
+	return errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// LaunchIQConnect starts IQConnect on Windows, elsewhere IQConnect runs under Wine or on another machine and has to be launched by other means, so it always fails.
+func (c *IQC) LaunchIQConnect(opts LaunchOptions) error {
+	return errors.New("iqfeed: LaunchIQConnect is only supported on Windows")
+}
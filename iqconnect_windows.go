@@ -0,0 +1,54 @@
+package iqfeed
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+)
+
+// wsaeconnrefused is the Winsock error for a refused connection, Windows doesn't report it as syscall.ECONNREFUSED.
+const wsaeconnrefused = syscall.Errno(10061)
+
+// connRefused reports whether err is a dial failing because nothing listens on the port.
+func connRefused(err error) bool {
+	return errors.Is(err, wsaeconnrefused) || errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// LaunchIQConnect starts IQConnect with the login given in opts and waits until it answers on the admin port (see AdminAddress), so Start can be called straight after.
+// IQConnect keeps running once launched, an IQConnect that is already running is reused by the launch and returns as soon as it answers.
+func (c *IQC) LaunchIQConnect(opts LaunchOptions) error {
+	path := opts.Path
+	if path == "" {
+		path = iqconnectPath()
+	}
+	cmd := exec.Command(path, opts.args()...)
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("iqfeed: could not launch IQConnect from %s: %w", path, err)
+	}
+	// IQConnect detaches from us, there is nothing to wait for.
+	go cmd.Wait()
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = defaultLaunchTimeout
+	}
+	return c.waitForPort(c.address(c.AdminAddress, defaultAdminPort), timeout)
+}
+
+// iqconnectPath returns where iqconnect.exe is found, on the PATH or in the directory the IQFeed client installs it to.
+func iqconnectPath() string {
+	if p, err := exec.LookPath("iqconnect.exe"); err == nil {
+		return p
+	}
+	for _, env := range []string{"ProgramFiles(x86)", "ProgramFiles"} {
+		if dir := os.Getenv(env); dir != "" {
+			p := filepath.Join(dir, "DTN", "IQFeed", "iqconnect.exe")
+			if _, err := os.Stat(p); err == nil {
+				return p
+			}
+		}
+	}
+	return "iqconnect.exe"
+}
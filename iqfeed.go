@@ -2,150 +2,867 @@ package iqfeed
 
 import (
 	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
 	"fmt"
 	"io"
-	"log"
 	"net"
+	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 )
 
 // IQC provides the main struct for the the IQ Client interface into what IQFeed will be sending us.
+// Once started the methods of IQC are safe for concurrent use by multiple goroutines, the exported option fields should be set before calling Start.
 type IQC struct {
-	System       chan *SystemMessage
-	News         chan *NewsMsg
-	Errors       chan *ErrorMsg
-	Fundamental  chan *FundamentalMsg
-	Regional     chan *RegionalMsg
-	Time         chan *TimeMsg
-	Updates      chan *UpdSummaryMsg
-	TimeZone     string
-	TimeLoc      *time.Location
-	CreateBackup bool
-	BackupFile   string
-	Conn         net.Conn
-	Quit         chan bool
-	DynFields    map[int]string
-	requestId string
-	previousRequestId int64
-}
-
-func (c *IQC) incr() string{
-	c.requestId = fmt.Sprintf("%d", atomic.AddInt64(&c.previousRequestId, 1))
-	return c.requestId
-}
-
-func (c *IQC) connect(cs string) {
+	System               chan *SystemMessage
+	News                 chan *NewsMsg
+	Errors               chan *ErrorMsg // Errors reported by the feed (ex: symbol not found) and lines that couldn't be parsed. Something must read it: once it is full the read goroutine blocks and the whole feed stalls, see LogErrors.
+	Fundamental          chan *FundamentalMsg
+	Regional             chan *RegionalMsg
+	Time                 chan *TimeMsg
+	Updates              chan *UpdSummaryMsg
+	Quotes               chan *Quote  // Merged per symbol quotes, only sent to when EmitQuotes is set.
+	Messages             chan Message // Every message in arrival order, used instead of the typed channels above (and Depth, Stats and Bars) when UnifiedMessages is set.
+	UnifiedMessages      bool         // Send every message on Messages instead of its typed channel, connection events are still sent on Connection.
+	TimeZone             string
+	TimeLoc              *time.Location
+	TradesOnly           bool // Drop update messages that aren't trades (see UpdateKind) instead of sending them on Updates, summaries are still sent. Requires Message Contents in the field selection.
+	TimestampsOff        bool // Turn the once per second timestamp messages off when starting, see DisableTimestamps.
+	LogErrors            bool // Read Errors from the start and log every message with Logger, for applications that don't otherwise consume it (see DrainErrors). Use DropPolicies to drop errors instead.
+	KeepRaw              bool // Keep the line every message was parsed from in its Line field (see Received), for debugging. It costs a copy of every line.
+	CoalesceTimestamps   bool // Only send a timestamp message on Time when its second is past the last one sent, repeats of the same second are dropped. Messages that can't be parsed are still sent.
+	EmitQuotes           bool // Merge summary and update messages into complete quotes on the Quotes channel.
+	KeepState            bool // Keep the state of every symbol merged from its summary and update messages, see LastQuote.
+	EmitMerged           bool // Send the merged state of a symbol on Merged after every summary and update message, implies KeepState.
+	LoadListedMarkets    bool // Fetch the listed markets table from the lookup port when starting, see MarketName. A failure is logged and doesn't fail Start.
+	CreateBackup         bool
+	BackupFile           string
+	MaxBackupBytes       int64                         // Rotate BackupFile once it would grow past this size, 0 disables size based rotation.
+	RotateDaily          bool                          // Rotate BackupFile when the day rolls over in TimeLoc.
+	CompressBackups      bool                          // Gzip rotated backup files, ReplayFile reads them as they are.
+	OnBackupError        func(err error)               // Called from the read goroutine whenever a line can't be written to BackupFile.
+	RealTimeReplay       bool                          // Pace ReplayFile using the timestamp messages in the file instead of replaying it as fast as it can be consumed.
+	ReplaySpeed          float64                       // Multiple of real time RealTimeReplay paces ReplayFile at (ex: 10 replays a minute of the feed in 6 seconds), 0 replays in real time.
+	ReplayStartTime      time.Time                     // Skip the part of ReplayFile before the first timestamp message at or after this time, only system messages (which carry the field layout) are processed until then.
+	NormalizeToUTC       bool                          // Convert every parsed timestamp to UTC after it has been interpreted in TimeLoc, times of day sent without a date are placed on the feed's current date (see FeedTime) first.
+	OutputLoc            *time.Location                // Convert every parsed timestamp, lookup results included, to this location instead of UTC, as NormalizeToUTC does. The wire times are still interpreted in TimeLoc, the exchange timezone of the feed. Takes precedence over NormalizeToUTC.
+	NotFoundTTL          time.Duration                 // How long a symbol reported as not found makes watches of it fail with ErrSymbolNotFound without asking the feed, 0 disables the cache.
+	ConfirmTimeout       time.Duration                 // How long to wait for the feed to confirm a command such as SelectUpdateFields, defaults to 5 seconds.
+	StrictFields         bool                          // Make SelectUpdateFields fail with ErrUnknownField for field names the parser doesn't know, instead of selecting them and reading them from Extra.
+	ClientName           string                        // Name of the connection in IQConnect's diagnostics and stats, sent with SetClientName when starting and after every reconnect.
+	UppercaseSymbols     bool                          // Upper case the symbols given to the watch methods, as equity symbols are. Leave it off for futures and options whose symbols have lower case parts.
+	MaxSymbols           int                           // Fail watches with ErrSymbolLimit once this many symbols are watched instead of letting the feed drop them, 0 disables the check. Set it to the MaxSymbols of your plan (see CustomerData).
+	SubscribeBufferSize  int                           // Number of messages each channel returned by Subscribe holds, defaults to 64.
+	OnWatchChange        func(added, removed []string) // Called when symbols are added to or removed from the watched set, without any client lock held so it may call back into the client.
+	SocketReadBuffer     int                           // OS receive buffer size in bytes for the TCP connection, 0 keeps the OS default (usually a few hundred KB).
+	SocketWriteBuffer    int                           // OS send buffer size in bytes for the TCP connection, 0 keeps the OS default.
+	ReadBufferSize       int                           // Initial size in bytes of the buffer lines are read into, defaults to 64KB. Longer lines are still read whole, in more than one go.
+	Host                 string                        // Host running IQConnect, used by the connection string given to Start and by every port address below that doesn't name a host, defaults to localhost.
+	LookupAddress        string                        // Address of the IQFeed lookup port used for historical and symbol lookups, defaults to port 9100 on Host. A port alone (ex: :9101) overrides the port only.
+	MaxLookups           int                           // Number of lookup requests allowed in flight at once, each on its own connection to the lookup port, defaults to 1. Further requests wait for a slot.
+	LookupTimeout        time.Duration                 // How long a lookup request may take to be answered up to its terminator before it fails with ErrTimeout, 0 waits forever. The time spent waiting for a slot isn't counted.
+	ReconnectEnabled     bool                          // Re-dial IQFeed when the connection is lost, replaying the field selection and watched symbols.
+	MaxReconnectAttempts int                           // Give up reconnecting after this many failed attempts, 0 retries forever.
+	InitialBackoff       time.Duration                 // Delay before the first reconnection attempt, doubled after every attempt. Defaults to 1 second.
+	MaxBackoff           time.Duration                 // Cap on the delay between reconnection attempts, defaults to 1 minute.
+	StaleTimeout         time.Duration                 // Report the feed as stale with a StateStale event when nothing is received for this long, 0 disables the watchdog. Keep it above a second since timestamps arrive every second unless DisableTimestamps was used.
+	ReconnectOnStale     bool                          // Close a stale connection so it is reconnected, requires ReconnectEnabled.
+	Stats                chan *ClientStats             // Connection statistics, from the admin port once ConnectAdmin is called and in answer to RequestStats.
+	AdminAddress         string                        // Address of the IQFeed admin port, defaults to port 9300 on Host.
+	Depth                chan *L2Msg                   // Level 2 market depth messages for the symbols watched with WatchL2.
+	Merged               chan *UpdSummaryMsg           // Every field of a symbol as last known after each summary / update message (see LastQuote), only sent to when EmitMerged is set.
+	Bars                 chan *IntervalBar             // Live interval bars for the symbols watched with WatchIntervalBars.
+	DerivAddress         string                        // Address of the IQFeed derivative port streaming interval bars, defaults to port 9400 on Host.
+	L2Address            string                        // Address of the IQFeed Level 2 port, defaults to port 9200 on Host.
+	DialTimeout          time.Duration                 // How long connecting to an IQFeed port (including the TLS handshake) may take, defaults to 10 seconds.
+	KeepAlive            time.Duration                 // TCP keepalive period of the connections so half open sockets are detected by the OS, 0 uses the Go default of 15 seconds and a negative value disables it.
+	ReadTimeout          time.Duration                 // Treat the Level 1 connection as lost when nothing is read for this long, 0 waits forever. Keep it above a second since timestamps arrive every second unless DisableTimestamps was used.
+	TLSConfig            *tls.Config                   // Connect to every IQFeed port over TLS, for an IQConnect running on another machine behind a TLS tunnel. Use StartConn for any other kind of transport.
+	Connection           chan *ConnectionEvent         // Connection state changes, events are dropped when the channel is full.
+	Logger               Logger                        // Receives the client's diagnostic messages, defaults to the standard log package.
+	Metrics              Metrics                       // Receives counters about the feed for monitoring, see Metrics.
+	DropPolicy           DropPolicy                    // What to do with a message when its output channel is full, defaults to blocking until the consumer catches up.
+	DropPolicies         map[string]DropPolicy         // Per channel overrides of DropPolicy, keyed by the channel's field name (ex: "Updates").
+	Conn                 net.Conn
+	connMu               sync.RWMutex    // Guards Conn while it is swapped by a reconnect.
+	writeMu              sync.Mutex      // Serializes writes to Conn.
+	connectString        string          // The address given to Start with the defaults filled in, re-dialled by reconnects.
+	Quit                 chan bool       // Closing or sending to Quit stops the client like Stop, without closing the output channels.
+	DynFields            map[int]string  // The current summary / update field layout. It is replaced rather than modified when the layout changes, use UpdateFieldNames to read it while the client is running.
+	dynMu                sync.RWMutex    // Guards swapping DynFields.
+	fieldsVersion        uint64          // Incremented every time DynFields is replaced, guarded by dynMu.
+	pending              []pendingUpdate // Summary / update lines received before the field names were known.
+	watchMu              sync.Mutex
+	snapshotWaiters      map[string][]chan snapshotResult // Snapshot calls waiting for the summary of a symbol.
+	watched              map[string]WatchMode
+	regional             map[string]bool            // Symbols watched with WatchRegional.
+	snapshots            map[string]*snapshotState  // Symbols watched in a snapshot mode that are still waiting for their initial messages.
+	paused               map[string]WatchMode       // Symbols unwatched by Pause, to be watched again by Resume.
+	contentFilters       map[string]MessageContents // The Message Contents flags updates must have to be delivered, set by WatchSymbolContents.
+	refreshes            map[string]bool            // Symbols RefreshFundamental is waiting on the fundamental message of.
+	subsMu               sync.Mutex
+	subs                 map[string][]*subscription // The channels handed out by Subscribe, keyed by symbol.
+	subWatches           map[string]*subWatch       // The watch made by the first Subscribe of every subscribed symbol. Guarded by subsMu.
+	notFoundMu           sync.Mutex
+	notFound             map[string]time.Time // Symbols reported as not found mapped to when the entry expires.
+	stop                 chan struct{}        // Closed to make the read goroutine stop.
+	done                 chan struct{}        // Closed once the read goroutine has exited.
+	workers              sync.WaitGroup       // Goroutines besides read that send on the output channels, Stop waits for them before closing the channels.
+	haltOnce             sync.Once
+	stopOnce             sync.Once
+	capsMu               sync.RWMutex
+	caps                 Capabilities
+	lastCommand          atomic.Value // The last command written, reported alongside syntax errors.
+	clientName           atomic.Value // The name last given to SetClientName, replayed on reconnect.
+	throttleMu           sync.Mutex
+	throttles            map[string]*symbolThrottle
+	throttled            uint64
+	timestampsOff        int32        // Set while timestamps are turned off, so it can be replayed on reconnect.
+	serverDown           int32        // Set while IQConnect reports it is disconnected from the DTN servers, updated atomically.
+	lastRecv             int64        // UnixNano of the last line received, updated atomically.
+	connected            int32        // Set while the Level 1 connection is up, updated atomically.
+	feedTime             atomic.Value // The timestamp of the most recent TimeMsg.
+	haltConditions       atomic.Value // The map[TradeCondition]bool set by SetHaltConditions.
+	listedMarkets        atomic.Value // The map[int]ListedMarket kept by RequestListedMarkets.
+	securityTypes        atomic.Value // The map[int]SecurityType kept by RequestSecurityTypes.
+	fieldsMu             sync.Mutex
+	fieldWaiters         []chan []string // Notified with the field names every time a new layout is received.
+	selectedFields       []string        // The fields last passed to SelectUpdateFields.
+	protocolMu           sync.Mutex
+	protocol             string        // The protocol version last confirmed by the feed.
+	protocolWaiters      []chan string // Notified with the version every time the feed reports its protocol.
+	quotes               map[string]*Quote
+	stateMu              sync.Mutex
+	states               map[string]*symbolState // The merged state of each symbol, see KeepState.
+	precisionMu          sync.Mutex
+	precisions           map[string]int         // The decimal precision of each symbol, see Precision.
+	assetClasses         map[string]AssetClass  // The security type of each symbol from its fundamental message, see AssetClassOf.
+	priceFormats         map[string]PriceFormat // The price format code of each symbol, see FormatPrice.
+	ctx                  context.Context        // The context given to StartContext.
+	lookupMu             sync.Mutex             // Guards lookupSlots and lookupIdle.
+	lookupSlots          chan struct{}          // Holds a token for every lookup request in flight, MaxLookups long.
+	lookupIdle           []*lookupStream        // Lookup connections waiting for the next request.
+	lookupConns          map[*lookupStream]bool // Every open lookup connection, guarded by connMu so halt can close them.
+	lookupsInFlight      int32                  // Updated atomically.
+	l2Mu                 sync.Mutex             // Serializes dialling and writing to the Level 2 connection.
+	l2Conn               net.Conn               // Dialled on the first WatchL2, guarded by connMu so halt can close it.
+	depthLevels          int32                  // The number of price levels per side delivered on Depth, 0 for all of them. See SetDepthLevels, updated atomically.
+	depthMu              sync.Mutex
+	depthBook            *Book      // The book of every Level 2 symbol while depthLevels is set, used to tell which messages are within the top levels. Guarded by depthMu.
+	derivMu              sync.Mutex // Serializes dialling and writing to the derivative port connection.
+	derivConn            net.Conn   // Dialled on the first WatchIntervalBars, guarded by connMu so halt can close it.
+	barsMu               sync.Mutex
+	barIntervals         map[string]int // The interval of every bar watch keyed by its request id.
+	adminMu              sync.Mutex
+	adminConn            net.Conn    // Dialled by ConnectAdmin, guarded by connMu.
+	backup               backupState // The open backup file, only used by the read goroutine.
+	recv                 Received    // When the line being processed was read, and the line with KeepRaw. Only used by the read goroutine.
+	lastTimeSent         time.Time   // The timestamp of the last TimeMsg sent on Time, for CoalesceTimestamps. Only used by the read goroutine.
+	unstamped            bool        // Leave Received zero, set on the offline clients of Verify so their output can be reproduced.
+	previousRequestId    int64
+}
+
+// defaultConfirmTimeout is used when ConfirmTimeout is not set.
+const defaultConfirmTimeout = 5 * time.Second
+
+// defaultDialTimeout is used when DialTimeout is not set.
+const defaultDialTimeout = 10 * time.Second
+
+// defaultReadBufferSize is used when ReadBufferSize is not set, large enough for the longest fundamental and news lines with every field selected.
+const defaultReadBufferSize = 64 * 1024
+
+// defaultSubscribeBufferSize is used when SubscribeBufferSize is not set.
+const defaultSubscribeBufferSize = 64
+
+// maxPendingUpdates bounds how many summary / update lines are held back while waiting for the field names.
+const maxPendingUpdates = 1024
+
+// pendingUpdate is a summary or update line that arrived before DynFields was populated.
+type pendingUpdate struct {
+	kind byte
+	data []byte
+	recv Received // When the line was read.
+}
+
+// incr returns a new request id, unique for the client and safe to call from multiple goroutines.
+func (c *IQC) incr() string {
+	return fmt.Sprintf("%d", atomic.AddInt64(&c.previousRequestId, 1))
+}
+
+// defaultHost is the host of every IQFeed port when Host is not set.
+const defaultHost = "localhost"
+
+// defaultLevel1Port is the IQFeed Level 1 port, used when the connection string doesn't give one.
+const defaultLevel1Port = "5009"
+
+// address returns the address to dial for a port: addr when it names a host, otherwise port, or the port given by addr alone, on Host.
+func (c *IQC) address(addr, port string) string {
+	host := c.Host
+	if host == "" {
+		host = defaultHost
+	}
+	if addr == "" {
+		return net.JoinHostPort(host, port)
+	}
+	if h, p, err := net.SplitHostPort(addr); err == nil && h == "" {
+		return net.JoinHostPort(host, p)
+	}
+	if _, err := strconv.Atoi(addr); err == nil {
+		return net.JoinHostPort(host, addr)
+	}
+	return addr
+}
+
+// connect resolves the feed timezone and dials IQFeed, returning an error when either fails.
+func (c *IQC) connect(cs string) error {
+	if err := c.prepare(); err != nil {
+		return err
+	}
+	c.connectString = c.address(cs, defaultLevel1Port)
+	conn, err := c.dial()
+	if err != nil {
+		return err
+	}
+	c.Conn = conn
+	return nil
+}
+
+// prepare resolves TimeLoc and resets the field layout before the client starts reading.
+func (c *IQC) prepare() error {
 	if c.TimeZone == "" {
 		c.TimeZone = "America/New_York"
 	}
 	var err error
 	c.TimeLoc, err = time.LoadLocation(c.TimeZone)
 	if err != nil {
-		// We absolutely need the timezone / location so we must panic if it fails.
-		panic(err)
+		// We absolutely need the timezone / location to parse anything so there is no point connecting without it.
+		return fmt.Errorf("iqfeed: could not load timezone %s: %w", c.TimeZone, err)
 	}
 	c.DynFields = make(map[int]string)
-	if cs == "" {
-		cs = "localhost:5009"
+	return nil
+}
+
+// dial opens a new connection to the address given to connect.
+func (c *IQC) dial() (net.Conn, error) {
+	conn, err := c.dialAddr(c.connectString)
+	if err != nil {
+		return nil, fmt.Errorf("iqfeed: could not connect to IQFeed at %s: %w", c.connectString, err)
 	}
-	conn, err := net.Dial("tcp", cs)
+	return conn, nil
+}
+
+// dialTimeout returns DialTimeout or its default.
+func (c *IQC) dialTimeout() time.Duration {
+	if c.DialTimeout > 0 {
+		return c.DialTimeout
+	}
+	return defaultDialTimeout
+}
+
+// dialAddr opens a TCP connection to addr with the socket buffers applied, wrapped in TLS when TLSConfig is set.
+func (c *IQC) dialAddr(addr string) (net.Conn, error) {
+	d := net.Dialer{Timeout: c.dialTimeout(), KeepAlive: c.KeepAlive}
+	conn, err := d.Dial("tcp", addr)
 	if err != nil {
-		log.Fatal("Could not connect to IQFeed")
+		return nil, dialError(err)
+	}
+	c.setSocketBuffers(conn)
+	if c.TLSConfig == nil {
+		return conn, nil
+	}
+	cfg := c.TLSConfig
+	if cfg.ServerName == "" && !cfg.InsecureSkipVerify {
+		// Verify the certificate against the host we dialled, as tls.Dial does.
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			host = addr
+		}
+		cfg = cfg.Clone()
+		cfg.ServerName = host
+	}
+	// Bound the handshake so a peer that doesn't speak TLS can't hang Start or a reconnect.
+	conn.SetDeadline(time.Now().Add(c.dialTimeout()))
+	tc := tls.Client(conn, cfg)
+	if err := tc.Handshake(); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("iqfeed: TLS handshake with %s failed: %w", addr, err)
+	}
+	conn.SetDeadline(time.Time{})
+	return tc, nil
+}
+
+// service returns the connection to one of the secondary IQFeed ports held in slot, dialling addr (see address, port is the default port) and starting reader on it first if needed.
+// The connection is registered under connMu so halt closes it along with the others, it fails with ErrClientStopped once the client is stopped.
+func (c *IQC) service(slot *net.Conn, addr, port string, reader func(net.Conn)) (net.Conn, error) {
+	c.connMu.RLock()
+	conn := *slot
+	c.connMu.RUnlock()
+	if conn != nil {
+		return conn, nil
+	}
+	addr = c.address(addr, port)
+	conn, err := c.dialAddr(addr)
+	if err != nil {
+		return nil, fmt.Errorf("iqfeed: could not connect to IQFeed at %s: %w", addr, err)
+	}
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+	if c.stopped() {
+		conn.Close()
+		return nil, c.stopErr(ErrClientStopped)
+	}
+	*slot = conn
+	c.workers.Add(1)
+	go reader(conn)
+	return conn, nil
+}
+
+// readService passes every line read from a secondary connection to process until it is closed, then clears slot so the next use dials again.
+func (c *IQC) readService(conn net.Conn, slot *net.Conn, name string, process func([]byte)) {
+	defer c.workers.Done()
+	r := c.newReader(conn)
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			if !c.stopped() {
+				c.log().Errorf("%s connection closed: %s", name, err)
+			}
+			c.connMu.Lock()
+			if *slot == conn {
+				*slot = nil
+			}
+			c.connMu.Unlock()
+			conn.Close()
+			return
+		}
+		process(line)
+	}
+}
+
+// newReader returns a reader over r buffering ReadBufferSize bytes, or its default.
+func (c *IQC) newReader(r io.Reader) *bufio.Reader {
+	size := c.ReadBufferSize
+	if size <= 0 {
+		size = defaultReadBufferSize
+	}
+	return bufio.NewReaderSize(r, size)
+}
+
+// setSocketBuffers applies SocketReadBuffer and SocketWriteBuffer to a TCP connection.
+// The receive buffer is what absorbs bursts while the consumer lags, the bufio reader in read() only ever holds a single line on top of it.
+func (c *IQC) setSocketBuffers(conn net.Conn) {
+	tc, ok := conn.(*net.TCPConn)
+	if !ok {
+		return
+	}
+	if c.SocketReadBuffer > 0 {
+		if err := tc.SetReadBuffer(c.SocketReadBuffer); err != nil {
+			c.log().Warnf("Could not set socket read buffer: %s", err)
+		}
+	}
+	if c.SocketWriteBuffer > 0 {
+		if err := tc.SetWriteBuffer(c.SocketWriteBuffer); err != nil {
+			c.log().Warnf("Could not set socket write buffer: %s", err)
+		}
 	}
-	c.Conn = conn
 }
 
 // ProcessSysMsg handles system messages, field definitions are available here: http://www.iqfeed.net/dev/api/docs/Level1SystemMessage.cfm.
 func (c *IQC) processSysMsg(d []byte) {
-	s := &SystemMessage{}
+	s := &SystemMessage{Received: c.received()}
+	s.Stats.Received = s.Received
 
 	pfx := strings.Split(string(d), ",")
 	switch pfx[0] {
-	case "UPDATE FIELDNAMES":
+	case "UPDATE FIELDNAMES", "CURRENT UPDATE FIELDNAMES":
 		/* We use a map here to preserve the actual order as it's important with marshalling dynamic fields */
-		for i := 1; i < len(pfx); i++ {
-			c.DynFields[i-1] = pfx[i]
-		}
-	case "CURRENT UPDATE FIELDNAMES":
-		/* We use a map here to preserve the actual order as it's important with marshalling dynamic fields */
-		for i := 1; i < len(pfx); i++ {
-			c.DynFields[i-1] = pfx[i]
-		}
+		c.setDynFields(pfx[1:])
+		s.Type = pfx[0]
+		s.FieldNames = newFieldNamesEvent(pfx[0], pfx[1:])
 	default:
 		s.UnMarshall(d, c.TimeLoc)
-		c.System <- s
+		switch s.Type {
+		case "CUST":
+			c.capsMu.Lock()
+			c.caps = newCapabilities(s.Customer)
+			c.capsMu.Unlock()
+		case "CURRENT PROTOCOL":
+			c.setProtocol(s.Protocol)
+		case "STATS":
+			c.sendStats(&s.Stats)
+		case "SERVER DISCONNECTED":
+			c.serverState(StateServerDisconnected)
+		case "SERVER CONNECTED":
+			c.serverState(StateServerConnected)
+		case "SERVER RECONNECT FAILED":
+			c.serverState(StateServerReconnectFailed)
+		case "SYMBOL LIMIT REACHED":
+			c.symbolLimitMsg(s.Symbol, "S,"+string(d))
+		}
+	}
+	if !c.divert("System", c.System, s) {
+		select {
+		case c.System <- s:
+		case <-c.stop:
+		}
 	}
 }
 
+// setDynFields stores the field layout used to unmarshall summary and update messages and parses anything that was held back waiting for it.
+// A new map is built and swapped in so a layout change can't race with a message being unmarshalled against the previous one, and so no stale positions survive a shorter layout.
+func (c *IQC) setDynFields(names []string) {
+	fields := make(map[int]string, len(names))
+	for i, n := range names {
+		fields[i] = n
+	}
+	c.dynMu.Lock()
+	c.DynFields = fields
+	c.fieldsVersion++
+	c.dynMu.Unlock()
+	c.fieldsMu.Lock()
+	for _, w := range c.fieldWaiters {
+		select {
+		case w <- names:
+		default:
+		}
+	}
+	c.fieldsMu.Unlock()
+	pending := c.pending
+	c.pending = nil
+	for _, p := range pending {
+		if p.kind == 0x50 {
+			c.processSummaryMsg(p.data, p.recv)
+		} else {
+			c.processUpdMsg(p.data, p.recv)
+		}
+	}
+}
+
+// received returns the Received of the line being processed, for the messages parsed from it.
+func (c *IQC) received() Received {
+	return c.recv
+}
+
+// dynFields returns the current field layout, the map is never modified once published so it can be used without holding the lock.
+func (c *IQC) dynFields() map[int]string {
+	fields, _ := c.dynLayout()
+	return fields
+}
+
+// dynLayout returns the current field layout along with its version, see UpdSummaryMsg.FieldsVersion.
+func (c *IQC) dynLayout() (map[int]string, uint64) {
+	c.dynMu.RLock()
+	defer c.dynMu.RUnlock()
+	return c.DynFields, c.fieldsVersion
+}
+
+// UpdateFieldNames returns the field names of the current summary / update layout in order.
+func (c *IQC) UpdateFieldNames() []string {
+	fields := c.dynFields()
+	names := make([]string, len(fields))
+	for i, n := range fields {
+		if i >= 0 && i < len(names) {
+			names[i] = n
+		}
+	}
+	return names
+}
+
+// awaitFields registers for notification of new field layouts, the returned func must be called to deregister.
+func (c *IQC) awaitFields() (chan []string, func()) {
+	w := make(chan []string, 1)
+	c.fieldsMu.Lock()
+	c.fieldWaiters = append(c.fieldWaiters, w)
+	c.fieldsMu.Unlock()
+	return w, func() {
+		c.fieldsMu.Lock()
+		defer c.fieldsMu.Unlock()
+		for i, fw := range c.fieldWaiters {
+			if fw == w {
+				c.fieldWaiters = append(c.fieldWaiters[:i], c.fieldWaiters[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// setProtocol records the protocol version confirmed by the feed and notifies anyone waiting in SetProtocol.
+func (c *IQC) setProtocol(v string) {
+	c.protocolMu.Lock()
+	defer c.protocolMu.Unlock()
+	c.protocol = v
+	for _, w := range c.protocolWaiters {
+		select {
+		case w <- v:
+		default:
+		}
+	}
+}
+
+// awaitProtocol registers for notification of protocol confirmations, the returned func must be called to deregister.
+func (c *IQC) awaitProtocol() (chan string, func()) {
+	w := make(chan string, 1)
+	c.protocolMu.Lock()
+	c.protocolWaiters = append(c.protocolWaiters, w)
+	c.protocolMu.Unlock()
+	return w, func() {
+		c.protocolMu.Lock()
+		defer c.protocolMu.Unlock()
+		for i, pw := range c.protocolWaiters {
+			if pw == w {
+				c.protocolWaiters = append(c.protocolWaiters[:i], c.protocolWaiters[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// Protocol returns the protocol version negotiated with SetProtocol, empty until the feed has confirmed one.
+func (c *IQC) Protocol() string {
+	c.protocolMu.Lock()
+	defer c.protocolMu.Unlock()
+	return c.protocol
+}
+
+// confirmTimeout returns the configured ConfirmTimeout or the default.
+func (c *IQC) confirmTimeout() time.Duration {
+	if c.ConfirmTimeout > 0 {
+		return c.ConfirmTimeout
+	}
+	return defaultConfirmTimeout
+}
+
+// deferUpdate holds on to a summary / update line received as recv until the field names arrive, dropping it once the pending buffer is full.
+func (c *IQC) deferUpdate(kind byte, d []byte, recv Received) {
+	if len(c.pending) >= maxPendingUpdates {
+		c.log().Warnf("No field names received yet, dropping update")
+		c.metrics().Dropped("Pending")
+		c.parseError(append([]byte{kind, ','}, d...), "", "no update field names received yet, message dropped", ErrFieldLayout, recv)
+		return
+	}
+	// The reader reuses its buffer so we must keep our own copy of the line.
+	c.pending = append(c.pending, pendingUpdate{kind: kind, data: append([]byte(nil), d...), recv: recv})
+}
+
+// Capabilities returns what the connected account is entitled to, built from the S,CUST handshake message. Known is false until it has been received.
+func (c *IQC) Capabilities() Capabilities {
+	c.capsMu.RLock()
+	defer c.capsMu.RUnlock()
+	return c.caps
+}
+
 // ProcessSumMsg handles summary messages, field definitions are available here: http://www.iqfeed.net/dev/api/docs/Level1UpdateSummaryMessage.cfm.
-func (c *IQC) processSummaryMsg(d []byte) {
-	s := &UpdSummaryMsg{}
+// recv is the Received of the line, passed along rather than read from the client as lines held back for the field names are parsed later.
+func (c *IQC) processSummaryMsg(d []byte, recv Received) {
+	fields, version := c.dynLayout()
+	if len(fields) == 0 {
+		c.deferUpdate(0x50, d, recv)
+		return
+	}
 	items := strings.Split(string(d), ",")
-	s.UnMarshall(items, c.DynFields, c.TimeLoc)
-	c.Updates <- s
+	if c.layoutMismatch(0x50, d, items, fields, recv) {
+		return
+	}
+	s := &UpdSummaryMsg{FieldsVersion: version, Received: recv}
+	s.UnMarshall(items, fields, c.TimeLoc)
+	s.Kind = KindSummary
+	c.markHalt(s)
+	c.classify(s)
+	c.updatePrecision(s)
+	if loc := c.outputLoc(); loc != nil {
+		s.inLoc(c.feedDay(), loc)
+	}
+	if c.deliverSnapshot(s.Symbol, snapshotResult{summary: s}) {
+		return
+	}
+	c.mergeState(s)
+	if c.EmitQuotes {
+		q := c.mergeQuote(s, items, fields)
+		if !c.divert("Quotes", c.Quotes, q) {
+			select {
+			case c.Quotes <- q:
+			case <-c.stop:
+			}
+		}
+	}
+	if !c.toSubscribers(s) && !c.divert("Updates", c.Updates, s) {
+		select {
+		case c.Updates <- s:
+		case <-c.stop:
+		}
+	}
+	c.deliverMerged(s)
+	c.snapshotReceived(s.Symbol, false)
 }
 
 // ProcessUpdMsg handles update messages, field definitions are available here: http://www.iqfeed.net/dev/api/docs/Level1UpdateSummaryMessage.cfm.
-func (c *IQC) processUpdMsg(d []byte) {
-	u := &UpdSummaryMsg{}
+// recv is the Received of the line, see processSummaryMsg.
+func (c *IQC) processUpdMsg(d []byte, recv Received) {
 	items := strings.Split(string(d), ",")
-	if items[2] == "Not Found" {
-		c.process404Msg([]byte(items[0]))
+	if len(items) > 2 && items[2] == "Not Found" {
+		c.notFoundMsg(items[0], "Q,"+string(d), recv)
 		return
 	}
-	u.UnMarshall(items, c.DynFields, c.TimeLoc)
-	c.Updates <- u
+	fields, version := c.dynLayout()
+	if len(fields) == 0 {
+		c.deferUpdate(0x51, d, recv)
+		return
+	}
+	if c.layoutMismatch(0x51, d, items, fields, recv) {
+		return
+	}
+	u := c.parseUpdate(items, fields, version)
+	u.Received = recv
+	c.updatePrecision(u)
+	if c.TradesOnly && u.Kind != KindTrade {
+		return
+	}
+	if !c.contentsWanted(u) {
+		return
+	}
+	var q *Quote
+	if c.EmitQuotes {
+		// Merged before throttling so the quote state reflects the updates that are held back.
+		q = c.mergeQuote(u, items, fields)
+	}
+	c.mergeState(u)
+	if c.throttle(u, items, fields, version, q, time.Now()) {
+		return
+	}
+	c.deliverUpdate(u, q)
+}
+
+// parseUpdate parses the fields of an update message against the layout fields, version is its FieldsVersion.
+func (c *IQC) parseUpdate(items []string, fields map[int]string, version uint64) *UpdSummaryMsg {
+	u := &UpdSummaryMsg{FieldsVersion: version}
+	u.UnMarshall(items, fields, c.TimeLoc)
+	c.markHalt(u)
+	c.classify(u)
+	if loc := c.outputLoc(); loc != nil {
+		u.inLoc(c.feedDay(), loc)
+	}
+	return u
+}
+
+// deliverUpdate sends an update, and its merged quote when q isn't nil, to the output channels.
+func (c *IQC) deliverUpdate(u *UpdSummaryMsg, q *Quote) {
+	if q != nil && !c.divert("Quotes", c.Quotes, q) {
+		select {
+		case c.Quotes <- q:
+		case <-c.stop:
+		}
+	}
+	if !c.toSubscribers(u) && !c.divert("Updates", c.Updates, u) {
+		select {
+		case c.Updates <- u:
+		case <-c.stop:
+		}
+	}
+	c.deliverMerged(u)
 }
 
 // ProcessTimeMsg handles timestamp updates, field definitions are available here: http://www.iqfeed.net/dev/api/docs/TimeMessageFormat.cfm.
 func (c *IQC) processTimeMsg(d []byte) {
-	t := &TimeMsg{}
+	t := &TimeMsg{Received: c.received()}
 	t.UnMarshall(d, c.TimeLoc)
+	if loc := c.outputLoc(); loc != nil {
+		t.inLoc(loc)
+	}
+	if !t.TimeStamp.IsZero() {
+		c.feedTime.Store(t.TimeStamp)
+		if c.CoalesceTimestamps {
+			if !t.TimeStamp.After(c.lastTimeSent) {
+				return
+			}
+			c.lastTimeSent = t.TimeStamp
+		}
+	}
+	if !c.divert("Time", c.Time, t) {
+		select {
+		case c.Time <- t:
+		case <-c.stop:
+		}
+	}
+}
 
-	c.Time <- t
+// FeedTime returns the timestamp of the most recent time message received from the feed, this is the feed's clock and doesn't drift with the local one.
+// The boolean is false until the first time message has arrived.
+func (c *IQC) FeedTime() (time.Time, bool) {
+	t, ok := c.feedTime.Load().(time.Time)
+	return t, ok
+}
+
+// outputLoc returns the location parsed timestamps are converted to, nil to leave them in TimeLoc. See OutputLoc and NormalizeToUTC.
+func (c *IQC) outputLoc() *time.Location {
+	if c.OutputLoc != nil {
+		return c.OutputLoc
+	}
+	if c.NormalizeToUTC {
+		return time.UTC
+	}
+	return nil
+}
+
+// feedDay returns the current date of the feed in TimeLoc, from the last time message or the local clock until one has arrived. Times of day sent without a date are placed on it before they are converted to OutputLoc or UTC.
+func (c *IQC) feedDay() time.Time {
+	if t, ok := c.FeedTime(); ok {
+		return t.In(c.TimeLoc)
+	}
+	return time.Now().In(c.TimeLoc)
 }
 
 // ProcessRegUpdMsg handles regional updates field definitions are available here: http://www.iqfeed.net/dev/api/docs/RegionalMessageFormat.cfm.
 func (c *IQC) processRegUpdMsg(d []byte) {
-	r := &RegionalMsg{}
+	r := &RegionalMsg{Received: c.received()}
 	r.UnMarshall(d, c.TimeLoc)
-	c.Regional <- r
+	if m, ok := c.listedMarket(r.MarketCenter); ok {
+		r.MarketCenterName = m.ShortName
+	}
+	if loc := c.outputLoc(); loc != nil {
+		r.inLoc(c.feedDay(), loc)
+	}
+	if !c.divert("Regional", c.Regional, r) {
+		select {
+		case c.Regional <- r:
+		case <-c.stop:
+		}
+	}
 }
 
 // ProcessFndMsg handles fundamental messages, field descriptions are available here: http://www.iqfeed.net/dev/api/docs/Level1FundamentalMessage.cfm.
 func (c *IQC) processFndMsg(d []byte) {
-	f := &FundamentalMsg{}
+	f := &FundamentalMsg{Received: c.received()}
 	f.UnMarshall(d, c.TimeLoc)
-	c.Fundamental <- f
-
+	f.Refreshed = c.refreshed(f.Symbol)
+	c.setPrecision(f.Symbol, f.DisplayPrecision())
+	c.setPriceFormat(f.Symbol, f.PriceFormat())
+	c.setAssetClass(f.Symbol, f.AssetClass())
+	if loc := c.outputLoc(); loc != nil {
+		f.inLoc(loc)
+	}
+	if !c.divert("Fundamental", c.Fundamental, f) {
+		select {
+		case c.Fundamental <- f:
+		case <-c.stop:
+		}
+	}
+	c.snapshotReceived(f.Symbol, true)
 }
 
 // ProcessNewsMsg handles summary messages, field definitions are available here: http://www.iqfeed.net/dev/api/docs/StreamingNewsMessageFormat.cfm.
+// A headline missing the fields before its text is reported as malformed rather than delivered.
 func (c *IQC) processNewsMsg(d []byte) {
-	n := &NewsMsg{}
+	if strings.Count(string(d), ",") < 4 {
+		c.malformedMsg(append([]byte("N,"), d...), "news message has too few fields")
+		return
+	}
+	n := &NewsMsg{Received: c.received()}
 	n.UnMarshall(d, c.TimeLoc)
-	c.News <- n
+	if loc := c.outputLoc(); loc != nil {
+		n.inLoc(loc)
+	}
+	if !c.divert("News", c.News, n) {
+		select {
+		case c.News <- n:
+		case <-c.stop:
+		}
+	}
 }
 
 // Process404Msg handles messages indicating that a symbol was not found.
 func (c *IQC) process404Msg(d []byte) {
-	e := &ErrorMsg{}
-	e.UnMarshall(true, d, 404)
-	c.Errors <- e
+	c.notFoundMsg(string(d), "n,"+string(d), c.received())
+}
+
+// notFoundMsg reports symbol as not found on Errors and stops treating it as watched, raw is the line it was reported in.
+func (c *IQC) notFoundMsg(symbol, raw string, recv Received) {
+	e := &ErrorMsg{Raw: raw, Received: recv}
+	e.UnMarshall(true, []byte(symbol), 404)
+	c.rememberNotFound(e.Symbol)
+	c.markUnwatched(e.Symbol)
+	c.deliverSnapshot(e.Symbol, snapshotResult{err: e})
+	if !c.divert("Errors", c.Errors, e) {
+		select {
+		case c.Errors <- e:
+		case <-c.stop:
+		}
+	}
+}
+
+// layoutMismatch reports a summary or update line holding more fields than the layout fields names as an ErrFieldLayout error, rather than letting the extra values be silently dropped.
+// It happens when the layout the feed uses is out of step with the one the client knows, a trailing empty field is not counted as IQFeed ends some lines with a comma.
+func (c *IQC) layoutMismatch(kind byte, d []byte, items []string, fields map[int]string, recv Received) bool {
+	n := len(items)
+	if n > 0 && items[n-1] == "" {
+		n--
+	}
+	if n <= len(fields) {
+		return false
+	}
+	c.parseError(append([]byte{kind, ','}, d...), items[0], fmt.Sprintf("message has %d fields but the update field layout only %d", n, len(fields)), ErrFieldLayout, recv)
+	return true
+}
+
+// malformedMsg reports a line that couldn't be parsed to Metrics and as an ErrorMsg wrapping ErrMalformedMessage on Errors, raw is the whole line.
+func (c *IQC) malformedMsg(raw []byte, reason string) {
+	c.parseError(raw, "", reason, ErrMalformedMessage, c.received())
+}
+
+// parseError reports a line that couldn't be parsed to Metrics and as an ErrorMsg wrapping err on Errors, raw is the whole line, received as recv, and symbol the one it was for when known.
+func (c *IQC) parseError(raw []byte, symbol, reason string, err error, recv Received) {
+	c.metrics().ParseError(raw[0], raw)
+	e := &ErrorMsg{Symbol: symbol, Message: reason, Code: 422, Err: err, Raw: string(raw), Received: recv}
+	if !c.divert("Errors", c.Errors, e) {
+		select {
+		case c.Errors <- e:
+		case <-c.stop:
+		}
+	}
 }
 
 // ProcessErrorMsg handles error messages in the form of error text.
 func (c *IQC) processErrorMsg(d []byte) {
-	e := &ErrorMsg{}
+	e := &ErrorMsg{Raw: "E," + string(d), Received: c.received()}
 	e.UnMarshall(false, d, 500)
-	c.Errors <- e
+	if e.Err == ErrSyntaxError {
+		// IQFeed doesn't echo the rejected command so the best we can do is report the last one we sent, along with the symbol it was for.
+		e.Command, _ = c.lastCommand.Load().(string)
+		e.Symbol = commandSymbol(e.Command)
+	}
+	if !c.divert("Errors", c.Errors, e) {
+		select {
+		case c.Errors <- e:
+		case <-c.stop:
+		}
+	}
 }
 
 // ProcessReceiver is one of the main reciever functions that interprets data received by IQFeed and processes it in sub functions.
+// The messages parsed from the line are stamped with the time it was read (and the line with KeepRaw), see Received.
+// A panic while handling a line is logged and reported as ErrMalformedMessage on Errors, the line is skipped so one bad message can't stop the reader.
 func (c *IQC) processReceiver(d []byte) {
-	if d == nil || len(d) <3 {
+	if !c.unstamped {
+		c.recv = c.stamp(d)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			c.log().Errorf("iqfeed: could not process %q: %v", d, r)
+			c.malformedMsg(d, fmt.Sprintf("could not process message: %v", r))
+		}
+	}()
+	if d == nil || len(d) < 3 {
+		if len(d) > 0 {
+			c.metrics().ParseError(d[0], d)
+		}
+		return
+	}
+	// The message type is the first field and always a single letter, anything else isn't a message this client knows how to route.
+	if bytes.IndexByte(d, ',') != 1 {
+		c.metrics().ParseError(d[0], d)
 		return
 	}
 	data := d[2:]
@@ -153,9 +870,9 @@ func (c *IQC) processReceiver(d []byte) {
 	case 0x53: // Start letter is S, indicating System message (Unicode representation in integer value).
 		c.processSysMsg(data)
 	case 0x50: // Start letter is P, indicating a summary message.
-		c.processSummaryMsg(data)
+		c.processSummaryMsg(data, c.recv)
 	case 0x51: // Start letter is Q, indicating an update message.
-		c.processUpdMsg(data)
+		c.processUpdMsg(data, c.recv)
 	case 0x54: // Start letter is T, indicating Time message.
 		c.processTimeMsg(data)
 	case 0x52: // Start letter is R, indicating regional update message
@@ -168,107 +885,158 @@ func (c *IQC) processReceiver(d []byte) {
 		c.process404Msg(data)
 	case 0x45: // Start letter is E, error message
 		c.processErrorMsg(data)
+	default:
+		c.metrics().ParseError(d[0], d)
 	}
 
 }
 
-// Read function does as expected and reads data from the network stream.
-func (c *IQC) read() {
-	r := bufio.NewReader(c.Conn)
-	for {
-		select {
-		case <-c.Quit:
-			log.Println("Client quitting")
+// readLine returns the next complete line from the reader, joining the fragments ReadLine returns when a line (typically a fundamental or news message) is longer than the reader's buffer.
+func readLine(r *bufio.Reader) ([]byte, error) {
+	line, isPrefix, err := r.ReadLine()
+	if !isPrefix {
+		return line, err
+	}
+	// ReadLine's slice is only valid until the next read so the fragments have to be copied.
+	full := append([]byte(nil), line...)
+	for isPrefix && err == nil {
+		line, isPrefix, err = r.ReadLine()
+		full = append(full, line...)
+	}
+	return full, err
+}
+
+// startReader sets up the shutdown plumbing and starts read (the live or replay reader) along with the ones watching Quit and, when StaleTimeout is set, the feed.
+func (c *IQC) startReader(read func()) {
+	if c.Quit == nil {
+		c.Quit = make(chan bool)
+	}
+	c.stop = make(chan struct{})
+	c.done = make(chan struct{})
+	c.touch()
+	go read()
+	go c.watchQuit()
+	if c.StaleTimeout > 0 {
+		c.workers.Add(1)
+		go c.watchdog()
+	}
+}
+
+// watchQuit stops the client as soon as Quit is closed or sent to, until the read goroutine exits on its own.
+func (c *IQC) watchQuit() {
+	select {
+	case <-c.Quit:
+		c.halt()
+	case <-c.done:
+	}
+}
+
+// watchContext stops the client once ctx is cancelled, until the read goroutine exits on its own.
+// It goes through Stop so the output channels are closed and consumers ranging over them end as they would after calling Stop.
+func (c *IQC) watchContext(ctx context.Context) {
+	select {
+	case <-ctx.Done():
+		c.Stop()
+	case <-c.done:
+	}
+}
+
+// stopErr returns the context error when the client was stopped by cancelling the context given to StartContext, err otherwise.
+func (c *IQC) stopErr(err error) error {
+	if c.ctx != nil && c.ctx.Err() != nil && c.stopped() {
+		return c.ctx.Err()
+	}
+	return err
+}
+
+// halt signals the read goroutine to stop and closes the connection to unblock a pending read, it is safe to call more than once.
+func (c *IQC) halt() {
+	c.haltOnce.Do(func() {
+		if c.stop != nil {
+			close(c.stop)
+		}
+		c.connMu.Lock()
+		if c.Conn != nil {
 			c.Conn.Close()
-			break
-		default:
-			line, isPrefix, err := r.ReadLine()
-
-			for err == nil && !isPrefix {
-				if c.CreateBackup {
-					bld := fmt.Sprintf("%s\r\n", string(line))
-					c.writeBackup([]byte(bld))
-				}
-				c.processReceiver(line)
-				line, isPrefix, err = r.ReadLine()
-			}
-			if isPrefix {
-				log.Println("buffer size to small")
-				//return Do not return and break the loop
-			}
-			if err != io.EOF {
-				log.Println("Pipe closed exiting...")
-				c.Conn.Close()
-				return
-			}
 		}
-	}
+		for s := range c.lookupConns {
+			s.conn.Close()
+		}
+		if c.l2Conn != nil {
+			c.l2Conn.Close()
+		}
+		if c.derivConn != nil {
+			c.derivConn.Close()
+		}
+		if c.adminConn != nil {
+			c.adminConn.Close()
+		}
+		c.connMu.Unlock()
+	})
+}
 
+// stopped reports whether the client has been stopped.
+func (c *IQC) stopped() bool {
+	if c.stop == nil {
+		return false
+	}
+	select {
+	case <-c.stop:
+		return true
+	default:
+		return false
+	}
 }
 
-func (c *IQC) getCallChar(t time.Time) string {
-	switch t.Month().String() {
-	case "January":
-		return "A"
-	case "February":
-		return "B"
-	case "March":
-		return "C"
-	case "April":
-		return "D"
-	case "May":
-		return "E"
-	case "June":
-		return "F"
-	case "July":
-		return "G"
-	case "August":
-		return "H"
-	case "September":
-		return "I"
-	case "October":
-		return "J"
-	case "November":
-		return "K"
-	case "December":
-		return "L"
-	}
-	return "A"
-}
-
-func (c *IQC) getPutChar(t time.Time) string {
-	switch t.Month().String() {
-	case "January":
-		return "M"
-	case "February":
-		return "N"
-	case "March":
-		return "O"
-	case "April":
-		return "P"
-	case "May":
-		return "Q"
-	case "June":
-		return "R"
-	case "July":
-		return "S"
-	case "August":
-		return "T"
-	case "September":
-		return "U"
-	case "October":
-		return "V"
-	case "November":
-		return "W"
-	case "December":
-		return "X"
-	}
-	return "M"
+// Stop shuts the client down, it stops the read goroutine, waits for it to exit and then closes every output channel so consumers ranging over them terminate. Calling Stop more than once, or on a client that failed to start, is safe.
+// Messages already buffered on the channels are not dropped, consumers ranging over a channel receive them before the loop ends. Cancelling the context given to StartContext shuts down the same way.
+func (c *IQC) Stop() {
+	c.stopOnce.Do(func() {
+		c.halt()
+		if c.done != nil {
+			<-c.done
+		}
+		c.stopThrottles()
+		if err := c.closeBackup(); err != nil {
+			c.log().Errorf("%s", err)
+		}
+		c.workers.Wait()
+		c.closeSubscriptions()
+		if c.System == nil {
+			// Start failed or was never called, the channels don't exist yet.
+			return
+		}
+		close(c.System)
+		close(c.News)
+		close(c.Errors)
+		close(c.Fundamental)
+		close(c.Regional)
+		close(c.Time)
+		close(c.Updates)
+		close(c.Quotes)
+		if c.Merged != nil {
+			close(c.Merged)
+		}
+		if c.Connection != nil {
+			close(c.Connection)
+		}
+		if c.Depth != nil {
+			close(c.Depth)
+		}
+		if c.Stats != nil {
+			close(c.Stats)
+		}
+		if c.Bars != nil {
+			close(c.Bars)
+		}
+		if c.Messages != nil {
+			close(c.Messages)
+		}
+	})
 }
 
-// Start function will start the concurrent functions to read and write data to the and from the network stream.
-func (c *IQC) Start(connectString string, bufferSize int) *IQC {
-	c.connect(connectString)
+// makeChannels creates every output channel with bufferSize slots.
+func (c *IQC) makeChannels(bufferSize int) {
 	c.System = make(chan *SystemMessage, bufferSize)
 	c.News = make(chan *NewsMsg, bufferSize)
 	c.Errors = make(chan *ErrorMsg, bufferSize)
@@ -276,10 +1044,140 @@ func (c *IQC) Start(connectString string, bufferSize int) *IQC {
 	c.Regional = make(chan *RegionalMsg, bufferSize)
 	c.Time = make(chan *TimeMsg, bufferSize)
 	c.Updates = make(chan *UpdSummaryMsg, bufferSize)
-	go c.read()
-	
-	c.ReqCurrentUpdateFNames()
-	//c.RequestListedMarkets()
-	return c
+	c.Quotes = make(chan *Quote, bufferSize)
+	c.Merged = make(chan *UpdSummaryMsg, bufferSize)
+	c.Connection = make(chan *ConnectionEvent, bufferSize)
+	c.Depth = make(chan *L2Msg, bufferSize)
+	c.Stats = make(chan *ClientStats, bufferSize)
+	c.Bars = make(chan *IntervalBar, bufferSize)
+	if c.UnifiedMessages {
+		c.Messages = make(chan Message, bufferSize)
+	}
+}
+
+// Read function does as expected and reads data from the network stream.
+// When ReconnectEnabled is set a lost connection is re-dialled and reading resumes on the new one.
+func (c *IQC) read() {
+	defer close(c.done)
+	conn := c.conn()
+	r := c.newReader(conn)
+	for {
+		if c.ReadTimeout > 0 {
+			conn.SetReadDeadline(time.Now().Add(c.ReadTimeout))
+		}
+		line, err := readLine(r)
+		if err != nil {
+			atomic.StoreInt32(&c.connected, 0)
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				err = fmt.Errorf("nothing received for %s: %w", c.ReadTimeout, err)
+			}
+			select {
+			case <-c.stop:
+				c.log().Infof("Client quitting")
+				c.conn().Close()
+				return
+			default:
+			}
+			if !c.ReconnectEnabled || c.connectString == "" {
+				c.log().Errorf("Pipe closed exiting...")
+				c.conn().Close()
+				return
+			}
+			c.log().Warnf("Connection lost, reconnecting: %s", err)
+			var ok bool
+			conn, ok = c.reconnect(err)
+			if !ok {
+				return
+			}
+			r = c.newReader(conn)
+			continue
+		}
+		c.touch()
+		if len(line) > 0 {
+			c.metrics().MessageReceived(line[0], len(line)+2)
+		}
+		if c.CreateBackup {
+			bld := fmt.Sprintf("%s\r\n", string(line))
+			c.backupLine([]byte(bld))
+		}
+		c.processReceiver(line)
+	}
+}
 
+// Start function will start the concurrent functions to read and write data to the and from the network stream.
+// An empty connectString connects to port 5009 on Host (localhost by default), as does a port alone (ex: :5010) on that port, an error is returned if the timezone can't be loaded or IQFeed can't be reached.
+// Nothing listening on the port fails with ErrIQConnectNotRunning, IQConnect has to be running and logged in first (see LaunchIQConnect).
+// When a protocol version is given it is negotiated with SetProtocol before the field names are requested, since their format depends on it, and Start fails if the feed doesn't confirm it.
+// Start returns once the feed has sent the current update field names (see ReqCurrentUpdateFNames), failing with ErrTimeout if they don't arrive within ConfirmTimeout.
+func (c *IQC) Start(connectString string, bufferSize int, protocol ...string) (*IQC, error) {
+	return c.StartContext(context.Background(), connectString, bufferSize, protocol...)
+}
+
+// StartContext is Start bound to ctx. Cancelling ctx stops the client with Stop: the read goroutine exits, the connections and output channels are closed and lookups in flight return ctx.Err().
+// Calling Stop as well afterwards is harmless.
+func (c *IQC) StartContext(ctx context.Context, connectString string, bufferSize int, protocol ...string) (*IQC, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	c.ctx = ctx
+	if err := c.connect(connectString); err != nil {
+		return nil, err
+	}
+	return c.start(ctx, bufferSize, protocol)
+}
+
+// StartConn starts the client on an already established connection to IQFeed instead of dialling one, for tunnels, proxies or canned byte streams in tests.
+// Everything else is as with Start, except that ReconnectEnabled has no effect since there is no address to re-dial.
+func (c *IQC) StartConn(conn io.ReadWriteCloser, bufferSize int, protocol ...string) (*IQC, error) {
+	if err := c.prepare(); err != nil {
+		return nil, err
+	}
+	c.Conn = wrapConn(conn)
+	return c.start(context.Background(), bufferSize, protocol)
+}
+
+// StartStreams starts the client reading the feed from r and writing commands to w, for transports that don't come as a single connection such as a pair of named pipes.
+// It is StartConn on the two joined together, Stop closes r and w when they implement io.Closer.
+func (c *IQC) StartStreams(r io.Reader, w io.Writer, bufferSize int, protocol ...string) (*IQC, error) {
+	return c.StartConn(&splitStream{r: r, w: w}, bufferSize, protocol...)
+}
+
+// start creates the output channels, starts reading from Conn and sends the initial commands.
+func (c *IQC) start(ctx context.Context, bufferSize int, protocol []string) (*IQC, error) {
+	c.makeChannels(bufferSize)
+	if c.LogErrors {
+		c.DrainErrors()
+	}
+	// Registered before reading starts so field names the feed sends straight away aren't missed.
+	fields, done := c.awaitFields()
+	defer done()
+	atomic.StoreInt32(&c.connected, 1)
+	c.startReader(c.read)
+	if ctx.Done() != nil {
+		go c.watchContext(ctx)
+	}
+
+	if len(protocol) > 0 && protocol[0] != "" {
+		if err := c.SetProtocol(protocol[0]); err != nil {
+			c.Stop()
+			return nil, fmt.Errorf("iqfeed: could not set protocol %s: %w", protocol[0], err)
+		}
+	}
+	if c.TimestampsOff {
+		c.DisableTimestamps()
+	}
+	if c.ClientName != "" {
+		c.SetClientName(c.ClientName)
+	}
+	// Summary and update messages can't be parsed until the layout is known, so don't hand out a client that would have to guess it.
+	if err := c.requestFields(fields); err != nil {
+		c.Stop()
+		return nil, fmt.Errorf("iqfeed: the feed did not send its update field names: %w", err)
+	}
+	if c.LoadListedMarkets {
+		if _, err := c.RequestListedMarkets(); err != nil {
+			c.log().Warnf("Could not load the listed markets: %s", err)
+		}
+	}
+	return c, nil
 }
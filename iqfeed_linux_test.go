@@ -0,0 +1,34 @@
+package iqfeed
+
+import (
+	"net"
+	"syscall"
+	"testing"
+)
+
+func TestSocketBuffers(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("cannot listen: %s", err)
+	}
+	defer l.Close()
+	c := &IQC{TimeZone: "UTC", SocketReadBuffer: 1 << 17, SocketWriteBuffer: 1 << 16}
+	if err := c.connect(l.Addr().String()); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Conn.Close()
+
+	raw, err := c.Conn.(*net.TCPConn).SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rcv int
+	raw.Control(func(fd uintptr) { rcv, err = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_RCVBUF) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The kernel doubles the requested size for bookkeeping (up to net.core.rmem_max) so the reported size is at least what was asked for.
+	if rcv < c.SocketReadBuffer {
+		t.Errorf("receive buffer was not applied, got %d", rcv)
+	}
+}
@@ -1,6 +1,30 @@
 package iqfeed
 
-import "testing"
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
 
 func TestStart(t *testing.T) {
 	/*dataChan := make(chan []byte)
@@ -13,3 +37,2721 @@ func TestStart(t *testing.T) {
 	*/
 
 }
+
+// recordConn is a net.Conn that records everything written to it.
+type recordConn struct {
+	net.Conn
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (r *recordConn) Write(b []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.buf.Write(b)
+}
+
+func (r *recordConn) Close() error { return nil }
+
+// String returns everything written to the connection so far.
+func (r *recordConn) String() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.buf.String()
+}
+
+// newTestClient returns a client with its channels set up but no network connection, for feeding lines straight into processReceiver.
+func newTestClient() *IQC {
+	c := &IQC{TimeLoc: time.UTC, DynFields: make(map[int]string), Conn: &recordConn{}}
+	c.System = make(chan *SystemMessage, 16)
+	c.News = make(chan *NewsMsg, 16)
+	c.Errors = make(chan *ErrorMsg, 16)
+	c.Fundamental = make(chan *FundamentalMsg, 16)
+	c.Regional = make(chan *RegionalMsg, 16)
+	c.Time = make(chan *TimeMsg, 16)
+	c.Updates = make(chan *UpdSummaryMsg, 16)
+	c.Quotes = make(chan *Quote, 16)
+	return c
+}
+
+func TestUpdateBeforeFieldNames(t *testing.T) {
+	c := newTestClient()
+	c.processReceiver([]byte("P,AAPL,95.0200,100"))
+	c.processReceiver([]byte("Q,AAPL,95.0300,200"))
+	if len(c.Updates) != 0 {
+		t.Fatalf("expected updates to be held until field names arrive, got %d", len(c.Updates))
+	}
+
+	c.processReceiver([]byte("S,CURRENT UPDATE FIELDNAMES,Symbol,Last,Bid Size"))
+	if len(c.Updates) != 2 {
+		t.Fatalf("expected 2 buffered updates to be delivered, got %d", len(c.Updates))
+	}
+	for _, want := range []struct {
+		last float64
+		size int
+	}{{95.02, 100}, {95.03, 200}} {
+		u := <-c.Updates
+		if u.Symbol != "AAPL" || u.Last != want.last || u.BidSize != want.size {
+			t.Errorf("got %s %v %d, want AAPL %v %d", u.Symbol, u.Last, u.BidSize, want.last, want.size)
+		}
+	}
+}
+
+func TestFieldLayoutMismatch(t *testing.T) {
+	c := newTestClient()
+	c.processReceiver([]byte("S,CURRENT UPDATE FIELDNAMES,Symbol,Last"))
+	c.processReceiver([]byte("Q,AAPL,95.02,"))
+	c.processReceiver([]byte("Q,AAPL,95.03,100,09:30:00"))
+	c.processReceiver([]byte("P,MSFT,52.10,200"))
+	if u := <-c.Updates; u.Last != 95.02 || len(c.Updates) != 0 {
+		t.Errorf("expected only the line matching the layout to be delivered, got %+v and %d more", u, len(c.Updates))
+	}
+	for _, want := range []string{"AAPL", "MSFT"} {
+		e := <-c.Errors
+		if !errors.Is(e, ErrFieldLayout) || e.Symbol != want || !strings.HasSuffix(e.Message, "layout only 2") {
+			t.Errorf("unexpected error %+v", e)
+		}
+	}
+
+	// Lines dropped while waiting for the field names are reported too.
+	c = newTestClient()
+	c.Errors = make(chan *ErrorMsg, 1)
+	c.Logger = NopLogger{}
+	for i := 0; i <= maxPendingUpdates; i++ {
+		c.processReceiver([]byte("Q,AAPL,95.02"))
+	}
+	if e := <-c.Errors; !errors.Is(e, ErrFieldLayout) || e.Raw != "Q,AAPL,95.02" {
+		t.Errorf("unexpected error %+v", e)
+	}
+}
+
+func TestReceivedAt(t *testing.T) {
+	c := newTestClient()
+	before := time.Now()
+	c.processReceiver([]byte("Q,AAPL,95.0300,200"))
+	held := time.Now()
+	c.processReceiver([]byte("S,CURRENT UPDATE FIELDNAMES,Symbol,Last,Bid Size"))
+	c.processReceiver([]byte("T,20160314 09:30:00"))
+
+	s := <-c.System
+	u := <-c.Updates
+	tm := <-c.Time
+	// An update held back for the field names keeps the time it was read, not the time it was parsed.
+	if u.ReceivedAt.Before(before) || u.ReceivedAt.After(held) {
+		t.Errorf("update received at %s, want between %s and %s", u.ReceivedAt, before, held)
+	}
+	if s.ReceivedAt.Before(held) || tm.ReceivedAt.Before(s.ReceivedAt) {
+		t.Errorf("unexpected receive times: system %s, time %s", s.ReceivedAt, tm.ReceivedAt)
+	}
+	if u.Line != "" || s.Line != "" {
+		t.Errorf("expected no lines without KeepRaw, got %q and %q", u.Line, s.Line)
+	}
+}
+
+func TestKeepRaw(t *testing.T) {
+	c := newTestClient()
+	c.KeepRaw = true
+	c.processReceiver([]byte("Q,AAPL,95.0300,200"))
+	c.processReceiver([]byte("S,CURRENT UPDATE FIELDNAMES,Symbol,Last,Bid Size"))
+	c.processReceiver([]byte("Q,MSFT,51.1200,300"))
+	c.processReceiver([]byte("n,ZZZZ"))
+
+	s := <-c.System
+	// The update held back for the field names keeps its own line.
+	for _, want := range []string{"Q,AAPL,95.0300,200", "Q,MSFT,51.1200,300"} {
+		if u := <-c.Updates; u.Line != want {
+			t.Errorf("got line %q, want %q", u.Line, want)
+		}
+	}
+	if want := "S,CURRENT UPDATE FIELDNAMES,Symbol,Last,Bid Size"; s.Line != want {
+		t.Errorf("got line %q, want %q", s.Line, want)
+	}
+	if e := <-c.Errors; e.Line != "n,ZZZZ" {
+		t.Errorf("got line %q, want %q", e.Line, "n,ZZZZ")
+	}
+}
+
+func TestNormalizeToUTCAcrossDST(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("timezone data unavailable: %s", err)
+	}
+	c := newTestClient()
+	c.TimeLoc = loc
+	c.NormalizeToUTC = true
+	// US daylight saving started at 2am on 03/13/2016, moving New York from UTC-5 to UTC-4.
+	c.processReceiver([]byte("T,20160312 09:30:00"))
+	c.processReceiver([]byte("T,20160314 09:30:00"))
+	for _, want := range []time.Time{
+		time.Date(2016, 3, 12, 14, 30, 0, 0, time.UTC),
+		time.Date(2016, 3, 14, 13, 30, 0, 0, time.UTC),
+	} {
+		tm := <-c.Time
+		if tm.TimeStamp.Location() != time.UTC {
+			t.Errorf("expected UTC location, got %s", tm.TimeStamp.Location())
+		}
+		if !tm.TimeStamp.Equal(want) {
+			t.Errorf("got %s, want %s", tm.TimeStamp, want)
+		}
+	}
+
+	// Times of day are dated with the feed date before converting, on either side of the change.
+	c.processReceiver([]byte("S,CURRENT UPDATE FIELDNAMES,Symbol,Most Recent Trade TimeMS,Bid Time"))
+	for _, day := range []string{"20160312", "20160314"} {
+		c.processReceiver([]byte("T," + day + " 09:30:00"))
+		<-c.Time
+		c.processReceiver([]byte("Q,AAPL,09:30:00.250,09:30:01"))
+		c.processReceiver([]byte("R,AAPL,,95.01,300,09:30:00.123456,95.04,400,09:30:01,14,4,5"))
+	}
+	for _, want := range []time.Time{
+		time.Date(2016, 3, 12, 14, 30, 0, 0, time.UTC),
+		time.Date(2016, 3, 14, 13, 30, 0, 0, time.UTC),
+	} {
+		u := <-c.Updates
+		if !u.MostRecentTradeTime.Equal(want.Add(250*time.Millisecond)) || !u.BidTime.Equal(want.Add(time.Second)) || u.BidTime.Location() != time.UTC {
+			t.Errorf("update times %s %s, want %s", u.MostRecentTradeTime, u.BidTime, want)
+		}
+		r := <-c.Regional
+		if !r.RegBidTime.Equal(want.Add(123456*time.Microsecond)) || !r.RegAskTime.Equal(want.Add(time.Second)) || r.RegAskTime.Location() != time.UTC {
+			t.Errorf("regional times %s %s, want %s", r.RegBidTime, r.RegAskTime, want)
+		}
+	}
+}
+
+func TestOutputLoc(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("timezone data unavailable: %s", err)
+	}
+	cet := time.FixedZone("CET", 3600)
+	c := newTestClient()
+	c.TimeLoc = loc
+	c.NormalizeToUTC = true
+	c.OutputLoc = cet
+	c.processReceiver([]byte("T,20160314 09:30:00"))
+	want := time.Date(2016, 3, 14, 14, 30, 0, 0, cet)
+	if tm := <-c.Time; !tm.TimeStamp.Equal(want) || tm.TimeStamp.Location() != cet {
+		t.Errorf("got %s, want %s", tm.TimeStamp, want)
+	}
+	c.processReceiver([]byte("S,CURRENT UPDATE FIELDNAMES,Symbol,Most Recent Trade TimeMS,Bid Time"))
+	c.processReceiver([]byte("Q,AAPL,09:30:00.250,"))
+	u := <-c.Updates
+	if !u.MostRecentTradeTime.Equal(want.Add(250*time.Millisecond)) || u.MostRecentTradeTime.Location() != cet {
+		t.Errorf("trade time %s, want %s", u.MostRecentTradeTime, want)
+	}
+	// Fields the message doesn't carry stay zero rather than being moved to OutputLoc.
+	if u.BidTime != (time.Time{}) {
+		t.Errorf("expected a zero bid time, got %s", u.BidTime)
+	}
+}
+
+func TestOnWatchChange(t *testing.T) {
+	c := newTestClient()
+	var added, removed []string
+	c.OnWatchChange = func(a, r []string) {
+		added = append(added, a...)
+		removed = append(removed, r...)
+	}
+	c.WatchSymbol("AAPL")
+	c.WatchSymbol("AAPL")
+	c.TradeOnlyWatch("MSFT")
+	c.UnwatchSymbol("IBM")
+	c.UnwatchSymbol("AAPL")
+	if !reflect.DeepEqual(added, []string{"AAPL", "MSFT"}) {
+		t.Errorf("added = %v", added)
+	}
+	if !reflect.DeepEqual(removed, []string{"AAPL"}) {
+		t.Errorf("removed = %v", removed)
+	}
+
+	removed = nil
+	c.WatchSymbol("GOOG")
+	c.UnwatchAllSymbols()
+	if !reflect.DeepEqual(removed, []string{"GOOG", "MSFT"}) {
+		t.Errorf("removed on unwatch all = %v", removed)
+	}
+}
+
+func TestWatchSymbolValidation(t *testing.T) {
+	c := newTestClient()
+	conn := c.Conn.(*recordConn)
+	for _, s := range []string{"", "   ", "AA PL", "AAPL,MSFT", "AAPL\r\nS,UNWATCH ALL"} {
+		if err := c.WatchSymbol(s); !errors.Is(err, ErrInvalidSymbol) {
+			t.Errorf("WatchSymbol(%q) = %v, want ErrInvalidSymbol", s, err)
+		}
+	}
+	if err := c.WatchSymbols([]string{"IBM", " "}); !errors.Is(err, ErrInvalidSymbol) {
+		t.Errorf("expected the batch to be rejected, got %v", err)
+	}
+	if conn.String() != "" {
+		t.Errorf("invalid symbols were sent: %q", conn.String())
+	}
+
+	if err := c.WatchSymbol(" aapl "); err != nil {
+		t.Fatal(err)
+	}
+	c.UppercaseSymbols = true
+	if err := c.WatchSymbol(" msft\t"); err != nil {
+		t.Fatal(err)
+	}
+	if conn.String() != "waapl\r\nwMSFT\r\n" {
+		t.Errorf("wrote %q", conn.String())
+	}
+	if w := c.WatchedSymbols(); !reflect.DeepEqual(w, []string{"MSFT", "aapl"}) {
+		t.Errorf("watched %v", w)
+	}
+}
+
+func TestCapabilities(t *testing.T) {
+	c := newTestClient()
+	if c.Capabilities().Known {
+		t.Fatal("capabilities should be unknown before S,CUST")
+	}
+	c.processReceiver([]byte("S,CUST,real_time,66.112.156.228,60002,0,6.1.0.20,0,AMEX NASDAQ NYSE OPRA ,,500,QT_API,,"))
+	<-c.System
+	caps := c.Capabilities()
+	if !caps.Known || !caps.RealTime || caps.MaxSymbols != 500 {
+		t.Errorf("unexpected capabilities %+v", caps)
+	}
+	if !caps.HasExchange("OPRA") || caps.HasExchange("CME") {
+		t.Errorf("unexpected exchanges %v", caps.Exchanges)
+	}
+	if !caps.HasFlag("QT_API") {
+		t.Errorf("unexpected flags %v", caps.Flags)
+	}
+
+	c.processReceiver([]byte("S,CUST,delayed,66.112.156.228,60002,0,6.1.0.20,0,,,100,NO_EOD,,"))
+	<-c.System
+	if caps = c.Capabilities(); caps.RealTime || !caps.HasFlag("NO_EOD") {
+		t.Errorf("unexpected delayed capabilities %+v", caps)
+	}
+}
+
+func TestSyntaxError(t *testing.T) {
+	c := newTestClient()
+	c.SendRaw("S,NOT A COMMAND")
+	c.processReceiver([]byte("E,!SYNTAX_ERROR!,"))
+	e := <-c.Errors
+	if !errors.Is(e, ErrSyntaxError) {
+		t.Fatalf("expected ErrSyntaxError, got %v", e)
+	}
+	if e.Command != "S,NOT A COMMAND" {
+		t.Errorf("expected offending command to be reported, got %q", e.Command)
+	}
+}
+
+func TestErrorMessagesCarrySymbol(t *testing.T) {
+	c := newTestClient()
+	c.processReceiver([]byte("S,CURRENT UPDATE FIELDNAMES,Symbol,Last"))
+	for _, tc := range []struct {
+		cmd, line string
+		code      int
+		symbol    string
+		message   string
+	}{
+		{"", "n,ZZZZ", 404, "ZZZZ", "Symbol not found"},
+		{"", "Q,YYYY,,Not Found", 404, "YYYY", "Symbol not found"},
+		{"wXXXX", "E,!SYNTAX_ERROR!,", 500, "XXXX", "!SYNTAX_ERROR!"},
+		{"S,REGON,WWWW", "E,!SYNTAX_ERROR!,", 500, "WWWW", "!SYNTAX_ERROR!"},
+		{"S,REQUEST STATS", "E,Some other error", 500, "", "Some other error"},
+	} {
+		if tc.cmd != "" {
+			c.SendRaw(tc.cmd)
+		}
+		c.processReceiver([]byte(tc.line))
+		e := <-c.Errors
+		if e.Code != tc.code || e.Symbol != tc.symbol || e.Message != tc.message || e.Raw != tc.line {
+			t.Errorf("%s: unexpected error %+v", tc.line, e)
+		}
+	}
+}
+
+func TestSymbolThrottle(t *testing.T) {
+	c := newTestClient()
+	c.EmitQuotes = true
+	c.processReceiver([]byte("S,CURRENT UPDATE FIELDNAMES,Symbol,Most Recent Trade,Bid Size"))
+	c.SetSymbolThrottle("AAPL", 50*time.Millisecond)
+	for i := 0; i < 5; i++ {
+		c.processReceiver([]byte(fmt.Sprintf("Q,AAPL,95.0%d,100", i)))
+		c.processReceiver([]byte("Q,MSFT,52.10,100"))
+	}
+	// A sparse delta ends the burst, it must not hide the price before it.
+	c.processReceiver([]byte("Q,AAPL,,300"))
+	if len(c.Updates) != 6 || len(c.Quotes) != 6 || c.ThrottledUpdates() != 5 {
+		t.Fatalf("expected 6 delivered and 5 throttled, got %d and %d", len(c.Updates), c.ThrottledUpdates())
+	}
+	for i := 0; i < 6; i++ {
+		<-c.Updates
+		<-c.Quotes
+	}
+
+	// The held back updates are delivered as one once the interval expires.
+	select {
+	case u := <-c.Updates:
+		if u.Symbol != "AAPL" || u.MostRecentTrade != 95.04 || u.BidSize != 300 {
+			t.Errorf("unexpected trailing update %+v", u)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the throttled updates to be delivered after the interval")
+	}
+	if q := <-c.Quotes; q.Last != 95.04 || q.BidSize != 300 {
+		t.Errorf("unexpected trailing quote %+v", q)
+	}
+
+	c.SetSymbolThrottle("AAPL", 0)
+	c.processReceiver([]byte("Q,AAPL,95.05,100"))
+	if len(c.Updates) != 1 || c.ThrottledUpdates() != 5 {
+		t.Errorf("expected throttle to be removed, got %d delivered and %d throttled", len(c.Updates), c.ThrottledUpdates())
+	}
+}
+
+func TestFieldLayoutFlip(t *testing.T) {
+	c := newTestClient()
+	c.SetSymbolThrottle("AAPL", 50*time.Millisecond)
+	c.processReceiver([]byte("S,CURRENT UPDATE FIELDNAMES,Symbol,Bid,Last"))
+	c.processReceiver([]byte("Q,AAPL,95.01,95.02"))
+	c.processReceiver([]byte("Q,AAPL,95.03,"))
+	c.processReceiver([]byte("Q,MSFT,52.01,52.02"))
+	// The order flips while an AAPL update is held back by the throttle.
+	c.processReceiver([]byte("S,CURRENT UPDATE FIELDNAMES,Symbol,Last,Bid"))
+	c.processReceiver([]byte("Q,MSFT,52.04,52.03"))
+	c.processReceiver([]byte("Q,AAPL,95.05,"))
+
+	first, msft, flipped := <-c.Updates, <-c.Updates, <-c.Updates
+	if first.Bid != 95.01 || first.Last != 95.02 || msft.Bid != 52.01 || msft.Last != 52.02 {
+		t.Errorf("before the flip: %+v / %+v", first, msft)
+	}
+	if flipped.Bid != 52.03 || flipped.Last != 52.04 || flipped.FieldsVersion != first.FieldsVersion+1 {
+		t.Errorf("after the flip: bid %v last %v version %d", flipped.Bid, flipped.Last, flipped.FieldsVersion)
+	}
+	select {
+	case u := <-c.Updates:
+		// The bid held back under the old layout is kept, the new last comes from the new one.
+		if u.Symbol != "AAPL" || u.Bid != 95.03 || u.Last != 95.05 || u.FieldsVersion != flipped.FieldsVersion {
+			t.Errorf("trailing update = bid %v last %v version %d", u.Bid, u.Last, u.FieldsVersion)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the throttled updates to be delivered")
+	}
+}
+
+func TestExtraFields(t *testing.T) {
+	c := newTestClient()
+	c.processReceiver([]byte("S,CURRENT UPDATE FIELDNAMES,Symbol,Last,Some New Field,Another One"))
+	c.processReceiver([]byte("Q,AAPL,95.02,xyz,"))
+	u := <-c.Updates
+	if u.Last != 95.02 || !reflect.DeepEqual(u.Extra, map[string]string{"Some New Field": "xyz", "Another One": ""}) {
+		t.Errorf("last %v extra %q", u.Last, u.Extra)
+	}
+	c.processReceiver([]byte("S,CURRENT UPDATE FIELDNAMES,Symbol,Last"))
+	c.processReceiver([]byte("Q,AAPL,95.03"))
+	if u := <-c.Updates; u.Extra != nil {
+		t.Errorf("expected no extra fields, got %q", u.Extra)
+	}
+}
+
+func TestExtendedHoursTrade(t *testing.T) {
+	c := newTestClient()
+	c.processReceiver([]byte("S,CURRENT UPDATE FIELDNAMES,Symbol,Most Recent Trade,Most Recent Trade Size,Most Recent Trade Time,Last,Last Size,Last Time,Extended Trade,Extended Trade Size,Extended Trade Time,Message Contents"))
+	c.processReceiver([]byte("Q,AAPL,95.10,50,16:05:00.000123,95.02,100,15:59:59.500000,95.10,50,16:05:00.000123,Ev"))
+	u := <-c.Updates
+	if u.Last != 95.02 || u.LastSize != 100 || u.LastTime.Hour() != 15 || u.LastTime.Nanosecond() != 500000000 {
+		t.Errorf("regular session fields %v %d %s", u.Last, u.LastSize, u.LastTime)
+	}
+	if u.ExtendedTrdLast != 95.10 || u.ExtendedTrdSize != 50 || u.ExtendedTrdTime.Nanosecond() != 123000 {
+		t.Errorf("extended trade fields %v %d %s", u.ExtendedTrdLast, u.ExtendedTrdSize, u.ExtendedTrdTime)
+	}
+	if !u.ExtendedHours() {
+		t.Error("expected an extended hours trade")
+	}
+	c.processReceiver([]byte("Q,AAPL,95.03,100,15:59:59.900000,95.03,100,15:59:59.900000,95.03,100,15:59:59.900000,Cv"))
+	if u := <-c.Updates; u.ExtendedHours() {
+		t.Error("expected a regular session trade")
+	}
+
+	// Without Message Contents the trade times tell them apart.
+	c.processReceiver([]byte("S,CURRENT UPDATE FIELDNAMES,Symbol,Most Recent Trade Time,Last Time,Extended Trade Time"))
+	c.processReceiver([]byte("Q,AAPL,16:05:00.000123,15:59:59.500000,16:05:00.000123"))
+	if u := <-c.Updates; !u.ExtendedHours() {
+		t.Error("expected an extended hours trade from the trade times")
+	}
+}
+
+func TestMarshalUpdate(t *testing.T) {
+	c := newTestClient()
+	c.processReceiver([]byte("S,CURRENT UPDATE FIELDNAMES,Symbol,Last,Most Recent Trade TimeMS,Most Recent Trade Conditions,Bid"))
+	c.processReceiver([]byte("Q,AAPL,95.0200,09:30:00.123456,01,,"))
+	u := <-c.Updates
+	if got := u.FieldNames(); !reflect.DeepEqual(got, []string{"Symbol", "Last", "Most Recent Trade TimeMS", "Most Recent Trade Conditions", "Bid"}) {
+		t.Errorf("field names = %q", got)
+	}
+
+	b, err := u.MarshalCSV(nil)
+	if want := "AAPL,95.0200,09:30:00.123456,01,\n"; err != nil || string(b) != want {
+		t.Errorf("MarshalCSV(nil) = %q, %v, want %q", b, err, want)
+	}
+	if b, _ := u.MarshalCSV([]string{"Bid", "Last", "Not Selected"}); string(b) != ",95.0200,\n" {
+		t.Errorf("MarshalCSV = %q", b)
+	}
+
+	b, err = json.Marshal(u)
+	if want := `{"Symbol":"AAPL","Last":95.0200,"Most Recent Trade TimeMS":"09:30:00.123456","Most Recent Trade Conditions":"01","Bid":null}`; err != nil || string(b) != want {
+		t.Errorf("json = %s, %v, want %s", b, err, want)
+	}
+	// Messages built by hand have no layout and keep the struct encoding.
+	if b, err := json.Marshal(&UpdSummaryMsg{Symbol: "AAPL"}); err != nil || !strings.Contains(string(b), `"Symbol":"AAPL"`) {
+		t.Errorf("json = %s, %v", b, err)
+	}
+}
+
+func TestFeedTime(t *testing.T) {
+	c := newTestClient()
+	if _, ok := c.FeedTime(); ok {
+		t.Fatal("expected no feed time before the first time message")
+	}
+	c.processReceiver([]byte("T,20160314 09:30:00"))
+	c.processReceiver([]byte("T,20160314 09:30:01"))
+	c.processReceiver([]byte("T,20160314 09:30:02"))
+	ft, ok := c.FeedTime()
+	if want := time.Date(2016, 3, 14, 9, 30, 2, 0, time.UTC); !ok || !ft.Equal(want) {
+		t.Errorf("got %s, want %s", ft, want)
+	}
+}
+
+func TestCoalesceTimestamps(t *testing.T) {
+	c := newTestClient()
+	c.CoalesceTimestamps = true
+	for _, l := range []string{"T,20160314 09:30:00", "T,20160314 09:30:00", "T,20160314 09:29:59", "T,garbage", "T,20160314 09:30:01"} {
+		c.processReceiver([]byte(l))
+	}
+	var got []string
+	for len(c.Time) > 0 {
+		got = append(got, (<-c.Time).Raw)
+	}
+	if strings.Join(got, ",") != "20160314 09:30:00,garbage,20160314 09:30:01" {
+		t.Errorf("unexpected time messages %q", got)
+	}
+	// The feed's clock still follows every message.
+	if ft, _ := c.FeedTime(); !ft.Equal(time.Date(2016, 3, 14, 9, 30, 1, 0, time.UTC)) {
+		t.Errorf("FeedTime() = %s", ft)
+	}
+}
+
+func TestTimeMsg(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("timezone data unavailable: %s", err)
+	}
+	c := newTestClient()
+	c.TimeLoc = loc
+	c.processReceiver([]byte("T,20160314 09:30:00"))
+	c.processReceiver([]byte("T,garbage"))
+	tm := <-c.Time
+	if tm.Raw != "20160314 09:30:00" || tm.TimeStamp.Location() != loc || !tm.TimeStamp.Equal(time.Date(2016, 3, 14, 13, 30, 0, 0, time.UTC)) {
+		t.Errorf("unexpected time message %+v", tm)
+	}
+	if tm := <-c.Time; tm.Raw != "garbage" || !tm.TimeStamp.IsZero() {
+		t.Errorf("expected a zero timestamp for a malformed message, got %+v", tm)
+	}
+}
+
+// fieldsLine is the field layout test feeds send, Start waits for one before returning.
+const fieldsLine = "S,CURRENT UPDATE FIELDNAMES,Symbol,Last\r\n"
+
+// feedListener accepts connections in the background and sends fieldsLine on each, so a test can Start a client before calling Accept.
+type feedListener struct {
+	net.Listener
+	conns chan net.Conn
+}
+
+func listenFeed(t *testing.T) *feedListener {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("cannot listen: %s", err)
+	}
+	f := &feedListener{Listener: l, conns: make(chan net.Conn, 16)}
+	go func() {
+		defer close(f.conns)
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write([]byte(fieldsLine))
+			f.conns <- conn
+		}
+	}()
+	return f
+}
+
+func (f *feedListener) Accept() (net.Conn, error) {
+	conn, ok := <-f.conns
+	if !ok {
+		return nil, errors.New("listener closed")
+	}
+	return conn, nil
+}
+
+// pipeClient starts a client reading from one end of an in memory pipe and returns the other end for the test to play the feed.
+func pipeClient() (*IQC, net.Conn) {
+	c := newTestClient()
+	client, server := net.Pipe()
+	c.Conn = client
+	c.startReader(c.read)
+	return c, server
+}
+
+func TestSnapshot(t *testing.T) {
+	c, server := pipeClient()
+	defer c.Conn.Close()
+	var mu sync.Mutex
+	var cmds []string
+	go func() {
+		r := bufio.NewReader(server)
+		server.Write([]byte("S,CURRENT UPDATE FIELDNAMES,Symbol,Most Recent Trade\r\n"))
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			cmds = append(cmds, strings.TrimRight(line, "\r\n"))
+			mu.Unlock()
+			switch line {
+			case "wAAPL\r\n":
+				// Another symbol's summary must not answer the snapshot.
+				server.Write([]byte("P,MSFT,52.10\r\nP,AAPL,95.02\r\n"))
+			case "wMSFT\r\n":
+				server.Write([]byte("P,MSFT,52.11\r\n"))
+			case "wZZZZ\r\n":
+				server.Write([]byte("n,ZZZZ\r\n"))
+			}
+		}
+	}()
+	c.ConfirmTimeout = 100 * time.Millisecond
+
+	s, err := c.Snapshot("AAPL")
+	if err != nil || s.Symbol != "AAPL" || s.MostRecentTrade != 95.02 || s.Kind != KindSummary {
+		t.Fatalf("unexpected snapshot %+v, %v", s, err)
+	}
+	// The MSFT summary wasn't for a snapshot so it is delivered, the AAPL one isn't.
+	if u := <-c.Updates; u.Symbol != "MSFT" || len(c.Updates) != 0 {
+		t.Errorf("unexpected update %+v", u)
+	}
+
+	// A watched symbol stays watched and its summary still reaches Updates.
+	c.WatchSymbol("MSFT")
+	if s, err := c.Snapshot("MSFT"); err != nil || s.MostRecentTrade != 52.11 {
+		t.Errorf("unexpected snapshot %+v, %v", s, err)
+	}
+	if u := <-c.Updates; u.MostRecentTrade != 52.11 {
+		t.Errorf("unexpected update %+v", u)
+	}
+
+	if _, err := c.Snapshot("ZZZZ"); !errors.Is(err, ErrSymbolNotFound) {
+		t.Errorf("expected ErrSymbolNotFound, got %v", err)
+	}
+	<-c.Errors
+	if _, err := c.Snapshot("SLOW"); err != ErrTimeout {
+		t.Errorf("expected ErrTimeout, got %v", err)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	want := "wAAPL rAAPL wMSFT wMSFT wZZZZ wSLOW rSLOW"
+	if got := strings.Join(cmds, " "); got != want {
+		t.Errorf("commands = %q, want %q", got, want)
+	}
+	if w := c.WatchedSymbols(); len(w) != 1 || w[0] != "MSFT" {
+		t.Errorf("snapshots must not change the watched set, got %v", w)
+	}
+}
+
+func TestSnapshotUppercase(t *testing.T) {
+	c, s := fakeClient(t, &IQC{UppercaseSymbols: true})
+	s.Watch("AAPL", "P,AAPL,95.02")
+	u, err := c.Snapshot(" aapl")
+	if err != nil || u.Symbol != "AAPL" || u.MostRecentTrade != 95.02 {
+		t.Fatalf("unexpected snapshot %+v, %v", u, err)
+	}
+	waitCommands(t, s, "rAAPL", 1)
+	if cmds := s.Commands(); cmds[len(cmds)-2] != "wAAPL" {
+		t.Errorf("commands = %q", cmds)
+	}
+	if _, err := c.Snapshot("A,B"); !errors.Is(err, ErrInvalidSymbol) {
+		t.Errorf("expected ErrInvalidSymbol, got %v", err)
+	}
+}
+
+func TestOptionSymbols(t *testing.T) {
+	c := newTestClient()
+	expiry := time.Date(2012, 10, 20, 0, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		strike float64
+		call   bool
+		want   string
+	}{
+		{30.5, true, "MSFT1220J30.5"},
+		{30, false, "MSFT1220V30"},
+		{97.25, true, "MSFT1220J97.25"},
+	} {
+		sym := c.BuildOptionSymbol("MSFT", expiry, tc.strike, tc.call)
+		if sym != tc.want {
+			t.Errorf("BuildOptionSymbol = %q, want %q", sym, tc.want)
+		}
+		o, err := ParseOptionSymbol(sym)
+		if err != nil || o.Underlying != "MSFT" || !o.Expiry.Equal(expiry) || o.Strike != tc.strike || o.Call != tc.call {
+			t.Errorf("ParseOptionSymbol(%q) = %+v, %v", sym, o, err)
+		}
+	}
+	if sym, err := c.WatchOptionSymbol("AAPL", 95, time.Date(2016, 3, 18, 0, 0, 0, 0, time.UTC), false); err != nil || sym != "AAPL1618O95" || c.Conn.(*recordConn).String() != "wAAPL1618O95\r\n" {
+		t.Errorf("unexpected watched option %q, %v", sym, err)
+	}
+	c.MaxSymbols = 1
+	if sym, err := c.WatchOptionSymbol("AAPL", 100, time.Date(2016, 3, 18, 0, 0, 0, 0, time.UTC), true); sym != "AAPL1618C100" || !errors.Is(err, ErrSymbolLimit) {
+		t.Errorf("expected the watch error for %q, got %v", sym, err)
+	}
+	for _, bad := range []string{"", "AAPL", "1618C95", "AAPL1618Z95", "AAPL1618C", "AAPL16X8C95", "AAPL1600C95"} {
+		if _, err := ParseOptionSymbol(bad); err == nil {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestOptionMonthCodes(t *testing.T) {
+	for _, tc := range []struct {
+		month     time.Month
+		call, put byte
+	}{
+		{time.January, 'A', 'M'},
+		{time.February, 'B', 'N'},
+		{time.March, 'C', 'O'},
+		{time.April, 'D', 'P'},
+		{time.May, 'E', 'Q'},
+		{time.June, 'F', 'R'},
+		{time.July, 'G', 'S'},
+		{time.August, 'H', 'T'},
+		{time.September, 'I', 'U'},
+		{time.October, 'J', 'V'},
+		{time.November, 'K', 'W'},
+		{time.December, 'L', 'X'},
+	} {
+		for _, side := range []struct {
+			call bool
+			code byte
+		}{{true, tc.call}, {false, tc.put}} {
+			code, err := OptionMonthCode(tc.month, side.call)
+			if err != nil || code != side.code {
+				t.Errorf("OptionMonthCode(%s, %t) = %q, %v, want %q", tc.month, side.call, code, err, side.code)
+			}
+			month, call, err := MonthFromOptionCode(side.code)
+			if err != nil || month != tc.month || call != side.call {
+				t.Errorf("MonthFromOptionCode(%q) = %s, %t, %v", side.code, month, call, err)
+			}
+		}
+	}
+	if _, err := OptionMonthCode(13, true); err == nil {
+		t.Error("expected month 13 to be rejected")
+	}
+	for _, bad := range []byte{'Y', 'a', '0', 0} {
+		if _, _, err := MonthFromOptionCode(bad); err == nil {
+			t.Errorf("expected code %q to be rejected", bad)
+		}
+	}
+}
+
+func TestSelectUpdateFieldsWaitsForLayout(t *testing.T) {
+	c, server := pipeClient()
+	defer c.Conn.Close()
+	go func() {
+		r := bufio.NewReader(server)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch line {
+			case "S,SELECT UPDATE FIELDS,Last,Bid\r\n":
+				// A stale layout from an earlier request must not be taken as the confirmation.
+				server.Write([]byte("S,CURRENT UPDATE FIELDNAMES,Symbol,Bid,Last\r\n"))
+				server.Write([]byte("S,CURRENT UPDATE FIELDNAMES,Symbol,Last,Bid\r\n"))
+			case "wAAPL\r\n":
+				server.Write([]byte("P,AAPL,95.02,95.01\r\n"))
+			}
+		}
+	}()
+
+	if err := c.SelectUpdateFields("Last", "Bid"); err != nil {
+		t.Fatal(err)
+	}
+	c.WatchSymbol("AAPL")
+	select {
+	case u := <-c.Updates:
+		if u.Last != 95.02 || u.Bid != 95.01 {
+			t.Errorf("summary parsed against the wrong layout: last %v bid %v", u.Last, u.Bid)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no summary received")
+	}
+}
+
+func TestSelectUpdateFieldsTimeout(t *testing.T) {
+	c := newTestClient()
+	c.ConfirmTimeout = 10 * time.Millisecond
+	if err := c.SelectUpdateFields("Last"); err != ErrTimeout {
+		t.Errorf("expected ErrTimeout, got %v", err)
+	}
+	if c.selectedFields != nil {
+		t.Errorf("an unconfirmed selection must not be replayed on reconnect, got %v", c.selectedFields)
+	}
+
+	// A write error is returned straight away instead of waiting for the confirmation.
+	client, server := net.Pipe()
+	client.Close()
+	server.Close()
+	c.Conn = client
+	c.ConfirmTimeout = time.Minute
+	if err := c.SelectUpdateFields("Last"); err == nil || err == ErrTimeout {
+		t.Errorf("expected the write error, got %v", err)
+	}
+}
+
+func TestSelectUpdateFieldsUnknown(t *testing.T) {
+	// Fields added to the feed after the package are selected and read from Extra.
+	log := &recordLogger{}
+	c, s := fakeClient(t, &IQC{Logger: log})
+	if err := c.SelectUpdateFields("Last", "Some New Field"); err != nil {
+		t.Fatal(err)
+	}
+	s.Watch("AAPL", "P,AAPL,95.02,xyz")
+	c.WatchSymbol("AAPL")
+	select {
+	case u := <-c.Updates:
+		if u.Last != 95.02 || u.Extra["Some New Field"] != "xyz" {
+			t.Errorf("last %v extra %q", u.Last, u.Extra)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no summary for the new field layout")
+	}
+	log.mu.Lock()
+	if len(log.msgs) == 0 || !strings.Contains(strings.Join(log.msgs, "\n"), `warn: Update field "Some New Field" is not known`) {
+		t.Errorf("expected a warning about the unknown field, logged %q", log.msgs)
+	}
+	log.mu.Unlock()
+
+	strict := newTestClient()
+	strict.StrictFields = true
+	err := strict.SelectUpdateFields("Last", "Bogus Field")
+	if !errors.Is(err, ErrUnknownField) || !strings.Contains(err.Error(), "Bogus Field") {
+		t.Errorf("expected ErrUnknownField naming the field, got %v", err)
+	}
+	if w := strict.Conn.(*recordConn).String(); w != "" {
+		t.Errorf("nothing should be sent for unknown fields, wrote %q", w)
+	}
+}
+
+func TestMergedQuotes(t *testing.T) {
+	c := newTestClient()
+	c.EmitQuotes = true
+	c.processReceiver([]byte("S,CURRENT UPDATE FIELDNAMES,Symbol,Most Recent Trade,Most Recent Trade Size,Total Volume,Bid,Bid Size,Ask,Ask Size"))
+	c.processReceiver([]byte("P,AAPL,95.02,100,1325032,95.01,300,95.04,400"))
+	c.processReceiver([]byte("Q,AAPL,,,,95.02,200,,"))
+	c.processReceiver([]byte("Q,AAPL,95.03,50,1325082,,,,"))
+
+	want := []Quote{
+		{Symbol: "AAPL", Last: 95.02, LastSize: 100, Volume: 1325032, Bid: 95.01, BidSize: 300, Ask: 95.04, AskSize: 400},
+		{Symbol: "AAPL", Last: 95.02, LastSize: 100, Volume: 1325032, Bid: 95.02, BidSize: 200, Ask: 95.04, AskSize: 400},
+		{Symbol: "AAPL", Last: 95.03, LastSize: 50, Volume: 1325082, Bid: 95.02, BidSize: 200, Ask: 95.04, AskSize: 400},
+	}
+	for i, w := range want {
+		q := <-c.Quotes
+		if q.ReceivedAt.IsZero() {
+			t.Errorf("quote %d: ReceivedAt not set", i)
+		}
+		q.Received = Received{}
+		if *q != w {
+			t.Errorf("quote %d: got %+v, want %+v", i, *q, w)
+		}
+	}
+}
+
+func TestMergedQuoteTime(t *testing.T) {
+	c := newTestClient()
+	c.EmitQuotes = true
+	c.processReceiver([]byte("S,CURRENT UPDATE FIELDNAMES,Symbol,Most Recent Trade,Most Recent Trade Size,Most Recent Trade Time,Bid,Bid Time,Ask,Ask Time"))
+	c.processReceiver([]byte("P,AAPL,95.02,100,09:30:00,95.01,09:30:01,95.04,09:29:00"))
+	// The trade is the latest of the three even though the ask time comes last in the layout.
+	if q := <-c.Quotes; q.Time.Format("15:04:05") != "09:30:01" {
+		t.Errorf("summary quote time = %s", q.Time)
+	}
+	// A trade only delta moves the time on its own.
+	c.processReceiver([]byte("Q,AAPL,95.03,50,09:31:00,,,,"))
+	if q := <-c.Quotes; q.Time.Format("15:04:05") != "09:31:00" || q.Last != 95.03 {
+		t.Errorf("trade quote time = %s", q.Time)
+	}
+}
+
+func TestVerifyBackup(t *testing.T) {
+	dir := t.TempDir()
+	backup, golden := dir+"/feed.txt", dir+"/feed.golden"
+	feed := "S,CURRENT UPDATE FIELDNAMES,Symbol,Last,Bid Size\r\nP,AAPL,95.02,100\r\nT,20160314 09:30:00\r\n"
+	if err := os.WriteFile(backup, []byte(feed), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c := newTestClient()
+	if err := c.WriteGolden(backup, golden); err != nil {
+		t.Fatal(err)
+	}
+	if diffs, err := c.Verify(backup, golden); err != nil || len(diffs) != 0 {
+		t.Fatalf("expected no diffs against fresh golden output, got %v %v", diffs, err)
+	}
+
+	// Parsing the same recording with different settings must be reported against the offending line.
+	c.NormalizeToUTC = true
+	c.TimeLoc = time.FixedZone("EST", -5*60*60)
+	diffs, err := c.Verify(backup, golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diffs) != 1 || diffs[0].Line != 3 || diffs[0].Raw != "T,20160314 09:30:00" {
+		t.Errorf("unexpected diffs %+v", diffs)
+	}
+}
+
+func TestReplayFile(t *testing.T) {
+	backup := t.TempDir() + "/feed.txt"
+	feed := "S,CURRENT UPDATE FIELDNAMES,Symbol,Last,Bid Size\r\nT,20160314 09:30:00\r\nP,AAPL,95.02,100\r\nT,20160314 09:30:01\r\nQ,AAPL,95.03,200\r\n"
+	if err := os.WriteFile(backup, []byte(feed), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c := &IQC{TimeZone: "UTC", RealTimeReplay: true, Logger: NopLogger{}}
+	start := time.Now()
+	if _, err := c.ReplayFile(backup, 16); err != nil {
+		t.Fatal(err)
+	}
+	c.WatchSymbol("MSFT") // Discarded, must not fail during a replay.
+
+	var got []string
+	for u := range c.Updates {
+		got = append(got, u.Symbol+" "+strconv.FormatFloat(u.Last, 'f', 2, 64))
+	}
+	if strings.Join(got, ",") != "AAPL 95.02,AAPL 95.03" {
+		t.Errorf("unexpected updates %v", got)
+	}
+	if n := len(c.Time); n != 2 {
+		t.Errorf("expected 2 time messages, got %d", n)
+	}
+	// The two timestamps are a second apart in the file.
+	if elapsed := time.Since(start); elapsed < 900*time.Millisecond {
+		t.Errorf("real time replay finished after %s", elapsed)
+	}
+
+	if _, err := (&IQC{TimeZone: "UTC"}).ReplayFile(backup+".missing", 16); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+}
+
+func TestReplaySpeedAndStartTime(t *testing.T) {
+	backup := t.TempDir() + "/feed.txt"
+	feed := "S,CURRENT UPDATE FIELDNAMES,Symbol,Last,Bid Size\r\nT,20160314 09:30:00\r\nP,AAPL,95.02,100\r\nT,20160314 09:30:01\r\nQ,AAPL,95.03,200\r\nT,20160314 09:30:03\r\nQ,AAPL,95.04,300\r\n"
+	if err := os.WriteFile(backup, []byte(feed), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c := &IQC{TimeZone: "UTC", RealTimeReplay: true, ReplaySpeed: 10, Logger: NopLogger{}}
+	c.ReplayStartTime = time.Date(2016, 3, 14, 9, 30, 1, 0, time.UTC)
+	start := time.Now()
+	if _, err := c.ReplayFile(backup, 16); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for u := range c.Updates {
+		got = append(got, u.Symbol+" "+strconv.FormatFloat(u.Last, 'f', 2, 64))
+	}
+	// The summary before the start time is skipped, the layout sent before it is still used.
+	if strings.Join(got, ",") != "AAPL 95.03,AAPL 95.04" {
+		t.Errorf("unexpected updates %v", got)
+	}
+	if n := len(c.Time); n != 2 {
+		t.Errorf("expected 2 time messages, got %d", n)
+	}
+	// Two seconds of the feed at ten times real time.
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond || elapsed > 1500*time.Millisecond {
+		t.Errorf("replay at 10x finished after %s", elapsed)
+	}
+}
+
+func TestBackupRotation(t *testing.T) {
+	dir := t.TempDir()
+	c := newTestClient()
+	c.Logger = NopLogger{}
+	c.CreateBackup = true
+	c.BackupFile = dir + "/feed.txt"
+	c.MaxBackupBytes = 40
+	c.CompressBackups = true
+
+	lines := []string{"S,CURRENT UPDATE FIELDNAMES,Symbol,Last\r\n", "P,AAPL,95.02\r\n", "Q,AAPL,95.03\r\n", "Q,AAPL,95.04\r\n"}
+	for _, l := range lines {
+		c.writeBackup([]byte(l))
+	}
+	c.closeBackup()
+	c.workers.Wait()
+
+	segments, _ := filepath.Glob(dir + "/feed-*.txt.gz")
+	if len(segments) != 2 {
+		t.Fatalf("expected 2 compressed segments, got %v", segments)
+	}
+	// Both segments were started in the same second so their order isn't given by the name, every line must be kept unchanged though.
+	var all, want []string
+	for _, p := range append(segments, c.BackupFile) {
+		l, err := readLines(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		all = append(all, l...)
+	}
+	for _, l := range lines {
+		want = append(want, strings.TrimSuffix(l, "\r\n"))
+	}
+	sort.Strings(all)
+	sort.Strings(want)
+	if !reflect.DeepEqual(all, want) {
+		t.Errorf("rotated segments hold %q, want %q", all, want)
+	}
+	first := segments[0]
+	for _, p := range segments {
+		if l, _ := readLines(p); l[0] == want[len(want)-1] {
+			first = p
+		}
+	}
+
+	r := &IQC{TimeZone: "UTC", Logger: NopLogger{}}
+	if _, err := r.ReplayFile(first, 16); err != nil {
+		t.Fatal(err)
+	}
+	for range r.System {
+	}
+	if r.UpdateFieldNames()[1] != "Last" {
+		t.Errorf("replaying a compressed segment gave fields %v", r.UpdateFieldNames())
+	}
+}
+
+func TestBackupRotateDaily(t *testing.T) {
+	dir := t.TempDir()
+	c := newTestClient()
+	c.CreateBackup = true
+	c.BackupFile = dir + "/feed.txt"
+	c.RotateDaily = true
+	c.writeBackup([]byte("T,20160314 09:30:00\r\n"))
+	c.backup.opened = c.backup.opened.AddDate(0, 0, -1)
+	c.writeBackup([]byte("T,20160315 09:30:00\r\n"))
+	c.closeBackup()
+
+	segments, _ := filepath.Glob(dir + "/feed-*.txt")
+	if len(segments) != 1 {
+		t.Fatalf("expected the previous day to be rotated, got %v", segments)
+	}
+	if l, _ := readLines(c.BackupFile); len(l) != 1 || l[0] != "T,20160315 09:30:00" {
+		t.Errorf("unexpected active file %v", l)
+	}
+}
+
+func TestBackupFailuresDisableBackups(t *testing.T) {
+	c := newTestClient()
+	log := &recordLogger{}
+	c.Logger = log
+	c.CreateBackup = true
+	c.BackupFile = t.TempDir() + "/missing/feed.txt"
+	var errs []error
+	c.OnBackupError = func(err error) { errs = append(errs, err) }
+	for i := 0; i < 10; i++ {
+		c.backupLine([]byte("T,20160314 09:30:00\r\n"))
+	}
+	if len(errs) != maxBackupFailures || !errors.Is(errs[0], os.ErrNotExist) {
+		t.Errorf("expected %d errors reported, got %v", maxBackupFailures, errs)
+	}
+	if c.CreateBackup {
+		t.Error("expected backups to be disabled")
+	}
+	if len(log.msgs) != 1 || !strings.HasPrefix(log.msgs[0], "warn: Disabling backups") {
+		t.Errorf("expected a single warning, got %q", log.msgs)
+	}
+}
+
+func TestWatchWithModes(t *testing.T) {
+	fundamental := "F,%s" + strings.Repeat(",", 54)
+	for _, tc := range []struct {
+		mode WatchMode
+		want string
+		left bool
+	}{
+		{WatchModeFull, "wAAPL\r\n", true},
+		{WatchModeTrades, "tAAPL\r\n", true},
+		{WatchModeSnapshotThenTrades, "wAAPL\r\ntAAPL\r\n", true},
+		{WatchModeSnapshot, "wAAPL\r\nrAAPL\r\n", false},
+	} {
+		c := newTestClient()
+		c.processReceiver([]byte("S,CURRENT UPDATE FIELDNAMES,Symbol,Last,Bid Size"))
+		c.WatchWith("AAPL", tc.mode)
+		c.processReceiver([]byte(fmt.Sprintf(fundamental, "AAPL")))
+		c.processReceiver([]byte("P,AAPL,95.02,100"))
+		c.processReceiver([]byte("Q,AAPL,95.03,100"))
+		if got := c.Conn.(*recordConn).String(); got != tc.want {
+			t.Errorf("mode %d: sent %q, want %q", tc.mode, got, tc.want)
+		}
+		if _, ok := c.WatchModeOf("AAPL"); ok != tc.left {
+			t.Errorf("mode %d: expected watched to be %v", tc.mode, tc.left)
+		}
+	}
+}
+
+// answerConn records writes like recordConn and calls answer with every command written, before the write returns.
+type answerConn struct {
+	recordConn
+	answer func(cmd string)
+}
+
+func (a *answerConn) Write(b []byte) (int, error) {
+	n, err := a.recordConn.Write(b)
+	a.answer(string(b))
+	return n, err
+}
+
+func TestSnapshotBeforeWatched(t *testing.T) {
+	for _, tc := range []struct {
+		mode    WatchMode
+		want    string
+		watched bool
+	}{
+		{WatchModeSnapshotThenTrades, "wAAPL\r\ntAAPL\r\n", true},
+		{WatchModeSnapshot, "wAAPL\r\nrAAPL\r\n", false},
+	} {
+		c := newTestClient()
+		c.processReceiver([]byte("S,CURRENT UPDATE FIELDNAMES,Symbol,Last,Bid Size"))
+		var wg sync.WaitGroup
+		conn := &answerConn{}
+		conn.answer = func(cmd string) {
+			if cmd != "wAAPL\r\n" {
+				return
+			}
+			// The initial messages are both processed before the watch returns, the downgrade then waits for this write to finish.
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				c.processReceiver([]byte("F,AAPL" + strings.Repeat(",", 54)))
+				c.processReceiver([]byte("P,AAPL,95.02,100"))
+			}()
+			for {
+				c.watchMu.Lock()
+				_, waiting := c.snapshots["AAPL"]
+				c.watchMu.Unlock()
+				if !waiting {
+					return
+				}
+				time.Sleep(time.Millisecond)
+			}
+		}
+		c.Conn = conn
+		if err := c.WatchWith("AAPL", tc.mode); err != nil {
+			t.Fatal(err)
+		}
+		wg.Wait()
+		if got := conn.String(); got != tc.want {
+			t.Errorf("mode %d: sent %q, want %q", tc.mode, got, tc.want)
+		}
+		mode, watched := c.WatchModeOf("AAPL")
+		if watched != tc.watched || (watched && mode != WatchModeTrades) {
+			t.Errorf("mode %d: left watched %v in mode %d", tc.mode, watched, mode)
+		}
+	}
+}
+
+func TestPauseResume(t *testing.T) {
+	c := newTestClient()
+	conn := c.Conn.(*recordConn)
+	c.WatchSymbol("AAPL")
+	c.TradeOnlyWatch("MSFT")
+	if err := c.Pause(); err != nil {
+		t.Fatal(err)
+	}
+	if !c.Paused() {
+		t.Fatal("expected client to report paused symbols")
+	}
+	if _, ok := c.WatchModeOf("AAPL"); ok {
+		t.Error("paused symbols should not be in the watched set")
+	}
+	conn.buf.Reset()
+	if err := c.Resume(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := conn.String(), "wAAPL\r\ntMSFT\r\n"; got != want {
+		t.Errorf("resume sent %q, want %q", got, want)
+	}
+	if m, ok := c.WatchModeOf("MSFT"); !ok || m != WatchModeTrades || c.Paused() {
+		t.Errorf("expected MSFT to be watched trades only again, got %v %v", m, ok)
+	}
+}
+
+func TestNotFoundCache(t *testing.T) {
+	c := newTestClient()
+	conn := c.Conn.(*recordConn)
+	c.NotFoundTTL = time.Minute
+	c.WatchSymbol("BADSYM")
+	c.processReceiver([]byte("n,BADSYM"))
+	<-c.Errors
+
+	conn.buf.Reset()
+	if err := c.WatchSymbol("BADSYM"); !errors.Is(err, ErrSymbolNotFound) {
+		t.Fatalf("expected ErrSymbolNotFound, got %v", err)
+	}
+	if conn.String() != "" {
+		t.Errorf("cached not found symbol was sent to the feed: %q", conn.String())
+	}
+
+	c.ClearNotFound()
+	if err := c.WatchSymbol("BADSYM"); err != nil || conn.String() != "wBADSYM\r\n" {
+		t.Errorf("expected watch to be sent after clearing the cache, got %v %q", err, conn.String())
+	}
+}
+
+func TestStartErrors(t *testing.T) {
+	if _, err := (&IQC{TimeZone: "Not/AZone"}).Start("127.0.0.1:0", 1); err == nil {
+		t.Error("expected an error for an unknown timezone")
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("cannot listen: %s", err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	c := &IQC{TimeZone: "UTC"}
+	if _, err := c.Start(addr, 1); !errors.Is(err, ErrIQConnectNotRunning) {
+		t.Errorf("expected ErrIQConnectNotRunning when nothing is listening, got %v", err)
+	}
+	// Stopping a client that never started must not panic.
+	c.Stop()
+	c.Stop()
+	(&IQC{}).Stop()
+}
+
+func TestWaitForPort(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("cannot listen: %s", err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	c := &IQC{}
+	if err := c.waitForPort(addr, 100*time.Millisecond); !errors.Is(err, ErrTimeout) {
+		t.Errorf("expected ErrTimeout while nothing listens, got %v", err)
+	}
+	// Start listening while waiting, as IQConnect does once it has launched.
+	go func() {
+		time.Sleep(300 * time.Millisecond)
+		if l, err := net.Listen("tcp", addr); err == nil {
+			defer l.Close()
+			if conn, err := l.Accept(); err == nil {
+				conn.Close()
+			}
+		}
+	}()
+	if err := c.waitForPort(addr, 5*time.Second); err != nil {
+		t.Errorf("expected the port to answer, got %v", err)
+	}
+	if args := (LaunchOptions{ProductID: "P", Login: "user", Password: "pw"}).args(); strings.Join(args, " ") != "-product P -login user -password pw -autoconnect" {
+		t.Errorf("unexpected arguments %q", args)
+	}
+}
+
+func TestStartTLS(t *testing.T) {
+	// httptest provides a certificate valid for 127.0.0.1.
+	srv := httptest.NewTLSServer(http.NotFoundHandler())
+	defer srv.Close()
+	l, err := tls.Listen("tcp", "127.0.0.1:0", srv.TLS)
+	if err != nil {
+		t.Skipf("cannot listen: %s", err)
+	}
+	defer l.Close()
+	cmds := make(chan string, 1)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				// Fails for the attempt that rejects the certificate.
+				if err := conn.(*tls.Conn).Handshake(); err != nil {
+					return
+				}
+				conn.Write([]byte(fieldsLine + "T,20160314 09:30:00\r\n"))
+				line, _ := bufio.NewReader(conn).ReadString('\n')
+				cmds <- line
+				io.Copy(ioutil.Discard, conn)
+			}()
+		}
+	}()
+
+	if _, err := (&IQC{TimeZone: "UTC", Logger: NopLogger{}, TLSConfig: &tls.Config{}}).Start(l.Addr().String(), 1); err == nil {
+		t.Error("expected an untrusted certificate to be rejected")
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(srv.Certificate())
+	c := &IQC{TimeZone: "UTC", Logger: NopLogger{}, TLSConfig: &tls.Config{RootCAs: roots}}
+	if _, err := c.Start(l.Addr().String(), 1); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	if _, ok := c.Conn.(*tls.Conn); !ok {
+		t.Errorf("expected a TLS connection, got %T", c.Conn)
+	}
+	if tm := <-c.Time; tm.TimeStamp.Hour() != 9 {
+		t.Errorf("unexpected time %v", tm.TimeStamp)
+	}
+	if cmd := <-cmds; cmd != "S,REQUEST CURRENT UPDATE FIELDNAMES\r\n" {
+		t.Errorf("unexpected first command %q", cmd)
+	}
+}
+
+func TestTLSHandshakeTimeout(t *testing.T) {
+	// A peer that accepts but never answers the handshake.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("cannot listen: %s", err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	c := &IQC{TimeZone: "UTC", Logger: NopLogger{}, TLSConfig: &tls.Config{}, DialTimeout: 100 * time.Millisecond}
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.Start(l.Addr().String(), 1)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("expected the handshake to fail")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Start hung on a silent peer")
+	}
+}
+
+func TestStartWaitsForFieldNames(t *testing.T) {
+	// A feed that never sends its field names.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("cannot listen: %s", err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	c := &IQC{TimeZone: "UTC", Logger: NopLogger{}, ConfirmTimeout: 100 * time.Millisecond}
+	if _, err := c.Start(l.Addr().String(), 1); !errors.Is(err, ErrTimeout) {
+		t.Errorf("expected ErrTimeout, got %v", err)
+	}
+
+	// Once running the layout can be requested again.
+	c, server := pipeClient()
+	defer server.Close()
+	go func() {
+		line, _ := bufio.NewReader(server).ReadString('\n')
+		if line == "S,REQUEST CURRENT UPDATE FIELDNAMES\r\n" {
+			server.Write([]byte("S,CURRENT UPDATE FIELDNAMES,Symbol,Bid\r\n"))
+		}
+	}()
+	if err := c.ReqCurrentUpdateFNames(); err != nil {
+		t.Fatal(err)
+	}
+	if f := c.UpdateFieldNames(); len(f) != 2 || f[1] != "Bid" {
+		t.Errorf("field names = %q", f)
+	}
+}
+
+func TestReadTimeout(t *testing.T) {
+	// A peer that accepts and then goes silent.
+	l := listenFeed(t)
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	c := &IQC{TimeZone: "UTC", Logger: NopLogger{}, ReadTimeout: 100 * time.Millisecond, KeepAlive: time.Second}
+	if _, err := c.Start(l.Addr().String(), 1); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	select {
+	case <-c.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the read loop to give up on a silent connection")
+	}
+}
+
+func TestStopTerminatesReader(t *testing.T) {
+	c, server := pipeClient()
+	defer server.Close()
+	go server.Write([]byte("T,20160314 09:30:00\r\n"))
+	<-c.Time
+
+	stopped := make(chan struct{})
+	go func() {
+		c.Stop()
+		c.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Stop did not return")
+	}
+	// Output channels are closed so range loops over them end.
+	for range c.Updates {
+	}
+	if _, ok := <-c.Time; ok {
+		t.Error("expected Time channel to be closed")
+	}
+}
+
+func TestQuitStopsReader(t *testing.T) {
+	c, server := pipeClient()
+	defer server.Close()
+	c.Quit <- true
+	select {
+	case <-c.done:
+	case <-time.After(time.Second):
+		t.Fatal("read goroutine did not exit on Quit")
+	}
+}
+
+func TestRegionalQuotes(t *testing.T) {
+	c := newTestClient()
+	conn := c.Conn.(*recordConn)
+	c.WatchRegional("AAPL")
+	c.UnwatchRegional("AAPL")
+	if got := conn.String(); got != "S,REGON,AAPL\r\nS,REGOFF,AAPL\r\n" {
+		t.Errorf("unexpected commands %q", got)
+	}
+
+	c.processReceiver([]byte("R,AAPL,,95.01,300,09:30:00.123456,95.04,400,09:30:01,14,4,5"))
+	r := <-c.Regional
+	if r.RegBidTime.Nanosecond() != 123456000 || r.RegAskTime.Second() != 1 || r.RegBidTime.Location() != time.UTC {
+		t.Errorf("unexpected regional times %v %v", r.RegBidTime, r.RegAskTime)
+	}
+	if r.RegBid != 95.01 || r.RegBidSize != 300 || r.RegAsk != 95.04 || r.RegAskSize != 400 || r.DecPrecision != 4 || r.MarketCenter != 5 {
+		t.Errorf("unexpected regional %+v", r)
+	}
+	// A truncated message must not panic.
+	c.processReceiver([]byte("R,AAPL,,95.01"))
+	if r := <-c.Regional; r.Symbol != "AAPL" || r.RegBid != 95.01 {
+		t.Errorf("unexpected short regional %+v", r)
+	}
+}
+
+func TestReconnectReplaysWatches(t *testing.T) {
+	l := listenFeed(t)
+	defer l.Close()
+	c := &IQC{TimeZone: "UTC", ReconnectEnabled: true, InitialBackoff: 10 * time.Millisecond}
+	if c.RemoteAddr() != "" || c.ConnectString() != "" {
+		t.Errorf("expected no address before Start, got %q and %q", c.RemoteAddr(), c.ConnectString())
+	}
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	if _, err := c.Start(":"+port, 16); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	if c.ConnectString() != "localhost:"+port || c.RemoteAddr() != l.Addr().String() {
+		t.Errorf("connected to %q at %q", c.ConnectString(), c.RemoteAddr())
+	}
+
+	first, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.WatchSymbol("AAPL"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.TradeOnlyWatch("MSFT"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.WatchRegional("IBM"); err != nil {
+		t.Fatal(err)
+	}
+	first.Close()
+
+	second, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	second.SetReadDeadline(time.Now().Add(time.Second))
+	r := bufio.NewReader(second)
+	var got []string
+	for len(got) < 4 {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading replayed commands: %s (got %q)", err, got)
+		}
+		got = append(got, strings.TrimRight(line, "\r\n"))
+	}
+	if got[0] != "S,REQUEST CURRENT UPDATE FIELDNAMES" {
+		t.Errorf("expected the field request to be replayed first, got %q", got[0])
+	}
+	watches := got[1:3]
+	sort.Strings(watches)
+	if !reflect.DeepEqual(watches, []string{"tMSFT", "wAAPL"}) {
+		t.Errorf("replayed watches = %q", watches)
+	}
+	if got[3] != "S,REGON,IBM" {
+		t.Errorf("expected the regional watch to be replayed last, got %q", got[3])
+	}
+
+	var states []ConnectionState
+	for len(states) < 3 {
+		select {
+		case e := <-c.Connection:
+			states = append(states, e.State)
+		case <-time.After(time.Second):
+			t.Fatalf("missing connection events, got %v", states)
+		}
+	}
+	if !reflect.DeepEqual(states, []ConnectionState{StateDisconnected, StateReconnecting, StateReconnected}) {
+		t.Errorf("connection events = %v", states)
+	}
+
+	if c.RemoteAddr() != l.Addr().String() {
+		t.Errorf("reconnected to %q", c.RemoteAddr())
+	}
+
+	// Streaming resumes on the new connection.
+	second.Write([]byte("T,20160314 09:30:00\r\n"))
+	select {
+	case <-c.Time:
+	case <-time.After(time.Second):
+		t.Fatal("no message received after reconnecting")
+	}
+}
+
+func TestReconnectGivesUp(t *testing.T) {
+	l := listenFeed(t)
+	c := &IQC{TimeZone: "UTC", ReconnectEnabled: true, MaxReconnectAttempts: 2, InitialBackoff: time.Millisecond}
+	if _, err := c.Start(l.Addr().String(), 16); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	first, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+	first.Close()
+
+	select {
+	case <-c.done:
+	case <-time.After(time.Second):
+		t.Fatal("read goroutine did not give up")
+	}
+	var last *ConnectionEvent
+	for len(c.Connection) > 0 {
+		last = <-c.Connection
+	}
+	if last == nil || last.State != StateReconnectFailed || last.Attempt != 2 || last.Err == nil {
+		t.Errorf("expected a failed reconnect after 2 attempts, got %+v", last)
+	}
+}
+
+func TestWatchCommands(t *testing.T) {
+	c := newTestClient()
+	conn := c.Conn.(*recordConn)
+	var wg sync.WaitGroup
+	for _, s := range []string{"AAPL", "MSFT", "IBM"} {
+		wg.Add(1)
+		go func(s string) {
+			defer wg.Done()
+			c.WatchSymbol(s)
+		}(s)
+	}
+	wg.Wait()
+	c.WatchTradesOnly("GOOG")
+	c.UnwatchSymbol("IBM")
+
+	lines := strings.Split(strings.TrimSuffix(conn.String(), "\r\n"), "\r\n")
+	sort.Strings(lines[:3])
+	want := []string{"wAAPL", "wIBM", "wMSFT", "tGOOG", "rIBM"}
+	if !reflect.DeepEqual(lines, want) {
+		t.Errorf("commands = %q, want %q", lines, want)
+	}
+	if m, ok := c.WatchModeOf("GOOG"); !ok || m != WatchModeTrades {
+		t.Errorf("GOOG watch mode = %v, %v", m, ok)
+	}
+	if _, ok := c.WatchModeOf("IBM"); ok {
+		t.Error("IBM still watched after UnwatchSymbol")
+	}
+	if got := c.WatchedSymbols(); !reflect.DeepEqual(got, []string{"AAPL", "GOOG", "MSFT"}) {
+		t.Errorf("WatchedSymbols = %v", got)
+	}
+}
+
+func TestWatchSymbolsBatch(t *testing.T) {
+	c := newTestClient()
+	conn := c.Conn.(*recordConn)
+	c.WatchSymbol("AAPL")
+	var added []string
+	c.OnWatchChange = func(a, r []string) { added = append(added, a...) }
+	if err := c.WatchSymbols([]string{"AAPL", "MSFT", "MSFT", "IBM"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := conn.String(); got != "wAAPL\r\nwMSFT\r\nwIBM\r\n" {
+		t.Errorf("unexpected commands %q", got)
+	}
+	if !reflect.DeepEqual(added, []string{"MSFT", "IBM"}) {
+		t.Errorf("unexpected added symbols %v", added)
+	}
+
+	conn.buf.Reset()
+	if err := c.UnwatchAll(); err != nil {
+		t.Fatal(err)
+	}
+	if got := conn.String(); got != "rAAPL\r\nrIBM\r\nrMSFT\r\n" {
+		t.Errorf("unexpected unwatch commands %q", got)
+	}
+	if _, ok := c.WatchModeOf("AAPL"); ok {
+		t.Error("AAPL still watched after UnwatchAll")
+	}
+}
+
+func TestSymbolLimit(t *testing.T) {
+	c := newTestClient()
+	conn := c.Conn.(*recordConn)
+	c.MaxSymbols = 2
+	c.WatchSymbol("AAPL")
+	c.WatchSymbol("MSFT")
+	if err := c.WatchSymbol("AAPL"); err != nil {
+		t.Errorf("re-watching a watched symbol must not count against the limit: %v", err)
+	}
+	err := c.WatchSymbol("IBM")
+	var e *ErrorMsg
+	if !errors.Is(err, ErrSymbolLimit) || !errors.As(err, &e) || e.Symbol != "IBM" {
+		t.Errorf("expected ErrSymbolLimit for IBM, got %v", err)
+	}
+	if err := c.WatchSymbols([]string{"GOOG", "IBM"}); !errors.Is(err, ErrSymbolLimit) {
+		t.Errorf("expected ErrSymbolLimit for the batch, got %v", err)
+	}
+	if strings.Contains(conn.String(), "IBM") || strings.Contains(conn.String(), "GOOG") {
+		t.Errorf("commands over the limit were sent: %q", conn.String())
+	}
+
+	c.processReceiver([]byte("S,SYMBOL LIMIT REACHED,MSFT"))
+	if s := <-c.System; s.Type != "SYMBOL LIMIT REACHED" || s.Symbol != "MSFT" {
+		t.Errorf("unexpected system message %+v", s)
+	}
+	if e := <-c.Errors; !errors.Is(e, ErrSymbolLimit) || e.Symbol != "MSFT" {
+		t.Errorf("unexpected error %+v", e)
+	}
+	if got := c.WatchedSymbols(); !reflect.DeepEqual(got, []string{"AAPL"}) {
+		t.Errorf("expected the dropped symbol to be unwatched, watching %v", got)
+	}
+}
+
+// slowConn writes one byte at a time, yielding in between, so unserialized writers interleave.
+type slowConn struct {
+	net.Conn
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (s *slowConn) Write(b []byte) (int, error) {
+	for i := range b {
+		s.mu.Lock()
+		s.buf.WriteByte(b[i])
+		s.mu.Unlock()
+		runtime.Gosched()
+	}
+	return len(b), nil
+}
+
+func TestConcurrentWrites(t *testing.T) {
+	c := newTestClient()
+	conn := &slowConn{}
+	c.Conn = conn
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			c.WatchSymbol(fmt.Sprintf("SYM%02d", i))
+		}(i)
+	}
+	wg.Wait()
+	for _, l := range strings.Split(strings.TrimSuffix(conn.buf.String(), "\r\n"), "\r\n") {
+		if len(l) != 6 || !strings.HasPrefix(l, "wSYM") {
+			t.Fatalf("interleaved command %q", l)
+		}
+	}
+}
+
+func TestStartWithProtocol(t *testing.T) {
+	l := listenFeed(t)
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if strings.HasPrefix(line, "S,SET PROTOCOL,") {
+				conn.Write([]byte("S,CURRENT PROTOCOL," + strings.TrimSpace(line[15:]) + "\r\n"))
+			}
+		}
+	}()
+	c := &IQC{TimeZone: "UTC"}
+	if _, err := c.Start(l.Addr().String(), 16, "6.2"); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	if v := c.Protocol(); v != "6.2" {
+		t.Errorf("Protocol() = %q", v)
+	}
+	s := <-c.System
+	if s.Type == "CURRENT UPDATE FIELDNAMES" {
+		s = <-c.System
+	}
+	if s.Type != "CURRENT PROTOCOL" || s.Protocol != "6.2" {
+		t.Errorf("system message = %+v", s)
+	}
+}
+
+func TestSetProtocolTimeout(t *testing.T) {
+	c, server := pipeClient()
+	defer c.Stop()
+	defer server.Close()
+	c.ConfirmTimeout = 20 * time.Millisecond
+	go func() {
+		r := bufio.NewReader(server)
+		r.ReadString('\n')
+		// A confirmation for another version doesn't satisfy the request.
+		server.Write([]byte("S,CURRENT PROTOCOL,5.1\r\n"))
+		for {
+			if _, err := r.ReadString('\n'); err != nil {
+				return
+			}
+		}
+	}()
+	if err := c.SetProtocol("6.2"); !errors.Is(err, ErrTimeout) {
+		t.Errorf("expected ErrTimeout, got %v", err)
+	}
+}
+
+func TestDynFieldsConcurrentLayoutChange(t *testing.T) {
+	c := newTestClient()
+	c.Updates = make(chan *UpdSummaryMsg)
+	c.System = make(chan *SystemMessage)
+	c.stop = make(chan struct{})
+	go func() {
+		for range c.Updates {
+		}
+	}()
+	go func() {
+		for range c.System {
+		}
+	}()
+	c.setDynFields([]string{"Symbol", "Last", "Bid"})
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 500; i++ {
+			if i%2 == 0 {
+				c.processSysMsg([]byte("CURRENT UPDATE FIELDNAMES,Symbol,Bid,Last"))
+			} else {
+				c.processSysMsg([]byte("CURRENT UPDATE FIELDNAMES,Symbol,Last,Bid"))
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 500; i++ {
+			c.processSummaryMsg([]byte("AAPL,95.02,95.01"), Received{})
+			if n := c.UpdateFieldNames(); len(n) != 3 || n[0] != "Symbol" {
+				t.Errorf("torn layout %q", n)
+				return
+			}
+		}
+	}()
+	wg.Wait()
+	close(c.Updates)
+	close(c.System)
+
+	// A shorter layout replaces the old one entirely.
+	c.setDynFields([]string{"Symbol", "Last"})
+	if n := c.UpdateFieldNames(); !reflect.DeepEqual(n, []string{"Symbol", "Last"}) {
+		t.Errorf("UpdateFieldNames() = %q", n)
+	}
+}
+
+func TestReadLineJoinsFragments(t *testing.T) {
+	headline := strings.Repeat("Apple unveils new devices, ", 400)
+	in := "N,DTN,22110963127,AAPL:,20160314 093000," + headline + "\r\nT,20160314 09:30:00\r\n"
+	// A tiny buffer makes ReadLine return the news line in many fragments.
+	r := bufio.NewReaderSize(strings.NewReader(in), 16)
+	first, err := readLine(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first) <= 8*1024 || !strings.HasSuffix(string(first), headline) {
+		t.Errorf("news line was truncated to %d bytes", len(first))
+	}
+	second, err := readLine(r)
+	if err != nil || string(second) != "T,20160314 09:30:00" {
+		t.Errorf("line after the long one = %q, %v", second, err)
+	}
+}
+
+// recordLogger keeps every message logged along with its level.
+type recordLogger struct {
+	mu   sync.Mutex
+	msgs []string
+}
+
+func (l *recordLogger) add(level, format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.msgs = append(l.msgs, level+": "+fmt.Sprintf(format, args...))
+}
+
+func (l *recordLogger) Debugf(format string, args ...interface{}) { l.add("debug", format, args...) }
+func (l *recordLogger) Infof(format string, args ...interface{})  { l.add("info", format, args...) }
+func (l *recordLogger) Warnf(format string, args ...interface{})  { l.add("warn", format, args...) }
+func (l *recordLogger) Errorf(format string, args ...interface{}) { l.add("error", format, args...) }
+
+func TestCustomLogger(t *testing.T) {
+	logs := &recordLogger{}
+	c := newTestClient()
+	c.Logger = logs
+	server, client := net.Pipe()
+	c.Conn = client
+	c.startReader(c.read)
+	server.Close()
+	<-c.done
+
+	logs.mu.Lock()
+	defer logs.mu.Unlock()
+	if !reflect.DeepEqual(logs.msgs, []string{"error: Pipe closed exiting..."}) {
+		t.Errorf("logged %q", logs.msgs)
+	}
+}
+
+func TestTradeConditionsAndContents(t *testing.T) {
+	c := newTestClient()
+	c.setDynFields([]string{"Symbol", "Most Recent Trade Conditions", "Message Contents"})
+	c.processUpdMsg([]byte("AAPL,013D8Zab,Ev"), Received{})
+	u := <-c.Updates
+	if !reflect.DeepEqual(u.Conditions, []TradeCondition{TradeConditionRegular, 0x3D, 0xAB}) || u.MostRecntTradeCond != "013D8Zab" {
+		t.Errorf("conditions = %v from %q", u.Conditions, u.MostRecntTradeCond)
+	}
+	if u.Conditions[1].String() != "3D" {
+		t.Errorf("String() = %q", u.Conditions[1].String())
+	}
+	want := MessageContents{Extended: true, Volume: true}
+	if u.Contents != want || !u.Contents.Trade() {
+		t.Errorf("contents = %+v", u.Contents)
+	}
+}
+
+func TestWatchSymbolContents(t *testing.T) {
+	c := newTestClient()
+	c.setDynFields([]string{"Symbol", "Last", "Message Contents"})
+	trades := MessageContents{LastQualified: true, Extended: true, OtherTrade: true}
+	if err := c.WatchSymbolContents("AAPL", trades); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.Conn.(*recordConn).String(); got != "wAAPL\r\n" {
+		t.Errorf("sent %q", got)
+	}
+	c.processUpdMsg([]byte("AAPL,95.01,ba"), Received{})
+	c.processUpdMsg([]byte("AAPL,95.02,Cv"), Received{})
+	c.processUpdMsg([]byte("AAPL,95.03,"), Received{})
+	c.processUpdMsg([]byte("MSFT,51.10,b"), Received{})
+	c.processSummaryMsg([]byte("AAPL,95.04,b"), Received{})
+	for _, want := range []float64{95.02, 51.10, 95.04} {
+		if u := <-c.Updates; u.Last != want {
+			t.Errorf("got update %s %v, want last %v", u.Symbol, u.Last, want)
+		}
+	}
+	if len(c.Updates) != 0 {
+		t.Errorf("expected the filtered updates to be dropped, %d left", len(c.Updates))
+	}
+
+	// Watching the symbol again without a filter delivers everything.
+	c.WatchSymbol("AAPL")
+	c.processUpdMsg([]byte("AAPL,95.05,a"), Received{})
+	if u := <-c.Updates; u.Last != 95.05 {
+		t.Errorf("got last %v", u.Last)
+	}
+	c.WatchSymbolContents("AAPL", MessageContents{Bid: true})
+	c.UnwatchSymbol("AAPL")
+	c.processUpdMsg([]byte("AAPL,95.06,a"), Received{})
+	if u := <-c.Updates; u.Last != 95.06 {
+		t.Errorf("got last %v", u.Last)
+	}
+}
+
+func TestDecimalPrices(t *testing.T) {
+	c := newTestClient()
+	c.setDynFields([]string{"Symbol", "Bid", "Ask"})
+	c.processUpdMsg([]byte("ZBM16,0.1,-99.015625"), Received{})
+	u := <-c.Updates
+	if v, ok := u.RawValue("Bid"); !ok || v != "0.1" {
+		t.Errorf("raw bid = %q, %v", v, ok)
+	}
+	bid, err := u.DecimalValue("Bid")
+	if err != nil || bid != (Decimal{Units: 1, Scale: 1}) || bid.Rat().Cmp(big.NewRat(1, 10)) != 0 {
+		t.Errorf("bid = %+v, %v", bid, err)
+	}
+	ask, err := u.DecimalValue("Ask")
+	if err != nil || ask.String() != "-99.015625" || ask.Float64() != -99.015625 {
+		t.Errorf("ask = %v, %v", ask, err)
+	}
+	if _, err := u.DecimalValue("Last"); !errors.Is(err, ErrUnknownField) {
+		t.Errorf("expected ErrUnknownField, got %v", err)
+	}
+	for _, bad := range []string{"", ".", "1.2.3", "abc", "99999999999999999999"} {
+		if _, err := ParseDecimal(bad); err == nil {
+			t.Errorf("expected an error parsing %q", bad)
+		}
+	}
+}
+
+func TestPrecision(t *testing.T) {
+	c := newTestClient()
+	if _, ok := c.Precision("AAPL"); ok || c.FormatPrice("AAPL", 101.5) != "101.5" {
+		t.Errorf("precision known before any message")
+	}
+	c.processFndMsg([]byte(equityFundamental))
+	<-c.Fundamental
+	if p, ok := c.Precision("AAPL"); !ok || p != 4 || c.FormatPrice("AAPL", 101.5) != "101.5000" {
+		t.Errorf("fundamental precision = %d, %v", p, ok)
+	}
+
+	c.setDynFields([]string{"Symbol", "Last", "Decimal Precision"})
+	c.processSummaryMsg([]byte("AAPL,101.5,2,"), Received{})
+	if p, ok := (<-c.Updates).Precision(); !ok || p != 2 {
+		t.Errorf("summary precision = %d, %v", p, ok)
+	}
+	if got := c.FormatPrice("AAPL", 101.5); got != "101.50" {
+		t.Errorf("FormatPrice = %q", got)
+	}
+	// Updates that don't carry the field keep the last precision.
+	c.processUpdMsg([]byte("AAPL,101.75,,"), Received{})
+	if _, ok := (<-c.Updates).Precision(); ok || c.FormatPrice("AAPL", 101.75) != "101.75" {
+		t.Errorf("precision lost on an update without it")
+	}
+}
+
+func TestPriceFormat(t *testing.T) {
+	for _, tc := range []struct {
+		code  PriceFormat
+		price float64
+		want  string
+	}{
+		{14, 101.5, "101.5000"},
+		{10, 101.5, "102"},
+		{3, 12.375, "12 3/8"},
+		{3, 12, "12"},
+		{5, 125.15625, "125-05"},
+		{6, 99.984375, "99-63"},
+		{8, 1.5, "1-128"},
+		{5, -0.5, "-0-16"},
+		{5, 1.999, "2-00"},
+	} {
+		if got, ok := tc.code.Format(tc.price); !ok || got != tc.want {
+			t.Errorf("code %d: Format(%v) = %q, want %q", tc.code, tc.price, got, tc.want)
+		}
+	}
+	if _, ok := PriceFormat(0).Format(1); ok {
+		t.Error("expected code 0 to have no format")
+	}
+
+	c := newTestClient()
+	c.processFndMsg([]byte(strings.Replace(futureFundamental, ",0,12,2,", ",0,5,2,", 1)))
+	<-c.Fundamental
+	if f, ok := c.PriceFormat("@ESM16"); !ok || f != 5 {
+		t.Errorf("price format = %d, %v", f, ok)
+	}
+	if got := c.FormatPrice("@ESM16", 125.15625); got != "125-05" {
+		t.Errorf("FormatPrice = %q", got)
+	}
+}
+
+func TestLastQuote(t *testing.T) {
+	c := newTestClient()
+	c.EmitMerged = true
+	c.Merged = make(chan *UpdSummaryMsg, 16)
+	c.setDynFields([]string{"Symbol", "Last", "Bid", "Ask", "Message Contents"})
+	if _, ok := c.LastQuote("AAPL"); ok {
+		t.Errorf("state known before any message")
+	}
+	c.processSummaryMsg([]byte("AAPL,95.02,95.01,95.03,"), Received{})
+	c.processUpdMsg([]byte("AAPL,95.10,,,C,"), Received{})
+	for range [2]int{} {
+		<-c.Updates
+	}
+	if m := <-c.Merged; m.Kind != KindSummary || m.Bid != 95.01 {
+		t.Errorf("merged summary = %+v", m)
+	}
+	m := <-c.Merged
+	if m.Kind != KindTrade || m.Last != 95.10 || m.Bid != 95.01 || m.Ask != 95.03 {
+		t.Errorf("merged trade = kind %s last %v bid %v ask %v", m.Kind, m.Last, m.Bid, m.Ask)
+	}
+	if v, _ := m.RawValue("Ask"); v != "95.03" {
+		t.Errorf("raw ask = %q", v)
+	}
+	q, ok := c.LastQuote("AAPL")
+	if !ok || q.Last != 95.10 || q.Bid != 95.01 {
+		t.Errorf("LastQuote = %+v, %v", q, ok)
+	}
+
+	// A new summary replaces the state and unwatching forgets it.
+	c.processSummaryMsg([]byte("AAPL,96,,,"), Received{})
+	<-c.Updates
+	if m := <-c.Merged; m.Last != 96 || m.Bid != 0 {
+		t.Errorf("summary did not reset the state: %+v", m)
+	}
+	c.UnwatchSymbol("AAPL")
+	if _, ok := c.LastQuote("AAPL"); ok {
+		t.Errorf("state kept after unwatching")
+	}
+}
+
+func TestUpdateKindAndTradesOnly(t *testing.T) {
+	c := newTestClient()
+	c.setDynFields([]string{"Symbol", "Last", "Bid", "Message Contents"})
+	c.processSummaryMsg([]byte("AAPL,95.02,95.01,Cbav"), Received{})
+	c.processUpdMsg([]byte("AAPL,95.03,95.01,Cv"), Received{})
+	c.processUpdMsg([]byte("AAPL,95.03,95.02,b"), Received{})
+	c.processUpdMsg([]byte("AAPL,95.03,95.02,v"), Received{})
+	var kinds []UpdateKind
+	for len(c.Updates) > 0 {
+		kinds = append(kinds, (<-c.Updates).Kind)
+	}
+	if !reflect.DeepEqual(kinds, []UpdateKind{KindSummary, KindTrade, KindQuote, KindOther}) {
+		t.Errorf("kinds = %v", kinds)
+	}
+
+	c.TradesOnly = true
+	c.processSummaryMsg([]byte("AAPL,95.02,95.01,Cbav"), Received{})
+	c.processUpdMsg([]byte("AAPL,95.03,95.02,ba"), Received{})
+	c.processUpdMsg([]byte("AAPL,95.04,95.02,Ev"), Received{})
+	kinds = nil
+	for len(c.Updates) > 0 {
+		kinds = append(kinds, (<-c.Updates).Kind)
+	}
+	if !reflect.DeepEqual(kinds, []UpdateKind{KindSummary, KindTrade}) {
+		t.Errorf("kinds with TradesOnly = %v", kinds)
+	}
+}
+
+func TestTimestampsOff(t *testing.T) {
+	l := listenFeed(t)
+	defer l.Close()
+	c := &IQC{TimeZone: "UTC", TimestampsOff: true}
+	if _, err := c.Start(l.Addr().String(), 16); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	c.EnableTimestamps()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	r := bufio.NewReader(conn)
+	var got []string
+	for i := 0; i < 3; i++ {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading commands: %s (got %q)", err, got)
+		}
+		got = append(got, line)
+	}
+	want := []string{"S,TIMESTAMPSOFF\r\n", "S,REQUEST CURRENT UPDATE FIELDNAMES\r\n", "S,TIMESTAMPSON\r\n"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("commands = %q, want %q", got, want)
+	}
+}
+
+func TestWatchdogReconnectsStaleFeed(t *testing.T) {
+	l := listenFeed(t)
+	defer l.Close()
+	c := &IQC{TimeZone: "UTC", StaleTimeout: 50 * time.Millisecond, ReconnectOnStale: true, ReconnectEnabled: true, InitialBackoff: time.Millisecond}
+	if _, err := c.Start(l.Addr().String(), 16); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	first, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	// The first connection stays silent, the watchdog drops it and a new one is dialled.
+	second, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+
+	var states []ConnectionState
+	for len(states) < 4 {
+		select {
+		case e := <-c.Connection:
+			states = append(states, e.State)
+		case <-time.After(time.Second):
+			t.Fatalf("missing connection events, got %v", states)
+		}
+	}
+	if !reflect.DeepEqual(states, []ConnectionState{StateStale, StateDisconnected, StateReconnecting, StateReconnected}) {
+		t.Errorf("connection events = %v", states)
+	}
+	if time.Since(c.LastReceived()) > time.Second {
+		t.Errorf("LastReceived not reset on reconnect: %s", c.LastReceived())
+	}
+}
+
+func TestWatchL2(t *testing.T) {
+	feed := listenFeed(t)
+	defer feed.Close()
+	l2, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("cannot listen: %s", err)
+	}
+	defer l2.Close()
+	go func() {
+		conn, err := l2.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if line == "wAAPL\r\n" {
+				conn.Write([]byte("Z,AAPL,NSDQ,95.01,95.03,300,200,09:30:00.123456,2016-03-14,52,09:30:01.000000,T,T,E,\r\n"))
+				conn.Write([]byte("2,AAPL,ARCA,95.00,,100,,09:30:02,2016-03-14,52,,T,F,\r\n"))
+				conn.Write([]byte("n,BOGUS\r\n"))
+			}
+		}
+	}()
+
+	c := &IQC{TimeZone: "UTC", L2Address: l2.Addr().String()}
+	if err := c.WatchL2("AAPL"); err != ErrNotStarted {
+		t.Errorf("expected ErrNotStarted before Start, got %v", err)
+	}
+	if _, err := c.Start(feed.Addr().String(), 16); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.WatchL2("AAPL"); err != nil {
+		t.Fatal(err)
+	}
+	var msgs []*L2Msg
+	for len(msgs) < 2 {
+		select {
+		case m := <-c.Depth:
+			msgs = append(msgs, m)
+		case <-time.After(time.Second):
+			t.Fatalf("missing depth messages, got %d", len(msgs))
+		}
+	}
+	z := msgs[0]
+	if !z.Summary || z.Symbol != "AAPL" || z.MarketMaker != "NSDQ" || z.Bid != 95.01 || z.Ask != 95.03 || z.BidSize != 300 || z.AskSize != 200 ||
+		!z.BidTime.Equal(time.Date(2016, 3, 14, 9, 30, 0, 123456000, time.UTC)) || !z.AskValid || !z.EndOfGroup {
+		t.Errorf("summary = %+v", z)
+	}
+	if u := msgs[1]; u.Summary || u.MarketMaker != "ARCA" || !u.BidValid || u.AskValid || u.EndOfGroup {
+		t.Errorf("update = %+v", u)
+	}
+	select {
+	case e := <-c.Errors:
+		if !errors.Is(e, ErrSymbolNotFound) || e.Symbol != "BOGUS" {
+			t.Errorf("error = %+v", e)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no not found error")
+	}
+
+	c.Stop()
+	if _, ok := <-c.Depth; ok {
+		t.Error("expected Depth to be closed")
+	}
+	if err := c.WatchL2("MSFT"); !errors.Is(err, ErrClientStopped) {
+		t.Errorf("expected ErrClientStopped after Stop, got %v", err)
+	}
+}
+
+func TestWatchIntervalBars(t *testing.T) {
+	feed := listenFeed(t)
+	defer feed.Close()
+	deriv, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("cannot listen: %s", err)
+	}
+	defer deriv.Close()
+	cmds := make(chan string, 4)
+	go func() {
+		conn, err := deriv.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			cmds <- line
+			if strings.HasPrefix(line, "BW,AAPL,60,") {
+				id := strings.Split(line, ",")[8]
+				conn.Write([]byte(id + ",BU,AAPL,2016-03-14 09:31:00,95.00,95.10,94.90,95.05,1325032,1200,15,\r\n"))
+				conn.Write([]byte(id + ",BC,AAPL,2016-03-14 09:31:00,95.00,95.20,94.90,95.15,1325532,1700,21,\r\n"))
+			}
+			if strings.HasPrefix(line, "BW,BOGUS,") {
+				conn.Write([]byte(strings.Split(line, ",")[8] + ",n,BOGUS\r\n"))
+			}
+		}
+	}()
+
+	c := &IQC{TimeZone: "UTC", DerivAddress: deriv.Addr().String()}
+	if err := c.WatchIntervalBars("AAPL", 60); err != ErrNotStarted {
+		t.Errorf("expected ErrNotStarted before Start, got %v", err)
+	}
+	if _, err := c.Start(feed.Addr().String(), 16); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	if err := c.WatchIntervalBars("AAPL", 0); err == nil {
+		t.Error("expected an invalid interval to be rejected")
+	}
+	if err := c.WatchIntervalBars("AAPL", 60); err != nil {
+		t.Fatal(err)
+	}
+	if cmd := <-cmds; !strings.HasPrefix(cmd, "BW,AAPL,60,,,,,,") || !strings.HasSuffix(cmd, ",s,,\r\n") {
+		t.Errorf("command = %q", cmd)
+	}
+	var bars []*IntervalBar
+	for len(bars) < 2 {
+		select {
+		case b := <-c.Bars:
+			bars = append(bars, b)
+		case <-time.After(time.Second):
+			t.Fatalf("missing bars, got %d", len(bars))
+		}
+	}
+	u, b := bars[0], bars[1]
+	if u.Complete || u.Symbol != "AAPL" || u.Interval != 60 || u.Close != 95.05 || u.Volume != 1200 || u.Trades != 15 ||
+		!u.Time.Equal(time.Date(2016, 3, 14, 9, 31, 0, 0, time.UTC)) {
+		t.Errorf("forming bar = %+v", u)
+	}
+	if !b.Complete || b.Historical || b.High != 95.2 || b.TotalVolume != 1325532 {
+		t.Errorf("complete bar = %+v", b)
+	}
+
+	c.WatchIntervalBars("BOGUS", 60)
+	<-cmds
+	select {
+	case e := <-c.Errors:
+		if !errors.Is(e, ErrSymbolNotFound) || e.Symbol != "BOGUS" {
+			t.Errorf("error = %+v", e)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no not found error")
+	}
+	if err := c.UnwatchIntervalBars("AAPL"); err != nil {
+		t.Fatal(err)
+	}
+	if cmd := <-cmds; cmd != "BR,AAPL\r\n" {
+		t.Errorf("unwatch command = %q", cmd)
+	}
+}
+
+const statsLine = "S,STATS,66.112.156.225,60004,500,3,1,0,2,5,Mar 14 9:29AM,Mar 14 9:30AM,Connected,6.1.0.20,123456,1024.51,1.25,2.50,10.75,0.10,0.20,"
+
+func TestBook(t *testing.T) {
+	c := newTestClient()
+	c.Depth = make(chan *L2Msg, 16)
+	b := NewBook()
+	apply := func(lines ...string) {
+		for _, l := range lines {
+			c.processL2([]byte(l))
+			b.Apply(<-c.Depth)
+		}
+	}
+	apply(
+		"Z,AAPL,NSDQ,95.01,95.03,300,200,09:30:00,2016-03-14,52,09:30:00,T,T,E,",
+		"Z,AAPL,ARCA,95.01,95.04,100,500,09:30:00,2016-03-14,52,09:30:00,T,T,E,",
+		"Z,AAPL,EDGX,95.00,95.03,400,100,09:30:00,2016-03-14,52,09:30:00,T,T,E,",
+		"Z,MSFT,NSDQ,52.10,52.11,100,100,09:30:00,2016-03-14,52,09:30:00,T,T,E,",
+		// ARCA moves its bid, a late message from before must not roll it back.
+		"2,AAPL,ARCA,95.02,95.04,200,500,09:30:02,2016-03-14,52,09:30:00,T,T,E,",
+		"2,AAPL,ARCA,94.99,95.04,900,500,09:30:01,2016-03-14,52,09:30:00,T,T,E,",
+		// EDGX pulls its ask, then deletes a market maker that was never in the book.
+		"2,AAPL,EDGX,95.00,,400,,09:30:03,2016-03-14,52,09:30:03,T,F,E,",
+		"2,AAPL,BATS,,,,,09:30:03,2016-03-14,52,09:30:03,F,F,E,",
+	)
+
+	bids, asks := b.Snapshot("AAPL")
+	wantBids := []PriceLevel{{95.02, 200, 1}, {95.01, 300, 1}, {95.00, 400, 1}}
+	wantAsks := []PriceLevel{{95.03, 200, 1}, {95.04, 500, 1}}
+	if !reflect.DeepEqual(bids, wantBids) || !reflect.DeepEqual(asks, wantAsks) {
+		t.Errorf("book = %v / %v, want %v / %v", bids, asks, wantBids, wantAsks)
+	}
+
+	// Sizes at the same price add up, a market maker without either side leaves the book.
+	apply("2,AAPL,NSDQ,95.02,,100,,09:30:04,2016-03-14,52,09:30:04,T,F,E,",
+		"2,AAPL,EDGX,,,,,09:30:04,2016-03-14,52,09:30:04,F,F,E,")
+	bids, asks = b.Snapshot("AAPL")
+	if !reflect.DeepEqual(bids, []PriceLevel{{95.02, 300, 2}}) || !reflect.DeepEqual(asks, []PriceLevel{{95.04, 500, 1}}) {
+		t.Errorf("book = %v / %v", bids, asks)
+	}
+
+	b.Clear("AAPL")
+	if bids, asks := b.Snapshot("AAPL"); len(bids)+len(asks) != 0 {
+		t.Errorf("expected an empty book after Clear, got %v / %v", bids, asks)
+	}
+	if bids, _ := b.Snapshot("MSFT"); len(bids) != 1 {
+		t.Errorf("other symbols must be kept, got %v", bids)
+	}
+}
+
+func TestDepthLevels(t *testing.T) {
+	c := newTestClient()
+	c.Depth = make(chan *L2Msg, 16)
+	if err := c.SetDepthLevels(-1); err == nil {
+		t.Error("expected an error for a negative number of levels")
+	}
+	if err := c.SetDepthLevels(2); err != nil || c.DepthLevels() != 2 {
+		t.Fatalf("DepthLevels() = %d, %v", c.DepthLevels(), err)
+	}
+	stream := func(lines ...string) []string {
+		var mms []string
+		for _, l := range lines {
+			c.processL2([]byte(l))
+		}
+		for len(c.Depth) > 0 {
+			mms = append(mms, (<-c.Depth).MarketMaker)
+		}
+		return mms
+	}
+	got := stream(
+		"Z,AAPL,NSDQ,95.02,95.03,300,200,09:30:00,2016-03-14,52,09:30:00,T,T,E,",
+		"Z,AAPL,ARCA,95.01,95.04,100,500,09:30:00,2016-03-14,52,09:30:00,T,T,E,",
+		// Third on both sides.
+		"Z,AAPL,EDGX,95.00,95.05,400,100,09:30:00,2016-03-14,52,09:30:00,T,T,E,",
+		"2,AAPL,BATS,95.03,95.06,100,100,09:30:01,2016-03-14,52,09:30:01,T,T,E,",
+		"2,AAPL,EDGX,94.90,95.10,400,100,09:30:02,2016-03-14,52,09:30:02,T,T,E,",
+		// ARCA's bid was pushed out but its ask is still second best, so it leaving is sent.
+		"2,AAPL,ARCA,,,,,09:30:03,2016-03-14,52,09:30:03,F,F,E,",
+	)
+	if want := []string{"NSDQ", "ARCA", "BATS", "ARCA"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("depth messages from %q, want %q", got, want)
+	}
+
+	// A Book fed from the full depth keeps every level, Top reads the best ones.
+	c.SetDepthLevels(0)
+	b := NewBook()
+	for _, l := range []string{
+		"Z,MSFT,NSDQ,52.10,52.11,100,100,09:30:00,2016-03-14,52,09:30:00,T,T,E,",
+		"Z,MSFT,ARCA,52.09,52.12,100,100,09:30:00,2016-03-14,52,09:30:00,T,T,E,",
+		"Z,MSFT,EDGX,52.08,52.13,100,100,09:30:00,2016-03-14,52,09:30:00,T,T,E,",
+	} {
+		c.processL2([]byte(l))
+		b.Apply(<-c.Depth)
+	}
+	bids, asks := b.Top("MSFT", 2)
+	if len(bids) != 2 || bids[1].Price != 52.09 || len(asks) != 2 || asks[1].Price != 52.12 {
+		t.Errorf("top of the book = %v / %v", bids, asks)
+	}
+	if got := stream("2,AAPL,EDGX,94.80,95.20,400,100,09:30:04,2016-03-14,52,09:30:04,T,T,E,"); !reflect.DeepEqual(got, []string{"EDGX"}) {
+		t.Errorf("depth messages from %q without a limit", got)
+	}
+}
+func TestAdminStats(t *testing.T) {
+	feed := listenFeed(t)
+	defer feed.Close()
+	admin, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("cannot listen: %s", err)
+	}
+	defer admin.Close()
+	go func() {
+		conn, err := admin.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte("S,REGISTER CLIENT APP COMPLETED,\r\n" + statsLine + "\r\n"))
+		io.Copy(ioutil.Discard, conn)
+	}()
+
+	c := &IQC{TimeZone: "UTC", AdminAddress: admin.Addr().String()}
+	if _, err := c.Start(feed.Addr().String(), 16); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	if err := c.ConnectAdmin(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case st := <-c.Stats:
+		if st.ServerIP != "66.112.156.225" || st.ServerPort != 60004 || st.NumberOfSymbols != 3 || st.Reconnections != 2 || st.AttemptedReconnections != 5 ||
+			!st.ServerConnected() || st.LoginID != "123456" || st.TotalKBsRecv != 1024.51 || st.AvgKBsPerSecSent != 0.2 {
+			t.Errorf("stats = %+v", st)
+		}
+		if st.MarketTime.Month() != time.March || st.MarketTime.Day() != 14 || st.MarketTime.Hour() != 9 || st.MarketTime.Minute() != 30 || st.MarketTime.Year() != time.Now().Year() {
+			t.Errorf("market time = %s", st.MarketTime)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no stats received")
+	}
+}
+
+func TestLoginCommands(t *testing.T) {
+	conn := &cannedConn{r: strings.NewReader(fieldsLine)}
+	c := &IQC{TimeZone: "UTC", Logger: NopLogger{}, ClientName: "scanner"}
+	if _, err := c.StartConn(conn, 16); err != nil {
+		t.Fatal(err)
+	}
+	c.Stop()
+	if !strings.Contains(conn.written(), "S,SET CLIENT NAME,scanner\r\n") {
+		t.Errorf("client name not sent, wrote %q", conn.written())
+	}
+
+	feed := listenFeed(t)
+	defer feed.Close()
+	admin, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("cannot listen: %s", err)
+	}
+	defer admin.Close()
+	lines := make(chan string, 8)
+	go func() {
+		conn, err := admin.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			lines <- line
+		}
+	}()
+
+	c = &IQC{TimeZone: "UTC", AdminAddress: admin.Addr().String()}
+	if err := c.SetLoginID("123456"); !errors.Is(err, ErrNotStarted) {
+		t.Errorf("expected ErrNotStarted, got %v", err)
+	}
+	if _, err := c.Start(feed.Addr().String(), 16); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	for _, err := range []error{c.SetLoginID("123456"), c.SetPassword("secret"), c.SaveLoginInfo(true), c.SetAutoconnect(false)} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, want := range []string{"S,SET LOGINID,123456\r\n", "S,SET PASSWORD,secret\r\n", "S,SET SAVE LOGIN INFO,On\r\n", "S,SET AUTOCONNECT,Off\r\n"} {
+		select {
+		case got := <-lines:
+			if got != want {
+				t.Errorf("admin command %q, want %q", got, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("admin command %q not received", want)
+		}
+	}
+}
+
+func TestStatsOnLevel1(t *testing.T) {
+	c := newTestClient()
+	c.Stats = make(chan *ClientStats, 1)
+	c.processSysMsg([]byte(statsLine[2:]))
+	if s := <-c.System; s.Type != "STATS" || s.Stats.MaxSymbols != 500 {
+		t.Errorf("system message = %+v", s)
+	}
+	if st := <-c.Stats; st.ClientsConnected != 1 {
+		t.Errorf("stats = %+v", st)
+	}
+}
+
+func TestServerConnectionMessages(t *testing.T) {
+	c := newTestClient()
+	c.Connection = make(chan *ConnectionEvent, 4)
+	if !c.ServerConnected() {
+		t.Error("expected the server to be connected initially")
+	}
+	c.processSysMsg([]byte("SERVER DISCONNECTED"))
+	if c.ServerConnected() {
+		t.Error("expected the server to be reported disconnected")
+	}
+	c.processSysMsg([]byte("SERVER RECONNECT FAILED"))
+	c.processSysMsg([]byte("SERVER CONNECTED"))
+	if !c.ServerConnected() {
+		t.Error("expected the server to be connected again")
+	}
+
+	want := []ConnectionState{StateServerDisconnected, StateServerReconnectFailed, StateServerConnected}
+	for i, w := range want {
+		e := <-c.Connection
+		if e.State != w {
+			t.Errorf("event %d = %s, want %s", i, e.State, w)
+		}
+		if i == 0 && !errors.Is(e.Err, ErrServerDisconnected) {
+			t.Errorf("disconnect event error = %v", e.Err)
+		}
+	}
+	// The messages are still sent on System as well.
+	if len(c.System) != 3 {
+		t.Errorf("expected 3 system messages, got %d", len(c.System))
+	}
+}
+
+func TestNewClient(t *testing.T) {
+	l := listenFeed(t)
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if strings.HasPrefix(line, "S,SELECT UPDATE FIELDS,") {
+						conn.Write([]byte("S,CURRENT UPDATE FIELDNAMES,Symbol," + strings.TrimSpace(line[23:]) + "\r\n"))
+					}
+				}
+			}()
+		}
+	}()
+
+	c, err := NewClient(Config{Address: l.Addr().String(), TimeZone: "UTC", Fields: []string{"Bid", "Ask"}, Reconnect: true, MaxReconnectAttempts: 3, Logger: NopLogger{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	if cap(c.Updates) != defaultBufferSize || !c.ReconnectEnabled || c.MaxReconnectAttempts != 3 || c.TimeLoc != time.UTC {
+		t.Errorf("client not configured from the config: %d slots, reconnect %v", cap(c.Updates), c.ReconnectEnabled)
+	}
+	if f := c.UpdateFieldNames(); !reflect.DeepEqual(f, []string{"Symbol", "Bid", "Ask"}) {
+		t.Errorf("field names = %q", f)
+	}
+
+	if _, err := NewClient(Config{Address: l.Addr().String(), TimeZone: "UTC", Fields: []string{"Bogus"}, StrictFields: true, Logger: NopLogger{}}); !errors.Is(err, ErrUnknownField) {
+		t.Errorf("expected ErrUnknownField, got %v", err)
+	}
+}
+
+func TestPing(t *testing.T) {
+	l := listenFeed(t)
+	defer l.Close()
+	answer := make(chan bool, 4)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if line == "T\r\n" && <-answer {
+				conn.Write([]byte("T,20160314 09:30:00\r\n"))
+			}
+		}
+	}()
+
+	c := &IQC{TimeZone: "UTC", Logger: NopLogger{}}
+	if c.Connected() || c.Ping(time.Second) != ErrNotStarted {
+		t.Error("expected a client that isn't started to be disconnected")
+	}
+	if _, err := c.Start(l.Addr().String(), 16); err != nil {
+		t.Fatal(err)
+	}
+	if !c.Connected() {
+		t.Error("expected the client to be connected")
+	}
+	answer <- true
+	if err := c.Ping(time.Second); err != nil {
+		t.Errorf("ping failed: %v", err)
+	}
+	answer <- false
+	if err := c.Ping(50 * time.Millisecond); err != ErrTimeout {
+		t.Errorf("expected ErrTimeout from a silent feed, got %v", err)
+	}
+	c.Stop()
+	if c.Connected() {
+		t.Error("expected the client to be disconnected after Stop")
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	c := newTestClient()
+	conn := c.Conn.(*recordConn)
+	c.processReceiver([]byte("S,CURRENT UPDATE FIELDNAMES,Symbol,Last"))
+	<-c.System
+	a1, cancel1, err := c.Subscribe("AAPL")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a2, cancel2, err := c.Subscribe("AAPL")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conn.String() != "wAAPL\r\n" {
+		t.Errorf("expected a single watch, wrote %q", conn.String())
+	}
+
+	c.processReceiver([]byte("P,AAPL,95.02"))
+	c.processReceiver([]byte("Q,MSFT,52.10"))
+	c.processReceiver([]byte("Q,AAPL,95.03"))
+	for _, ch := range []<-chan *UpdSummaryMsg{a1, a2} {
+		if u := <-ch; u.Kind != KindSummary || u.Last != 95.02 {
+			t.Errorf("unexpected first message %+v", u)
+		}
+		if u := <-ch; u.Last != 95.03 {
+			t.Errorf("unexpected second message %+v", u)
+		}
+	}
+	if len(c.Updates) != 1 || (<-c.Updates).Symbol != "MSFT" {
+		t.Error("expected only the unsubscribed symbol on Updates")
+	}
+
+	cancel1()
+	cancel1()
+	if _, ok := <-a1; ok {
+		t.Error("expected the cancelled channel to be closed")
+	}
+	if strings.Contains(conn.String(), "rAAPL") {
+		t.Error("unwatched while a subscriber is left")
+	}
+	cancel2()
+	if !strings.HasSuffix(conn.String(), "rAAPL\r\n") {
+		t.Errorf("expected the symbol to be unwatched, wrote %q", conn.String())
+	}
+	c.processReceiver([]byte("Q,AAPL,95.04"))
+	if u := <-c.Updates; u.Symbol != "AAPL" {
+		t.Errorf("expected AAPL back on Updates, got %+v", u)
+	}
+
+	// A symbol watched before it was subscribed to stays watched once the subscription is cancelled.
+	c.WatchSymbol("MSFT")
+	conn.buf.Reset()
+	_, cancel3, err := c.Subscribe("MSFT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancel3()
+	if _, watched := c.WatchModeOf("MSFT"); !watched || conn.String() != "" {
+		t.Errorf("expected MSFT to stay watched, wrote %q", conn.String())
+	}
+
+	// Stop closes the subscriptions still open.
+	a3, _, err := c.Subscribe("IBM")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Stop()
+	if _, ok := <-a3; ok {
+		t.Error("expected Stop to close the subscription")
+	}
+}
+
+func TestSubscribeConcurrent(t *testing.T) {
+	c := newTestClient()
+	conn := c.Conn.(*recordConn)
+	c.MaxSymbols = 1
+	subscribe := func(symbol string) []error {
+		errs := make([]error, 8)
+		var wg sync.WaitGroup
+		for i := range errs {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				ch, _, err := c.Subscribe(symbol)
+				if err == nil && cap(ch) != defaultSubscribeBufferSize {
+					err = fmt.Errorf("channel holds %d messages", cap(ch))
+				}
+				errs[i] = err
+			}(i)
+		}
+		wg.Wait()
+		return errs
+	}
+	for _, err := range subscribe("AAPL") {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	if conn.String() != "wAAPL\r\n" {
+		t.Errorf("expected a single watch, wrote %q", conn.String())
+	}
+
+	// Every caller gets the error of the watch made by the first one.
+	for _, err := range subscribe("IBM") {
+		if !errors.Is(err, ErrSymbolLimit) {
+			t.Errorf("expected ErrSymbolLimit, got %v", err)
+		}
+	}
+	c.MaxSymbols = 0
+	c.SubscribeBufferSize = 4
+	if ch, _, err := c.Subscribe("IBM"); err != nil || cap(ch) != 4 {
+		t.Errorf("subscribing after the failed watch = %v, %v", ch, err)
+	}
+}
@@ -0,0 +1,205 @@
+package iqfeed
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// defaultL2Port is the IQFeed Level 2 port, used when L2Address doesn't give one.
+const defaultL2Port = "9200"
+
+// L2Msg is a market maker or price level update from the Level 2 port, field definitions are available here: http://www.iqfeed.net/dev/api/docs/Level2Message.cfm.
+type L2Msg struct {
+	Summary       bool      // True for the summary (Z) sent when a symbol is first watched, false for updates (2).
+	Symbol        string    // The Symbol ID to match with watch request
+	MarketMaker   string    // Market maker ID, or the price level for depth of market symbols.
+	Bid           float64   // Bid price of the market maker.
+	Ask           float64   // Ask price of the market maker.
+	BidSize       int       // Size of the bid.
+	AskSize       int       // Size of the ask.
+	BidTime       time.Time // Time of the bid, combined with Date and interpreted in TimeLoc.
+	AskTime       time.Time // Time of the ask, combined with Date and interpreted in TimeLoc.
+	Date          time.Time // Date of the message.
+	ConditionCode string    // Quote condition code.
+	BidValid      bool      // False when the market maker has no bid.
+	AskValid      bool      // False when the market maker has no ask.
+	EndOfGroup    bool      // True on the last message of a group of updates sent together.
+
+	Received
+}
+
+// UnMarshall sends the data into the usable struct for consumption by the application.
+func (m *L2Msg) UnMarshall(items []string, loc *time.Location) {
+	for len(items) < 13 {
+		items = append(items, "")
+	}
+	m.Symbol = items[0]
+	m.MarketMaker = items[1]
+	m.Bid = GetFloatFromStr(items[2])
+	m.Ask = GetFloatFromStr(items[3])
+	m.BidSize = GetIntFromStr(items[4])
+	m.AskSize = GetIntFromStr(items[5])
+	m.Date = getL2Date(items[7], loc)
+	m.BidTime = getL2Time(m.Date, items[6], loc)
+	m.ConditionCode = items[8]
+	m.AskTime = getL2Time(m.Date, items[9], loc)
+	m.BidValid = items[10] != "F"
+	m.AskValid = items[11] != "F"
+	m.EndOfGroup = items[12] == "E"
+}
+
+// inLoc converts every timestamp on the message to loc.
+func (m *L2Msg) inLoc(loc *time.Location) {
+	inLoc(loc, &m.BidTime, &m.AskTime, &m.Date)
+}
+
+// getL2Date parses the message date which is CCYY-MM-DD, or MM/DD/CCYY on older protocols.
+func getL2Date(d string, loc *time.Location) time.Time {
+	if t, err := time.ParseInLocation("2006-01-02", d, loc); err == nil {
+		return t
+	}
+	return GetDateMMDDCCYY(d, loc)
+}
+
+// getL2Time parses an HH:MM:SS time with optional fraction on the given date.
+func getL2Time(date time.Time, d string, loc *time.Location) time.Time {
+	t, err := time.ParseInLocation("15:04:05.999999", d, loc)
+	if err != nil || date.IsZero() {
+		return t
+	}
+	return time.Date(date.Year(), date.Month(), date.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), loc)
+}
+
+// WatchL2 starts Level 2 market depth updates for symbol on the Depth channel, the Level 2 connection is dialled on first use. The client must have been started.
+func (c *IQC) WatchL2(symbol string) error {
+	symbol, err := c.normalizeSymbol(symbol)
+	if err != nil {
+		return err
+	}
+	return c.sendL2("w" + symbol + "\r\n")
+}
+
+// UnwatchL2 stops Level 2 market depth updates for symbol.
+func (c *IQC) UnwatchL2(symbol string) error {
+	symbol, err := c.normalizeSymbol(symbol)
+	if err != nil {
+		return err
+	}
+	c.depthMu.Lock()
+	if c.depthBook != nil {
+		c.depthBook.Clear(symbol)
+	}
+	c.depthMu.Unlock()
+	return c.sendL2("r" + symbol + "\r\n")
+}
+
+// SetDepthLevels limits the Level 2 messages sent on Depth to the top n price levels of each side of the book, 0 sends them all. IQFeed always sends the full depth, so the levels are kept by the client:
+// it rebuilds the book of every watched symbol and drops the messages of market makers neither within the top n levels nor leaving them. A Book fed from Depth may still hold levels pushed out by better ones, use Top to read it.
+// The setting stays in effect across reconnects of the Level 2 connection.
+func (c *IQC) SetDepthLevels(n int) error {
+	if n < 0 {
+		return fmt.Errorf("iqfeed: invalid number of depth levels %d", n)
+	}
+	atomic.StoreInt32(&c.depthLevels, int32(n))
+	if n == 0 {
+		c.depthMu.Lock()
+		c.depthBook = nil
+		c.depthMu.Unlock()
+	}
+	return nil
+}
+
+// DepthLevels returns the number of price levels per side sent on Depth, 0 when they all are. See SetDepthLevels.
+func (c *IQC) DepthLevels() int {
+	return int(atomic.LoadInt32(&c.depthLevels))
+}
+
+// beyondDepth reports whether m must not be sent on Depth because its market maker is outside the top DepthLevels price levels, both before and after m is applied.
+func (c *IQC) beyondDepth(m *L2Msg) bool {
+	n := c.DepthLevels()
+	if n == 0 {
+		return false
+	}
+	c.depthMu.Lock()
+	defer c.depthMu.Unlock()
+	if c.depthBook == nil {
+		c.depthBook = NewBook()
+	}
+	was := c.depthBook.withinLevels(m.Symbol, m.MarketMaker, n)
+	c.depthBook.Apply(m)
+	return !was && !c.depthBook.withinLevels(m.Symbol, m.MarketMaker, n)
+}
+
+// sendL2 writes a command to the Level 2 port, dialling it and starting its reader first if needed.
+func (c *IQC) sendL2(cmd string) error {
+	if c.stop == nil {
+		return ErrNotStarted
+	}
+	if c.stopped() {
+		return c.stopErr(ErrClientStopped)
+	}
+	c.l2Mu.Lock()
+	defer c.l2Mu.Unlock()
+	conn, err := c.service(&c.l2Conn, c.L2Address, defaultL2Port, c.readL2)
+	if err != nil {
+		return err
+	}
+	_, err = conn.Write([]byte(cmd))
+	return err
+}
+
+// readL2 reads the Level 2 connection until it is closed. Depth messages go to Depth, errors and not found symbols to Errors, everything else is ignored.
+func (c *IQC) readL2(conn net.Conn) {
+	c.readService(conn, &c.l2Conn, "Level 2", c.processL2)
+	// Symbols watched on the next connection start over from their summary messages.
+	c.depthMu.Lock()
+	c.depthBook = nil
+	c.depthMu.Unlock()
+}
+
+// processL2 handles a single line from the Level 2 port.
+func (c *IQC) processL2(d []byte) {
+	if len(d) < 2 {
+		return
+	}
+	received := c.stamp(d)
+	data := d[2:]
+	switch d[0] {
+	case 0x5A, 0x32: // Start letter is Z (summary) or 2 (update), indicating a depth message
+		m := &L2Msg{Summary: d[0] == 0x5A, Received: received}
+		m.UnMarshall(strings.Split(string(data), ","), c.TimeLoc)
+		if loc := c.outputLoc(); loc != nil {
+			m.inLoc(loc)
+		}
+		if c.beyondDepth(m) {
+			return
+		}
+		if !c.divert("Depth", c.Depth, m) {
+			select {
+			case c.Depth <- m:
+			case <-c.stop:
+			}
+		}
+	case 0x6E: // Start letter is n, indicating Symbol not found message
+		e := &ErrorMsg{Raw: string(d), Received: received}
+		e.UnMarshall(true, data, 404)
+		if !c.divert("Errors", c.Errors, e) {
+			select {
+			case c.Errors <- e:
+			case <-c.stop:
+			}
+		}
+	case 0x45: // Start letter is E, error message
+		e := &ErrorMsg{Raw: string(d), Received: received}
+		e.UnMarshall(false, data, 500)
+		if !c.divert("Errors", c.Errors, e) {
+			select {
+			case c.Errors <- e:
+			case <-c.stop:
+			}
+		}
+	}
+}
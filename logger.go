@@ -0,0 +1,35 @@
+package iqfeed
+
+import "log"
+
+// Logger receives the client's diagnostic messages, set IQC.Logger to route them to your own logging.
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+// stdLogger is the default Logger, it writes every level to the standard log package.
+type stdLogger struct{}
+
+func (stdLogger) Debugf(format string, args ...interface{}) { log.Printf(format, args...) }
+func (stdLogger) Infof(format string, args ...interface{})  { log.Printf(format, args...) }
+func (stdLogger) Warnf(format string, args ...interface{})  { log.Printf(format, args...) }
+func (stdLogger) Errorf(format string, args ...interface{}) { log.Printf(format, args...) }
+
+// NopLogger discards every message, use it to silence the client.
+type NopLogger struct{}
+
+func (NopLogger) Debugf(format string, args ...interface{}) {}
+func (NopLogger) Infof(format string, args ...interface{})  {}
+func (NopLogger) Warnf(format string, args ...interface{})  {}
+func (NopLogger) Errorf(format string, args ...interface{}) {}
+
+// log returns the configured Logger or the standard one.
+func (c *IQC) log() Logger {
+	if c.Logger != nil {
+		return c.Logger
+	}
+	return stdLogger{}
+}
@@ -0,0 +1,291 @@
+package iqfeed
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// defaultLookupPort is the IQFeed lookup port, used when LookupAddress doesn't give one.
+const defaultLookupPort = "9100"
+
+// defaultMaxLookups is the number of lookup requests in flight at once when MaxLookups is not set.
+const defaultMaxLookups = 1
+
+// lookupStream is one connection to the lookup port, it carries a single request at a time.
+type lookupStream struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// lookup sends a request tagged with id on the lookup port and calls row with the fields of every data line answering it, up to the !ENDMSG! terminator.
+// The request id and the LH marker sent by newer protocols are stripped from the fields passed to row. An E line ends the request with an ErrorMsg carrying id, as does a request answered without any data:
+// !NO_DATA! (a valid request with nothing to return) matches ErrNoData and !SYNTAX_ERROR! (a malformed request) matches ErrSyntaxError. IQFeed can't tag the answer to a request too malformed to find its id in,
+// so an untagged E line fails the request as well and its connection is discarded rather than searched for a terminator.
+// At most MaxLookups requests are in flight at once, each on its own connection, the others wait for a slot. A request whose connection drops fails straight away with the read error and the connection is discarded, so no caller waits for a terminator that can't arrive.
+// A request not answered within LookupTimeout fails with ErrTimeout and its connection is discarded too, so a late answer can't be read by the next request.
+func (c *IQC) lookup(cmd, id string, row func(items []string) error) error {
+	return c.lookupContext(context.Background(), cmd, id, row)
+}
+
+// lookupContext is lookup ending the request with the context error as soon as ctx is done, whether it is waiting for a slot, for the feed or in row. The connection is discarded rather than drained.
+func (c *IQC) lookupContext(ctx context.Context, cmd, id string, row func(items []string) error) error {
+	s, err := c.acquireLookup(ctx)
+	if err != nil {
+		return c.stopErr(err)
+	}
+	defer c.releaseLookup(s)
+
+	// Connections are reused so the deadline of the previous request is always replaced, by none when there is no timeout.
+	var deadline time.Time
+	if c.LookupTimeout > 0 {
+		deadline = time.Now().Add(c.LookupTimeout)
+	}
+	s.conn.SetDeadline(deadline)
+	defer c.cancelLookup(ctx, s)()
+
+	if _, err := s.conn.Write([]byte(cmd)); err != nil {
+		c.closeLookup(s)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return c.stopErr(fmt.Errorf("iqfeed: lookup write failed: %w", err))
+	}
+
+	rows := 0
+	for {
+		line, err := readLine(s.r)
+		if err != nil {
+			c.closeLookup(s)
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				return fmt.Errorf("iqfeed: lookup not answered within %s: %w", c.LookupTimeout, ErrTimeout)
+			}
+			return c.stopErr(fmt.Errorf("iqfeed: lookup read failed: %w", err))
+		}
+		items := strings.Split(strings.TrimSuffix(string(line), ","), ",")
+		if items[0] == "E" {
+			c.closeLookup(s)
+			return lookupError(cmd, id, strings.Join(items[1:], ","))
+		}
+		if items[0] != id {
+			// Left over from an earlier request that gave up before its terminator.
+			continue
+		}
+		items = items[1:]
+		if len(items) > 0 && items[0] == "LH" {
+			items = items[1:]
+		}
+		if len(items) == 0 {
+			continue
+		}
+		switch items[0] {
+		case "!ENDMSG!":
+			if rows == 0 {
+				return lookupError(cmd, id, "!NO_DATA!")
+			}
+			return nil
+		case "E":
+			msg := ""
+			if len(items) > 1 {
+				msg = items[1]
+			}
+			// Skip ahead to the terminator so the next request starts on a clean stream.
+			c.drainLookup(s, id)
+			return lookupError(cmd, id, msg)
+		}
+		rows++
+		if err := row(items); err != nil {
+			if ctx.Err() != nil {
+				c.closeLookup(s)
+				return ctx.Err()
+			}
+			c.drainLookup(s, id)
+			return err
+		}
+	}
+}
+
+// cancelLookup interrupts the read of the request on s once ctx is done, by moving its deadline to the past. The returned function stops watching ctx and must be called before s is released.
+func (c *IQC) cancelLookup(ctx context.Context, s *lookupStream) func() {
+	done := ctx.Done()
+	if done == nil {
+		return func() {}
+	}
+	finished := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		select {
+		case <-done:
+			s.conn.SetDeadline(time.Unix(1, 0))
+		case <-finished:
+		}
+	}()
+	return func() {
+		close(finished)
+		<-exited
+	}
+}
+
+// lookupError builds the error returned for the lookup request id answered with an E line.
+func lookupError(cmd, id, msg string) error {
+	return &ErrorMsg{Message: msg, Code: 500, Err: classifyError(msg), Command: strings.TrimRight(cmd, "\r\n"), RequestID: id}
+}
+
+// drainLookup discards the rest of the response to id, up to and including its terminator.
+func (c *IQC) drainLookup(s *lookupStream, id string) {
+	for {
+		line, err := readLine(s.r)
+		if err != nil {
+			c.closeLookup(s)
+			return
+		}
+		if strings.HasPrefix(string(line), id+",!ENDMSG!") {
+			return
+		}
+	}
+}
+
+// LookupsInFlight returns the number of lookup requests currently holding one of the MaxLookups slots.
+func (c *IQC) LookupsInFlight() int {
+	return int(atomic.LoadInt32(&c.lookupsInFlight))
+}
+
+// acquireLookup waits for one of the MaxLookups slots, or until ctx is done, and returns an idle lookup connection, dialling a new one if there is none.
+func (c *IQC) acquireLookup(ctx context.Context) (*lookupStream, error) {
+	c.lookupMu.Lock()
+	if c.lookupSlots == nil {
+		n := c.MaxLookups
+		if n <= 0 {
+			n = defaultMaxLookups
+		}
+		c.lookupSlots = make(chan struct{}, n)
+	}
+	slots := c.lookupSlots
+	c.lookupMu.Unlock()
+
+	select {
+	case slots <- struct{}{}:
+	case <-c.stop:
+		return nil, ErrClientStopped
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	atomic.AddInt32(&c.lookupsInFlight, 1)
+	// The select picks at random when a slot is free after Stop, the idle streams are closed by then.
+	if c.stopped() {
+		c.releaseLookup(nil)
+		return nil, ErrClientStopped
+	}
+
+	c.lookupMu.Lock()
+	if n := len(c.lookupIdle); n > 0 {
+		s := c.lookupIdle[n-1]
+		c.lookupIdle = c.lookupIdle[:n-1]
+		c.lookupMu.Unlock()
+		return s, nil
+	}
+	c.lookupMu.Unlock()
+	s, err := c.dialLookup()
+	if err != nil {
+		c.releaseLookup(nil)
+		return nil, err
+	}
+	return s, nil
+}
+
+// releaseLookup gives back the slot taken by acquireLookup, s is kept for the next request unless it was closed.
+func (c *IQC) releaseLookup(s *lookupStream) {
+	if s != nil {
+		c.connMu.RLock()
+		open := c.lookupConns[s]
+		c.connMu.RUnlock()
+		if open {
+			c.lookupMu.Lock()
+			c.lookupIdle = append(c.lookupIdle, s)
+			c.lookupMu.Unlock()
+		}
+	}
+	atomic.AddInt32(&c.lookupsInFlight, -1)
+	<-c.lookupSlots
+}
+
+// dialLookup opens a new connection to the lookup port.
+func (c *IQC) dialLookup() (*lookupStream, error) {
+	addr := c.address(c.LookupAddress, defaultLookupPort)
+	conn, err := c.dialAddr(addr)
+	if err != nil {
+		return nil, fmt.Errorf("iqfeed: could not connect to the IQFeed lookup port at %s: %w", addr, err)
+	}
+	// connMu is shared with the streaming connection so halt can close every connection.
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+	if c.stopped() {
+		conn.Close()
+		return nil, ErrClientStopped
+	}
+	s := &lookupStream{conn: conn, r: c.newReader(conn)}
+	if c.lookupConns == nil {
+		c.lookupConns = make(map[*lookupStream]bool)
+	}
+	c.lookupConns[s] = true
+	return s, nil
+}
+
+// closeLookup drops a lookup connection so it isn't used for another request.
+func (c *IQC) closeLookup(s *lookupStream) {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+	if c.lookupConns[s] {
+		s.conn.Close()
+		delete(c.lookupConns, s)
+	}
+}
+
+// LookupClient returns a client for the lookup port only, with the connection settings of c (host, lookup address, timezone and output location, TLS, timeouts, socket and read buffers, logger, metrics and MaxLookups).
+// Lookups made on c already use their own connections so they never hold up the streaming feed, a lookup client adds a separate pool of MaxLookups slots and lifetime:
+// a long historical pull on it doesn't queue up the lookups made on c and stopping c doesn't fail it. It needs no Start, Stop closes its connections and lookups made afterwards fail with ErrClientStopped.
+func (c *IQC) LookupClient() *IQC {
+	return &IQC{
+		stop:              make(chan struct{}),
+		TimeZone:          c.TimeZone,
+		TimeLoc:           c.TimeLoc,
+		Host:              c.Host,
+		LookupAddress:     c.LookupAddress,
+		MaxLookups:        c.MaxLookups,
+		DialTimeout:       c.DialTimeout,
+		KeepAlive:         c.KeepAlive,
+		TLSConfig:         c.TLSConfig,
+		SocketReadBuffer:  c.SocketReadBuffer,
+		SocketWriteBuffer: c.SocketWriteBuffer,
+		ReadBufferSize:    c.ReadBufferSize,
+		ConfirmTimeout:    c.ConfirmTimeout,
+		LookupTimeout:     c.LookupTimeout,
+		NormalizeToUTC:    c.NormalizeToUTC,
+		OutputLoc:         c.OutputLoc,
+		Logger:            c.Logger,
+		Metrics:           c.Metrics,
+	}
+}
+
+// lookupLoc returns the location lookup timestamps are interpreted in, lookups may be used without Start so TimeZone is resolved here when TimeLoc isn't set.
+func (c *IQC) lookupLoc() *time.Location {
+	if c.TimeLoc != nil {
+		return c.TimeLoc
+	}
+	tz := c.TimeZone
+	if tz == "" {
+		tz = "America/New_York"
+	}
+	if loc, err := time.LoadLocation(tz); err == nil {
+		return loc
+	}
+	return time.UTC
+}
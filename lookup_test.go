@@ -0,0 +1,1026 @@
+package iqfeed
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// lookupServer answers every request on a fake lookup port with the lines returned by respond, the request id is passed separately as the last field of the command.
+func lookupServer(t *testing.T, respond func(cmd []string, id string) []string) *IQC {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("cannot listen: %s", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					cmd := strings.Split(strings.TrimRight(line, "\r\n"), ",")
+					for _, resp := range respond(cmd, cmd[len(cmd)-1]) {
+						conn.Write([]byte(resp + "\r\n"))
+					}
+				}
+			}()
+		}
+	}()
+	return &IQC{LookupAddress: l.Addr().String(), TimeLoc: time.UTC}
+}
+
+func TestRequestTickData(t *testing.T) {
+	var got []string
+	c := lookupServer(t, func(cmd []string, id string) []string {
+		got = cmd
+		return []string{
+			"99,LH,2016-03-14 09:30:00.000001,1.00,1,1,0.99,1.01,1,C,1,,",
+			id + ",LH,2016-03-14 09:30:00.123456,95.02,100,1000,95.01,95.03,42,C,11,3D87,1,20,",
+			id + ",2016-03-14 09:30:01,95.04,200,1200,95.03,95.05,43,E,11,,9,",
+			id + ",!ENDMSG!,",
+		}
+	})
+	ticks, err := c.RequestTickData("AAPL", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(got[:3], ",") != "HTX,AAPL,2" {
+		t.Errorf("command = %q", got)
+	}
+	if len(ticks) != 2 {
+		t.Fatalf("expected 2 ticks, got %d", len(ticks))
+	}
+	want := time.Date(2016, 3, 14, 9, 30, 0, 123456000, time.UTC)
+	if tk := ticks[0]; !tk.TimeStamp.Equal(want) || tk.Last != 95.02 || tk.LastSize != 100 || tk.TickID != 42 || tk.TradeMarketCenter != 11 || tk.TradeConditions != "3D87" {
+		t.Errorf("first tick = %+v", tk)
+	}
+	if tk := ticks[0]; tk.AggressorSide != AggressorBuy || len(tk.Conditions) != 2 || tk.Conditions[1] != 0x87 {
+		t.Errorf("first tick aggressor and conditions = %s %v", tk.AggressorSide, tk.Conditions)
+	}
+	// An aggressor code the client doesn't know is reported as unknown.
+	if tk := ticks[1]; tk.BasisForLast != "E" || tk.TotalVolume != 1200 || tk.AggressorSide != AggressorUnknown || tk.Conditions != nil {
+		t.Errorf("second tick = %+v", tk)
+	}
+}
+
+func TestRecentTicks(t *testing.T) {
+	var got []string
+	c := lookupServer(t, func(cmd []string, id string) []string {
+		got = cmd
+		if cmd[1] == "EMPTY" {
+			return []string{id + ",E,!NO_DATA!,", id + ",!ENDMSG!,"}
+		}
+		return []string{
+			id + ",LH,2016-03-11 15:59:59.500000,94.90,100,3000000,94.89,94.91,7,C,11,,",
+			id + ",LH,2016-03-14 09:30:00,95.02,200,1000,95.01,95.03,1,C,11,,",
+			id + ",!ENDMSG!,",
+		}
+	})
+	ticks, err := c.RecentTicks("AAPL", 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(got[:3], ",") != "HTD,AAPL,5" || got[6] != "1" {
+		t.Errorf("command = %q", got)
+	}
+	if len(ticks) != 2 || ticks[0].TimeStamp.Day() != 11 || ticks[1].Last != 95.02 {
+		t.Errorf("unexpected ticks %+v", ticks)
+	}
+	if ticks, err := c.RecentTicks("EMPTY", 5); err != nil || ticks == nil || len(ticks) != 0 {
+		t.Errorf("expected no ticks without an error, got %v %v", ticks, err)
+	}
+	if _, err := c.RecentTicks("AAPL", 0); err == nil {
+		t.Error("expected an error for 0 days")
+	}
+}
+
+func TestTickCount(t *testing.T) {
+	var got []string
+	c := lookupServer(t, func(cmd []string, id string) []string {
+		got = cmd
+		if cmd[1] == "EMPTY" {
+			return []string{id + ",E,!NO_DATA!,", id + ",!ENDMSG!,"}
+		}
+		return []string{
+			id + ",LH,2016-03-14 09:30:00,95.02,200,1000,95.01,95.03,1,C,11,,",
+			id + ",LH,2016-03-14 09:30:01,95.03,100,1100,95.02,95.04,2,C,11,,",
+			id + ",LH,2016-03-14 09:30:02,95.04,100,1200,95.03,95.05,3,C,11,,",
+			id + ",!ENDMSG!,",
+		}
+	})
+	n, err := c.TickCount("AAPL", time.Date(2016, 3, 14, 15, 0, 0, 0, time.UTC))
+	if err != nil || n != 3 {
+		t.Errorf("got %d ticks, %v", n, err)
+	}
+	if strings.Join(got[:4], ",") != "HTT,AAPL,20160314 000000,20160314 235959" {
+		t.Errorf("command = %q", got)
+	}
+	if n, err := c.TickCount("EMPTY", time.Now()); err != nil || n != 0 {
+		t.Errorf("expected 0 ticks without an error, got %d %v", n, err)
+	}
+}
+
+func TestRequestTickDataByTime(t *testing.T) {
+	var got []string
+	c := lookupServer(t, func(cmd []string, id string) []string {
+		got = cmd
+		if cmd[1] == "EMPTY" {
+			return []string{id + ",E,!NO_DATA!,", id + ",!ENDMSG!,"}
+		}
+		return []string{
+			id + ",LH,2016-03-11 15:59:59,94.90,100,3000000,94.89,94.91,7,C,11,,",
+			id + ",LH,2016-03-14 09:30:00,95.02,200,1000,95.01,95.03,1,C,11,,",
+			id + ",LH,2016-03-14 09:30:01,95.03,100,1100,95.02,95.04,2,C,11,,",
+			id + ",!ENDMSG!,",
+		}
+	})
+	from := time.Date(2016, 3, 11, 15, 0, 0, 0, time.UTC)
+	to := time.Date(2016, 3, 14, 9, 30, 1, 0, time.UTC)
+	ticks, err := c.RequestTickDataByTime("AAPL", from, to, 0)
+	if err != nil || len(ticks) != 3 || ticks[0].TimeStamp.Day() != 11 {
+		t.Fatalf("unexpected ticks %+v, %v", ticks, err)
+	}
+	if strings.Join(got[:8], ",") != "HTT,AAPL,20160311 150000,20160314 093001,,,,1" {
+		t.Errorf("command = %q", got)
+	}
+
+	ticks, err = c.RequestTickDataByTime("AAPL", from, to, 2)
+	if !errors.Is(err, ErrTruncated) || len(ticks) != 2 || ticks[1].TickID != 1 {
+		t.Errorf("expected 2 ticks and ErrTruncated, got %+v, %v", ticks, err)
+	}
+	if got[4] != "3" {
+		t.Errorf("expected one more tick than the maximum to be requested, got %q", got)
+	}
+	if ticks, err := c.RequestTickDataByTime("AAPL", from, to, 3); err != nil || len(ticks) != 3 {
+		t.Errorf("a window holding exactly the maximum isn't truncated, got %d ticks, %v", len(ticks), err)
+	}
+
+	if ticks, err := c.RequestTickDataByTime("EMPTY", from, to, 0); err != nil || ticks == nil || len(ticks) != 0 {
+		t.Errorf("expected no ticks without an error, got %v %v", ticks, err)
+	}
+	if _, err := c.RequestTickDataByTime("AAPL", to, from, 0); err == nil {
+		t.Error("expected an error for a window ending before it starts")
+	}
+}
+
+func TestStreamTickData(t *testing.T) {
+	c := lookupServer(t, func(cmd []string, id string) []string {
+		switch cmd[1] {
+		case "EMPTY":
+			return []string{id + ",E,!NO_DATA!,", id + ",!ENDMSG!,"}
+		case "SLOW":
+			return nil
+		case "MANY":
+			rows := make([]string, 0, 10000)
+			for i := 0; i < 10000; i++ {
+				rows = append(rows, fmt.Sprintf("%s,LH,2016-03-14 09:30:00,95.02,1,%d,95.01,95.03,%d,C,11,,1,", id, i, i))
+			}
+			return append(rows, id+",!ENDMSG!,")
+		}
+		return []string{
+			id + ",LH,2016-03-14 09:30:00,95.02,200,1000,95.01,95.03,1,C,11,,1,",
+			id + ",LH,2016-03-14 09:30:01,95.03,100,1100,95.02,95.04,2,O,11,,2,",
+			id + ",!ENDMSG!,",
+		}
+	})
+	var ids []int
+	ticks, errs := c.StreamTickData(context.Background(), "AAPL", 2)
+	for tk := range ticks {
+		ids = append(ids, tk.TickID)
+	}
+	if err := <-errs; err != nil || !reflect.DeepEqual(ids, []int{1, 2}) {
+		t.Errorf("got ticks %v, %v", ids, err)
+	}
+	if _, errs := c.StreamTickData(context.Background(), "EMPTY", 2); !errors.Is(<-errs, ErrNoData) {
+		t.Error("expected ErrNoData for a request without ticks")
+	}
+	from := time.Date(2016, 3, 14, 9, 30, 0, 0, time.UTC)
+	ticks, errs = c.StreamTickDataByTime(context.Background(), "EMPTY", from, from.Add(time.Minute))
+	if _, ok := <-ticks; ok {
+		t.Error("expected no ticks")
+	}
+	if err := <-errs; err != nil {
+		t.Errorf("an empty window isn't an error, got %v", err)
+	}
+	if _, errs := c.StreamTickDataByTime(context.Background(), "AAPL", from, from.Add(-time.Minute)); <-errs == nil {
+		t.Error("expected an error for a window ending before it starts")
+	}
+
+	// Cancelling part way through discards the rest of the response.
+	ctx, cancel := context.WithCancel(context.Background())
+	ticks, errs = c.StreamTickData(ctx, "MANY", 0)
+	<-ticks
+	<-ticks
+	cancel()
+	for range ticks {
+	}
+	if err := <-errs; !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	// As does cancelling while waiting for the feed.
+	ctx, cancel = context.WithCancel(context.Background())
+	ticks, errs = c.StreamTickData(ctx, "SLOW", 0)
+	time.AfterFunc(10*time.Millisecond, cancel)
+	select {
+	case err := <-errs:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected context.Canceled, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("stream did not end on cancel")
+	}
+	if n := c.LookupsInFlight(); n != 0 {
+		t.Errorf("%d lookups still in flight", n)
+	}
+	if got, err := c.RequestTickData("AAPL", 2); err != nil || len(got) != 2 {
+		t.Errorf("lookup after cancel = %d ticks, %v", len(got), err)
+	}
+}
+
+func TestIntervalStream(t *testing.T) {
+	c := lookupServer(t, func(cmd []string, id string) []string {
+		return []string{
+			id + ",LH,2016-03-14 09:35:00,95.10,94.50,94.80,95.00,100000,5000,120,",
+			id + ",LH,2016-03-14 09:40:00,95.20,94.90,95.00,95.10,104000,4000,80,",
+			id + ",!ENDMSG!,",
+		}
+	})
+	bars, errs := c.Interval("AAPL").Seconds(300).Max(2).Stream(context.Background())
+	var closes []float64
+	for b := range bars {
+		closes = append(closes, b.Close)
+	}
+	if err := <-errs; err != nil || !reflect.DeepEqual(closes, []float64{95.00, 95.10}) {
+		t.Errorf("got closes %v, %v", closes, err)
+	}
+	bars, errs = c.Interval("AAPL").Stream(context.Background())
+	if _, ok := <-bars; ok || <-errs == nil {
+		t.Error("expected the invalid request to fail without bars")
+	}
+}
+
+func TestRequestTickDataErrors(t *testing.T) {
+	c := lookupServer(t, func(cmd []string, id string) []string {
+		if cmd[1] == "EMPTY" {
+			return []string{id + ",!ENDMSG!,"}
+		}
+		return []string{id + ",E,Invalid symbol.,", id + ",!ENDMSG!,"}
+	})
+	if _, err := c.RequestTickData("EMPTY", 10); !errors.Is(err, ErrNoData) {
+		t.Errorf("expected ErrNoData, got %v", err)
+	}
+	_, err := c.RequestTickData("BAD", 10)
+	var e *ErrorMsg
+	if !errors.As(err, &e) || e.Message != "Invalid symbol." {
+		t.Errorf("expected the feed error, got %v", err)
+	}
+	// The connection is still usable afterwards.
+	if _, err := c.RequestTickData("EMPTY", 10); !errors.Is(err, ErrNoData) {
+		t.Errorf("expected ErrNoData after an error, got %v", err)
+	}
+}
+
+func TestLookupErrorTokens(t *testing.T) {
+	c := lookupServer(t, func(cmd []string, id string) []string {
+		switch cmd[1] {
+		case "EMPTY":
+			return []string{id + ",E,!NO_DATA!,", id + ",!ENDMSG!,"}
+		case "BAD":
+			return []string{id + ",E,!SYNTAX_ERROR!,", id + ",!ENDMSG!,"}
+		}
+		// Too malformed for IQFeed to find the request id in.
+		return []string{"E,!SYNTAX_ERROR!,", "!ENDMSG!,"}
+	})
+	for _, tc := range []struct {
+		symbol string
+		want   error
+	}{{"EMPTY", ErrNoData}, {"BAD", ErrSyntaxError}, {"UNTAGGED", ErrSyntaxError}, {"EMPTY", ErrNoData}} {
+		_, err := c.RequestTickData(tc.symbol, 10)
+		var e *ErrorMsg
+		if !errors.Is(err, tc.want) || !errors.As(err, &e) {
+			t.Errorf("%s: expected %v, got %v", tc.symbol, tc.want, err)
+			continue
+		}
+		if e.RequestID == "" || !strings.HasSuffix(e.Command, ","+e.RequestID) || !strings.Contains(e.Error(), "request "+e.RequestID) {
+			t.Errorf("%s: request id missing from %+v", tc.symbol, e)
+		}
+	}
+	if errors.Is(ErrNoData, ErrSyntaxError) {
+		t.Error("no data must be distinguishable from a syntax error")
+	}
+}
+
+func TestRequestIntervalData(t *testing.T) {
+	c := lookupServer(t, func(cmd []string, id string) []string {
+		if cmd[0] != "HIX" || cmd[2] != "300" {
+			return []string{id + ",E,!SYNTAX_ERROR!,", id + ",!ENDMSG!,"}
+		}
+		// Answer slowly so concurrent requests overlap.
+		time.Sleep(5 * time.Millisecond)
+		return []string{
+			id + ",LH,2016-03-14 09:35:00," + cmd[1] + ",94.50,94.80,95.00,100000,5000,120,",
+			id + ",!ENDMSG!,",
+		}
+	})
+	if _, err := c.RequestIntervalData("AAPL", 0, 1); err == nil {
+		t.Error("expected an error for a zero interval")
+	}
+
+	symbols := []string{"1", "2", "3", "4"}
+	errs := make(chan error, len(symbols))
+	for _, s := range symbols {
+		go func(s string) {
+			bars, err := c.RequestIntervalData(s, 300, 1)
+			if err == nil && (len(bars) != 1 || bars[0].High != GetFloatFromStr(s)) {
+				err = errors.New("crossed responses for " + s)
+			}
+			errs <- err
+		}(s)
+	}
+	for range symbols {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+
+	bars, err := c.RequestIntervalData("95", 300, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := bars[0]
+	if !b.TimeStamp.Equal(time.Date(2016, 3, 14, 9, 35, 0, 0, time.UTC)) || b.Low != 94.5 || b.Open != 94.8 || b.Close != 95 || b.TotalVolume != 100000 || b.PeriodVolume != 5000 || b.NumTrades != 120 {
+		t.Errorf("bar = %+v", b)
+	}
+}
+
+func TestMaxLookups(t *testing.T) {
+	var busy, peak int32
+	c := lookupServer(t, func(cmd []string, id string) []string {
+		n := atomic.AddInt32(&busy, 1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		atomic.AddInt32(&busy, -1)
+		return []string{id + ",2016-03-14 09:30:00," + cmd[1] + ",1,1,1,1,1,1,C,1,,", id + ",!ENDMSG!,"}
+	})
+	c.MaxLookups = 2
+
+	const requests = 6
+	errs := make(chan error, requests)
+	for i := 0; i < requests; i++ {
+		go func(s string) {
+			ticks, err := c.RequestTickData(s, 1)
+			if err == nil && (len(ticks) != 1 || ticks[0].Last != GetFloatFromStr(s)) {
+				err = errors.New("lost or crossed response for " + s)
+			}
+			errs <- err
+		}(strconv.Itoa(i + 1))
+	}
+	time.Sleep(10 * time.Millisecond)
+	if n := c.LookupsInFlight(); n != 2 {
+		t.Errorf("expected 2 lookups in flight, got %d", n)
+	}
+	for i := 0; i < requests; i++ {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+	if peak := atomic.LoadInt32(&peak); peak != 2 {
+		t.Errorf("expected at most 2 concurrent requests on the lookup port, got %d", peak)
+	}
+	if n := c.LookupsInFlight(); n != 0 {
+		t.Errorf("expected no lookups in flight, got %d", n)
+	}
+}
+
+func TestLookupConnectionDropped(t *testing.T) {
+	// Read every request, then drop the connection without answering.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("cannot listen: %s", err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				bufio.NewReader(conn).ReadString('\n')
+				time.Sleep(10 * time.Millisecond)
+				conn.Close()
+			}()
+		}
+	}()
+	c := &IQC{LookupAddress: l.Addr().String(), TimeLoc: time.UTC, MaxLookups: 2}
+
+	const requests = 5
+	errs := make(chan error, requests)
+	for i := 0; i < requests; i++ {
+		go func() {
+			_, err := c.RequestTickData("AAPL", 10)
+			errs <- err
+		}()
+	}
+	for i := 0; i < requests; i++ {
+		select {
+		case err := <-errs:
+			if err == nil || errors.Is(err, ErrNoData) {
+				t.Errorf("expected a connection error, got %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("a lookup hung after its connection dropped")
+		}
+	}
+	if n := c.LookupsInFlight(); n != 0 {
+		t.Errorf("expected every slot to be released, %d in flight", n)
+	}
+	c.connMu.RLock()
+	defer c.connMu.RUnlock()
+	if len(c.lookupConns) != 0 {
+		t.Errorf("expected the dropped connections to be discarded, %d left", len(c.lookupConns))
+	}
+}
+
+func TestLookupTimeout(t *testing.T) {
+	var calls int32
+	c := lookupServer(t, func(cmd []string, id string) []string {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			// Never answered in time, the answer only comes with the next request.
+			return nil
+		}
+		return []string{"1,LC,01,REGULAR,Normal Trade", "1,!ENDMSG!,", id + ",LC,02,ACQ,Acquisition", id + ",!ENDMSG!,"}
+	})
+	defer c.Stop()
+	c.LookupTimeout = 50 * time.Millisecond
+	if _, err := c.RequestTradeConditions(); !errors.Is(err, ErrTimeout) {
+		t.Fatalf("expected ErrTimeout, got %v", err)
+	}
+	conds, err := c.RequestTradeConditions()
+	if err != nil || len(conds) != 1 || conds[0].Name != "ACQ" {
+		t.Fatalf("conditions after a timeout = %+v, %v", conds, err)
+	}
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Errorf("%d requests sent, want 2", n)
+	}
+}
+
+func TestIntervalBuilder(t *testing.T) {
+	cmds := make(chan string, 1)
+	c := lookupServer(t, func(cmd []string, _ string) []string {
+		cmds <- strings.Join(cmd, ",")
+		// The request id isn't always the last field, it precedes the interval type for HIT and HID.
+		id := cmd[len(cmd)-1]
+		switch cmd[0] {
+		case "HIT":
+			id = cmd[9]
+		case "HID":
+			id = cmd[8]
+		case "HIX":
+			id = cmd[5]
+		}
+		return []string{id + ",LH,2016-03-14 09:31:00,95.10,94.90,95.00,95.05,1000,100,5,", id + ",!ENDMSG!,"}
+	})
+	c.TimeLoc = time.UTC
+	from := time.Date(2016, 3, 14, 9, 30, 0, 0, time.UTC)
+	to := time.Date(2016, 3, 15, 16, 0, 0, 0, time.UTC)
+
+	for _, tc := range []struct {
+		req  *IntervalRequest
+		want string
+	}{
+		{c.Interval("AAPL").Seconds(60).Max(10), "HIX,AAPL,60,10,,%s"},
+		{c.Interval("AAPL").Seconds(60).From(from).To(to).Max(1000), "HIT,AAPL,60,20160314 093000,20160315 160000,1000,,,,%s"},
+		{c.Interval("AAPL").Ticks(100).Days(5).Between(9*time.Hour+30*time.Minute, 16*time.Hour).OldestFirst(), "HID,AAPL,100,5,,093000,160000,1,%s,,t,0"},
+		{c.Interval("AAPL").Volume(5000).From(from).LabelAtStart(), "HIT,AAPL,5000,20160314 093000,,,,,,%s,,v,1"},
+	} {
+		bars, err := tc.req.Do()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := <-cmds
+		id := strconv.FormatInt(atomic.LoadInt64(&c.previousRequestId), 10)
+		if want := fmt.Sprintf(tc.want, id); got != want {
+			t.Errorf("command = %q, want %q", got, want)
+		}
+		if len(bars) != 1 || bars[0].Close != 95.05 || bars[0].NumTrades != 5 {
+			t.Errorf("unexpected bars %+v", bars)
+		}
+	}
+
+	for _, req := range []*IntervalRequest{
+		c.Interval("AAPL"),
+		c.Interval("AAPL").Seconds(60).Days(1).From(from),
+		c.Interval("AAPL").Seconds(60).From(to).To(from),
+		c.Interval("AAPL").Seconds(60).Between(time.Hour, 2*time.Hour),
+		c.Interval("AAPL").Seconds(60).Days(1).Between(2*time.Hour, time.Hour),
+		c.Interval("AAPL").Seconds(60).Max(-1),
+	} {
+		if _, err := req.Do(); err == nil {
+			t.Errorf("expected %+v to be rejected", *req)
+		}
+	}
+	select {
+	case cmd := <-cmds:
+		t.Errorf("an invalid request was sent: %q", cmd)
+	default:
+	}
+}
+
+func TestRequestDailyData(t *testing.T) {
+	var cmds []string
+	c := lookupServer(t, func(cmd []string, id string) []string {
+		cmds = append(cmds, strings.Join(cmd[:len(cmd)-1], ","))
+		return []string{
+			id + ",LH,2016-03-14,95.50,94.00,94.80,95.00,3000000,12,",
+			id + ",2016-03-11 00:00:00,94.50,93.00,93.80,94.00,2500000,0,",
+			id + ",!ENDMSG!,",
+		}
+	})
+	bars, err := c.RequestDailyData("AAPL", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bars) != 2 {
+		t.Fatalf("expected 2 bars, got %d", len(bars))
+	}
+	if b := bars[0]; !b.Date.Equal(time.Date(2016, 3, 14, 0, 0, 0, 0, time.UTC)) || b.High != 95.5 || b.Close != 95 || b.PeriodVolume != 3000000 || b.OpenInterest != 12 {
+		t.Errorf("first bar = %+v", b)
+	}
+	if b := bars[1]; !b.Date.Equal(time.Date(2016, 3, 11, 0, 0, 0, 0, time.UTC)) || b.Low != 93 {
+		t.Errorf("second bar = %+v", b)
+	}
+
+	c.RequestWeeklyData("AAPL", 3)
+	c.RequestMonthlyData("AAPL", 4)
+	c.RequestDailyDataBetween("AAPL", time.Date(2016, 1, 4, 0, 0, 0, 0, time.UTC), time.Date(2016, 3, 14, 0, 0, 0, 0, time.UTC))
+	want := []string{"HDX,AAPL,2,", "HWX,AAPL,3,", "HMX,AAPL,4,", "HDT,AAPL,20160104,20160314,,"}
+	if !reflect.DeepEqual(cmds, want) {
+		t.Errorf("commands = %q, want %q", cmds, want)
+	}
+
+	// The dates are taken in TimeLoc, 03:00 UTC is still the previous day in New York.
+	c.TimeLoc = time.FixedZone("EST", -5*3600)
+	cmds = nil
+	c.RequestDailyDataBetween("AAPL", time.Date(2016, 1, 5, 3, 0, 0, 0, time.UTC), time.Date(2016, 3, 15, 3, 0, 0, 0, time.UTC))
+	if want := []string{"HDT,AAPL,20160104,20160314,,"}; !reflect.DeepEqual(cmds, want) {
+		t.Errorf("commands = %q, want %q", cmds, want)
+	}
+}
+
+func TestHistoryNormalizeToUTC(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("America/New_York not available")
+	}
+	c := lookupServer(t, func(cmd []string, id string) []string {
+		switch cmd[0] {
+		case "HTX":
+			return []string{id + ",LH,2016-03-14 09:30:00,95.02,100,1000,95.01,95.03,42,C,11,,", id + ",!ENDMSG!,"}
+		case "HIX":
+			return []string{id + ",LH,2016-03-14 09:35:00,95.10,94.50,94.80,95.00,100000,5000,120,", id + ",!ENDMSG!,"}
+		}
+		return []string{id + ",LH,2016-03-14,95.50,94.00,94.80,95.00,3000000,12,", id + ",!ENDMSG!,"}
+	})
+	c.TimeLoc = ny
+	c.NormalizeToUTC = true
+
+	ticks, err := c.RequestTickData("AAPL", 1)
+	if err != nil || len(ticks) != 1 {
+		t.Fatalf("unexpected ticks %+v, %v", ticks, err)
+	}
+	// The day after the DST change New York is 4 hours behind UTC.
+	if ts := ticks[0].TimeStamp; ts.Location() != time.UTC || !ts.Equal(time.Date(2016, 3, 14, 13, 30, 0, 0, time.UTC)) {
+		t.Errorf("tick time = %s", ts)
+	}
+	bars, err := c.RequestIntervalData("AAPL", 300, 1)
+	if err != nil || len(bars) != 1 {
+		t.Fatalf("unexpected bars %+v, %v", bars, err)
+	}
+	if ts := bars[0].TimeStamp; ts.Location() != time.UTC || !ts.Equal(time.Date(2016, 3, 14, 13, 35, 0, 0, time.UTC)) {
+		t.Errorf("bar time = %s", ts)
+	}
+	days, err := c.RequestDailyData("AAPL", 1)
+	if err != nil || len(days) != 1 {
+		t.Fatalf("unexpected daily bars %+v, %v", days, err)
+	}
+	if d := days[0].Date; d.Location() != time.UTC || !d.Equal(time.Date(2016, 3, 14, 4, 0, 0, 0, time.UTC)) {
+		t.Errorf("daily bar date = %s", d)
+	}
+}
+
+func TestHistoryOutputLoc(t *testing.T) {
+	cet := time.FixedZone("CET", 3600)
+	c := lookupServer(t, func(cmd []string, id string) []string {
+		switch cmd[0] {
+		case "HTX":
+			return []string{id + ",LH,2016-03-14 09:30:00,95.02,100,1000,95.01,95.03,42,C,11,,", id + ",!ENDMSG!,"}
+		case "HIX":
+			return []string{id + ",LH,2016-03-14 09:35:00,95.10,94.50,94.80,95.00,100000,5000,120,", id + ",!ENDMSG!,"}
+		}
+		return []string{id + ",LH,2016-03-14,95.50,94.00,94.80,95.00,3000000,12,", id + ",!ENDMSG!,"}
+	})
+	c.OutputLoc = cet
+
+	ticks, errs := c.StreamTickData(context.Background(), "AAPL", 1)
+	for tk := range ticks {
+		if tk.TimeStamp.Location() != cet || !tk.TimeStamp.Equal(time.Date(2016, 3, 14, 9, 30, 0, 0, time.UTC)) {
+			t.Errorf("streamed tick time = %s", tk.TimeStamp)
+		}
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	bars, errs := c.Interval("AAPL").Seconds(300).Max(1).Stream(context.Background())
+	for b := range bars {
+		if b.TimeStamp.Location() != cet || !b.TimeStamp.Equal(time.Date(2016, 3, 14, 9, 35, 0, 0, time.UTC)) {
+			t.Errorf("streamed bar time = %s", b.TimeStamp)
+		}
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	days, err := c.RequestDailyDataBetween("AAPL", time.Date(2016, 3, 14, 0, 0, 0, 0, time.UTC), time.Date(2016, 3, 14, 0, 0, 0, 0, time.UTC))
+	if err != nil || len(days) != 1 || days[0].Date.Location() != cet {
+		t.Errorf("unexpected daily bars %+v, %v", days, err)
+	}
+}
+
+func TestNewsLookups(t *testing.T) {
+	var cmds []string
+	c := lookupServer(t, func(cmd []string, id string) []string {
+		cmds = append(cmds, strings.Join(cmd[:len(cmd)-1], ","))
+		if cmd[0] == "NSY" {
+			return []string{id + ",Apple reported earnings today,", id + ",beating estimates.", id + ",!ENDMSG!,"}
+		}
+		return []string{
+			id + ",N,DTN,22110963127,AAPL:MSFT:,20160314093000,Apple, Microsoft rally",
+			id + ",!ENDMSG!,",
+		}
+	})
+	hl, err := c.RequestNewsHeadlines([]string{"DTN", "CPR"}, []string{"AAPL", "MSFT"}, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hl) != 1 {
+		t.Fatalf("expected 1 headline, got %d", len(hl))
+	}
+	h := hl[0]
+	if h.DistributorCode != "DTN" || h.StoryID != "22110963127" || !reflect.DeepEqual(h.SymbolList, []string{"AAPL", "MSFT"}) ||
+		!h.DateTime.Equal(time.Date(2016, 3, 14, 9, 30, 0, 0, time.UTC)) || h.Headline != "Apple, Microsoft rally" {
+		t.Errorf("headline = %+v", h)
+	}
+
+	story, err := c.GetNewsStory(h.StoryID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if story != "Apple reported earnings today\nbeating estimates." {
+		t.Errorf("story = %q", story)
+	}
+	want := []string{"NHL,DTN:CPR,AAPL:MSFT,t,5,", "NSY,22110963127,t,"}
+	if !reflect.DeepEqual(cmds, want) {
+		t.Errorf("commands = %q, want %q", cmds, want)
+	}
+}
+
+func TestRequestTradeConditions(t *testing.T) {
+	c := lookupServer(t, func(cmd []string, id string) []string {
+		return []string{id + ",LC,01,REGULAR,Normal Trade", id + ",3D,INTRADETAIL,Intraday Trade Detail, late", id + ",!ENDMSG!,"}
+	})
+	conds, err := c.RequestTradeConditions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []TradeConditionInfo{
+		{Condition: TradeConditionRegular, Name: "REGULAR", Description: "Normal Trade"},
+		{Condition: 0x3D, Name: "INTRADETAIL", Description: "Intraday Trade Detail, late"},
+	}
+	if !reflect.DeepEqual(conds, want) {
+		t.Errorf("conditions = %+v", conds)
+	}
+}
+
+func TestRequestListedMarkets(t *testing.T) {
+	var got []string
+	c := lookupServer(t, func(cmd []string, id string) []string {
+		got = cmd
+		return []string{id + ",LM,7,NYSE,New York Stock Exchange,7,NYSE", id + ",11,ARCA,NYSE Archipelago,11,ARCA", id + ",!ENDMSG!,"}
+	})
+	markets, err := c.RequestListedMarkets()
+	if err != nil || len(markets) != 2 || got[0] != "SLM" {
+		t.Fatalf("markets = %+v, %v, sent %q", markets, err, got)
+	}
+	if m := markets[0]; m.ID != 7 || m.ShortName != "NYSE" || m.LongName != "New York Stock Exchange" || m.GroupID != 7 || m.GroupName != "NYSE" {
+		t.Errorf("market = %+v", m)
+	}
+	c.Regional = make(chan *RegionalMsg, 2)
+	c.processRegUpdMsg([]byte("AAPL,,95.01,300,09:30:00,95.04,400,09:30:01,14,4,11"))
+	c.processRegUpdMsg([]byte("AAPL,,95.01,300,09:30:00,95.04,400,09:30:01,14,4,99"))
+	if r := <-c.Regional; r.MarketCenter != 11 || r.MarketCenterName != "ARCA" {
+		t.Errorf("regional market center %d %q", r.MarketCenter, r.MarketCenterName)
+	}
+	if r := <-c.Regional; r.MarketCenterName != "" {
+		t.Errorf("unknown market center named %q", r.MarketCenterName)
+	}
+}
+
+func TestLoadListedMarkets(t *testing.T) {
+	l := lookupServer(t, func(cmd []string, id string) []string {
+		if cmd[0] == "SBF" {
+			return []string{id + ",AAPL,5,1,APPLE INC", id + ",!ENDMSG!,"}
+		}
+		return []string{id + ",LM,5,NASDAQ,Nasdaq Stock Market,5,NASDAQ", id + ",!ENDMSG!,"}
+	})
+	feed := listenFeed(t)
+	defer feed.Close()
+	c := &IQC{TimeZone: "UTC", LookupAddress: l.LookupAddress, LoadListedMarkets: true}
+	if _, ok := c.MarketName(5); ok {
+		t.Error("market named before the table was loaded")
+	}
+	if _, err := c.Start(feed.Addr().String(), 16); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	if name, ok := c.MarketName(5); !ok || name != "NASDAQ" {
+		t.Errorf("MarketName(5) = %q, %v", name, ok)
+	}
+	matches, err := c.SearchSymbols("s", "AAPL", "", "")
+	if err != nil || len(matches) != 1 || matches[0].Market != "NASDAQ" {
+		t.Errorf("matches = %+v, %v", matches, err)
+	}
+}
+
+func TestRequestSecurityTypes(t *testing.T) {
+	c := lookupServer(t, func(cmd []string, id string) []string {
+		if cmd[0] != "SST" {
+			return []string{id + ",E,!SYNTAX_ERROR!,", id + ",!ENDMSG!,"}
+		}
+		return []string{id + ",LS,1,EQUITY,Equity", id + ",LS,42,CRYPTO,Crypto Currency", id + ",!ENDMSG!,"}
+	})
+	if name, ok := c.SecurityTypeName(8); !ok || name != "FUTURE" {
+		t.Errorf("built in name = %q, %v", name, ok)
+	}
+	if _, ok := c.SecurityTypeName(42); ok {
+		t.Error("type 42 named before the table was loaded")
+	}
+	types, err := c.RequestSecurityTypes()
+	if err != nil || len(types) != 2 || types[1] != (SecurityType{ID: 42, ShortName: "CRYPTO", LongName: "Crypto Currency"}) {
+		t.Fatalf("types = %+v, %v", types, err)
+	}
+	if name, ok := c.SecurityTypeName(42); !ok || name != "CRYPTO" {
+		t.Errorf("SecurityTypeName(42) = %q, %v", name, ok)
+	}
+}
+
+func TestHaltConditions(t *testing.T) {
+	c := lookupServer(t, func(cmd []string, id string) []string {
+		return []string{id + ",LC,01,REGULAR,Normal Trade", id + ",2A,HALT,Trading Halted", id + ",2B,RESUME,Halt lifted", id + ",!ENDMSG!,"}
+	})
+	conds, err := c.LoadHaltConditions()
+	if err != nil || !reflect.DeepEqual(conds, []TradeCondition{0x2A, 0x2B}) {
+		t.Fatalf("halt conditions = %v, %v", conds, err)
+	}
+	c.TimeLoc = time.UTC
+	c.setDynFields([]string{"Symbol", "Most Recent Trade Conditions", "Market Open"})
+	u := c.parseUpdate([]string{"@ESM16", "012A", "1"}, c.dynFields(), 1)
+	if !u.Halted || !u.MarketOpen {
+		t.Errorf("halted %v market open %v", u.Halted, u.MarketOpen)
+	}
+	if u := c.parseUpdate([]string{"@ESM16", "01", "0"}, c.dynFields(), 1); u.Halted || u.MarketOpen {
+		t.Errorf("halted %v market open %v", u.Halted, u.MarketOpen)
+	}
+	c.SetHaltConditions()
+	if u := c.parseUpdate([]string{"@ESM16", "2A", ""}, c.dynFields(), 1); u.Halted {
+		t.Error("expected no halt once the conditions are cleared")
+	}
+}
+
+func TestLookupClient(t *testing.T) {
+	c := lookupServer(t, func(cmd []string, id string) []string {
+		return []string{id + ",LC,01,REGULAR,Normal Trade", id + ",!ENDMSG!,"}
+	})
+	c.MaxLookups = 3
+	c.LookupTimeout = time.Second
+	c.NormalizeToUTC = true
+	c.OutputLoc = time.FixedZone("CET", 3600)
+	lc := c.LookupClient()
+	defer lc.Stop()
+	if lc.LookupAddress != c.LookupAddress || lc.MaxLookups != 3 || lc.TimeLoc != c.TimeLoc {
+		t.Fatalf("lookup client settings = %q %d %v", lc.LookupAddress, lc.MaxLookups, lc.TimeLoc)
+	}
+	if lc.LookupTimeout != time.Second || !lc.NormalizeToUTC || lc.OutputLoc != c.OutputLoc {
+		t.Errorf("lookup client timeout and output = %s %v %v", lc.LookupTimeout, lc.NormalizeToUTC, lc.OutputLoc)
+	}
+	c.stop = make(chan struct{})
+	close(c.stop)
+	if _, err := c.RequestTradeConditions(); !errors.Is(err, ErrClientStopped) {
+		t.Fatalf("stopped client lookup err = %v", err)
+	}
+	if conds, err := lc.RequestTradeConditions(); err != nil || len(conds) != 1 {
+		t.Fatalf("lookup client conditions = %v, %v", conds, err)
+	}
+
+	// A stopped lookup client doesn't dial again.
+	lc.Stop()
+	for i := 0; i < 10; i++ {
+		if _, err := lc.RequestTradeConditions(); !errors.Is(err, ErrClientStopped) {
+			t.Fatalf("lookup after Stop err = %v", err)
+		}
+	}
+}
+
+func TestAddress(t *testing.T) {
+	c := &IQC{}
+	for _, tc := range []struct{ host, addr, port, want string }{
+		{"", "", "9100", "localhost:9100"},
+		{"10.0.0.5", "", "9100", "10.0.0.5:9100"},
+		{"10.0.0.5", ":9101", "9100", "10.0.0.5:9101"},
+		{"10.0.0.5", "9101", "9100", "10.0.0.5:9101"},
+		{"10.0.0.5", "feed:9101", "9100", "feed:9101"},
+		{"::1", "", "5009", "[::1]:5009"},
+	} {
+		c.Host = tc.host
+		if got := c.address(tc.addr, tc.port); got != tc.want {
+			t.Errorf("host %q: address(%q, %q) = %q, want %q", tc.host, tc.addr, tc.port, got, tc.want)
+		}
+	}
+
+	// A port alone is dialled on Host.
+	l := lookupServer(t, func(cmd []string, id string) []string {
+		return []string{id + ",LC,01,REGULAR,Normal Trade", id + ",!ENDMSG!,"}
+	})
+	host, port, _ := net.SplitHostPort(l.LookupAddress)
+	c = &IQC{Host: host, LookupAddress: ":" + port, TimeLoc: time.UTC}
+	defer c.Stop()
+	if conds, err := c.RequestTradeConditions(); err != nil || len(conds) != 1 {
+		t.Fatalf("conditions = %v, %v", conds, err)
+	}
+}
+
+func TestSearchSymbols(t *testing.T) {
+	var cmds []string
+	c := lookupServer(t, func(cmd []string, id string) []string {
+		cmds = append(cmds, strings.Join(cmd[:len(cmd)-1], ","))
+		switch cmd[0] {
+		case "SBF":
+			if cmd[2] == "NOPE" {
+				return []string{id + ",E,!NO_DATA!,", id + ",!ENDMSG!,"}
+			}
+			return []string{id + ",AAPL,5,1,APPLE INC,", id + ",!ENDMSG!,"}
+		case "SBS":
+			return []string{id + ",LH,AAPL,5,1,3571,APPLE, INC.", id + ",!ENDMSG!,"}
+		}
+		return []string{id + ",AAPL,5,1,334111,APPLE INC", id + ",!ENDMSG!,"}
+	})
+	m, err := c.SearchSymbols("s", "AAPL", "t", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(m, []SymbolMatch{{Symbol: "AAPL", MarketID: 5, SecurityTypeID: 1, Description: "APPLE INC"}}) {
+		t.Errorf("SBF matches = %+v", m)
+	}
+	if _, err := c.SearchSymbols("s", "NOPE", "", ""); !errors.Is(err, ErrNoData) {
+		t.Errorf("expected ErrNoData, got %v", err)
+	}
+	if m, err := c.SearchBySIC("357"); err != nil || len(m) != 1 || m[0].SIC != 3571 || m[0].Description != "APPLE, INC." {
+		t.Errorf("SBS matches = %+v, %v", m, err)
+	}
+	if m, err := c.SearchByNAICS("3341"); err != nil || len(m) != 1 || m[0].NAICS != 334111 || m[0].SIC != 0 {
+		t.Errorf("SBN matches = %+v, %v", m, err)
+	}
+	want := []string{"SBF,s,AAPL,t,1", "SBF,s,NOPE,,", "SBS,357", "SBN,3341"}
+	if !reflect.DeepEqual(cmds, want) {
+		t.Errorf("commands = %q, want %q", cmds, want)
+	}
+}
+
+func TestRequestEquityOptionChain(t *testing.T) {
+	var cmds []string
+	c := lookupServer(t, func(cmd []string, id string) []string {
+		cmds = append(cmds, strings.Join(cmd[:len(cmd)-1], ","))
+		return []string{id + ",AAPL1618C100,AAPL1718C100,:,AAPL1618O100,", id + ",!ENDMSG!,"}
+	})
+	syms, err := c.RequestEquityOptionChain("AAPL", "pc", "March", 2016, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(syms, []string{"AAPL1618C100", "AAPL1618O100"}) {
+		t.Errorf("symbols = %q", syms)
+	}
+	if syms, _ := c.RequestEquityOptionChain("AAPL", "c", "", 0, 2); len(syms) != 3 {
+		t.Errorf("unfiltered symbols = %q", syms)
+	}
+	if _, err := c.RequestEquityOptionChain("AAPL", "c", "Smarch", 2016, 0); err == nil {
+		t.Error("expected an error for an invalid month")
+	}
+	want := []string{"CEO,AAPL,pc,CO,,0,,,", "CEO,AAPL,c,,2,0,,,"}
+	if !reflect.DeepEqual(cmds, want) {
+		t.Errorf("commands = %q, want %q", cmds, want)
+	}
+}
+
+func TestRequestFutureChains(t *testing.T) {
+	var cmds []string
+	c := lookupServer(t, func(cmd []string, id string) []string {
+		cmds = append(cmds, strings.Join(cmd[:len(cmd)-1], ","))
+		if cmd[0] == "CFU" {
+			return []string{id + ",@ESH16,@ESM16,", id + ",!ENDMSG!,"}
+		}
+		return []string{id + ",@ESH16C2000,:,@ESH16P2000,", id + ",!ENDMSG!,"}
+	})
+	syms, err := c.RequestFutureChain("@ES", "HM", "6", 0)
+	if err != nil || !reflect.DeepEqual(syms, []string{"@ESH16", "@ESM16"}) {
+		t.Errorf("future chain = %q, %v", syms, err)
+	}
+	syms, err = c.RequestFutureOptionChain("@ES", "pc", "", "", 2)
+	if err != nil || !reflect.DeepEqual(syms, []string{"@ESH16C2000", "@ESH16P2000"}) {
+		t.Errorf("future option chain = %q, %v", syms, err)
+	}
+	want := []string{"CFU,@ES,HM,6,", "CFO,@ES,pc,,,2"}
+	if !reflect.DeepEqual(cmds, want) {
+		t.Errorf("commands = %q, want %q", cmds, want)
+	}
+}
+
+func TestStartContextCancel(t *testing.T) {
+	feed := listenFeed(t)
+	defer feed.Close()
+	go func() {
+		for {
+			conn, err := feed.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	// The lookup port never answers so the request is still in flight when the context is cancelled.
+	received := make(chan struct{}, 1)
+	l := lookupServer(t, func(cmd []string, id string) []string {
+		received <- struct{}{}
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &IQC{TimeZone: "UTC", LookupAddress: l.LookupAddress}
+	if _, err := c.StartContext(ctx, feed.Addr().String(), 16); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	errs := make(chan error, 1)
+	go func() {
+		_, err := c.RequestTickData("AAPL", 10)
+		errs <- err
+	}()
+	<-received
+	cancel()
+
+	select {
+	case err := <-errs:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected context.Canceled, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("lookup did not return on cancel")
+	}
+	select {
+	case <-c.done:
+	case <-time.After(time.Second):
+		t.Fatal("read goroutine did not exit on cancel")
+	}
+	if _, err := c.RequestTickData("AAPL", 10); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled for a lookup after cancel, got %v", err)
+	}
+	// The output channels are closed as by Stop.
+	select {
+	case _, ok := <-c.Updates:
+		if ok {
+			t.Error("unexpected update after cancel")
+		}
+	case <-time.After(time.Second):
+		t.Error("Updates was not closed on cancel")
+	}
+}
@@ -0,0 +1,111 @@
+package iqfeed
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// ListedMarket is an entry of the listed markets table, it names the market IDs sent as the market center of regional quotes, the listed market of fundamentals and so on.
+// See: Listed Markets http://www.iqfeed.net/dev/api/docs/ListedMarkets.cfm.
+type ListedMarket struct {
+	ID        int    // The listed market ID.
+	ShortName string // Short name of the market (ex: NYSE, NASDAQ, CME).
+	LongName  string // Full name of the market.
+	GroupID   int    // The ID of the market group the market belongs to.
+	GroupName string // Short name of the market group.
+}
+
+// RequestListedMarkets returns the listed markets table from the lookup port (the SLM command).
+// The table is kept for MarketName, so regional messages received and symbol searches made afterwards have their market names set.
+func (c *IQC) RequestListedMarkets() ([]ListedMarket, error) {
+	id := c.incr()
+	var markets []ListedMarket
+	err := c.lookup(fmt.Sprintf("SLM,%s\r\n", id), id, func(items []string) error {
+		n, err := strconv.Atoi(items[0])
+		if err != nil && len(items) > 1 {
+			// Newer protocols start the row with a two letter message marker.
+			items = items[1:]
+			n, err = strconv.Atoi(items[0])
+		}
+		if err != nil {
+			return nil
+		}
+		for len(items) < 5 {
+			items = append(items, "")
+		}
+		markets = append(markets, ListedMarket{ID: n, ShortName: items[1], LongName: items[2], GroupID: GetIntFromStr(items[3]), GroupName: items[4]})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	table := make(map[int]ListedMarket, len(markets))
+	for _, m := range markets {
+		table[m.ID] = m
+	}
+	c.listedMarkets.Store(table)
+	return markets, nil
+}
+
+// MarketName returns the short name of the listed market id (ex: NYSE), false when it isn't in the table or RequestListedMarkets hasn't been called yet (see LoadListedMarkets).
+func (c *IQC) MarketName(id int) (string, bool) {
+	m, ok := c.listedMarket(id)
+	return m.ShortName, ok
+}
+
+// listedMarket returns the entry of the listed markets table kept by RequestListedMarkets for id.
+func (c *IQC) listedMarket(id int) (ListedMarket, bool) {
+	table, _ := c.listedMarkets.Load().(map[int]ListedMarket)
+	m, ok := table[id]
+	return m, ok
+}
+
+// SecurityType is an entry of the security types table, it names the security type IDs sent in fundamentals and symbol searches.
+// See: Security Types http://www.iqfeed.net/dev/api/docs/SecurityTypes.cfm.
+type SecurityType struct {
+	ID        int    // The security type ID, see AssetClass.
+	ShortName string // Short name of the type (ex: EQUITY, FUTURE).
+	LongName  string // Full name of the type.
+}
+
+// RequestSecurityTypes returns the security types table from the lookup port (the SST command), the table is kept for SecurityTypeName.
+func (c *IQC) RequestSecurityTypes() ([]SecurityType, error) {
+	id := c.incr()
+	var types []SecurityType
+	err := c.lookup(fmt.Sprintf("SST,%s\r\n", id), id, func(items []string) error {
+		n, err := strconv.Atoi(items[0])
+		if err != nil && len(items) > 1 {
+			// Newer protocols start the row with a two letter message marker.
+			items = items[1:]
+			n, err = strconv.Atoi(items[0])
+		}
+		if err != nil {
+			return nil
+		}
+		for len(items) < 3 {
+			items = append(items, "")
+		}
+		types = append(types, SecurityType{ID: n, ShortName: items[1], LongName: items[2]})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	table := make(map[int]SecurityType, len(types))
+	for _, t := range types {
+		table[t.ID] = t
+	}
+	c.securityTypes.Store(table)
+	return types, nil
+}
+
+// SecurityTypeName returns the short name of the security type id (ex: EQUITY), from the table kept by RequestSecurityTypes or else the names known to AssetClass.
+// It returns false for an id found in neither.
+func (c *IQC) SecurityTypeName(id int) (string, bool) {
+	table, _ := c.securityTypes.Load().(map[int]SecurityType)
+	if t, ok := table[id]; ok {
+		return t.ShortName, true
+	}
+	n, ok := assetClassNames[AssetClass(id)]
+	return n, ok
+}
@@ -0,0 +1,51 @@
+package iqfeed
+
+import "time"
+
+// Message is implemented by every message the client delivers, it is the element type of the Messages channel. Use a type switch to tell the messages apart.
+type Message interface {
+	iqfeedMessage()
+}
+
+// Received is embedded in every message, it records when the line the message was parsed from was read so latency can be measured against the feed's own timestamps,
+// and with KeepRaw the line itself so a suspect value can be checked against what was actually sent.
+type Received struct {
+	ReceivedAt time.Time // Wall clock time the line was read, before it was parsed and dispatched. Zero for messages raised by the client itself.
+	Line       string    // The line as read, without its terminator. Only kept when KeepRaw is set.
+}
+
+// stamp returns the Received of the messages parsed from the line d read just now.
+func (c *IQC) stamp(d []byte) Received {
+	r := Received{ReceivedAt: time.Now()}
+	if c.KeepRaw {
+		r.Line = string(d)
+	}
+	return r
+}
+
+func (*SystemMessage) iqfeedMessage()  {}
+func (*NewsMsg) iqfeedMessage()        {}
+func (*ErrorMsg) iqfeedMessage()       {}
+func (*FundamentalMsg) iqfeedMessage() {}
+func (*RegionalMsg) iqfeedMessage()    {}
+func (*TimeMsg) iqfeedMessage()        {}
+func (*UpdSummaryMsg) iqfeedMessage()  {}
+func (*Quote) iqfeedMessage()          {}
+func (*L2Msg) iqfeedMessage()          {}
+func (*ClientStats) iqfeedMessage()    {}
+func (*IntervalBar) iqfeedMessage()    {}
+
+// divert is called before every send on a typed output channel with the message m about to be sent, it reports whether m must not be sent there.
+// With UnifiedMessages set m is sent on Messages instead, otherwise the channel's DropPolicy may drop it when the channel is full.
+func (c *IQC) divert(channel string, ch interface{}, m Message) bool {
+	if !c.UnifiedMessages {
+		return c.overflow(channel, ch)
+	}
+	if !c.overflow("Messages", c.Messages) {
+		select {
+		case c.Messages <- m:
+		case <-c.stop:
+		}
+	}
+	return true
+}
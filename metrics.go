@@ -0,0 +1,92 @@
+package iqfeed
+
+import "reflect"
+
+// Metrics receives counters about the feed for monitoring, set IQC.Metrics to export them (ex: to Prometheus). The methods are called from the read goroutines and must not block.
+type Metrics interface {
+	// MessageReceived is called for every line read from the Level 1 connection with its message type (the first letter, ex: Q) and size in bytes including the line terminator.
+	MessageReceived(msgType byte, bytes int)
+	// ParseError is called for lines that couldn't be parsed, such as an unknown message type.
+	ParseError(msgType byte, line []byte)
+	// ChannelFull is called when a message has to wait because the named output channel (ex: "Updates") is full and its DropPolicy is PolicyBlock. The read goroutine is blocked until the consumer catches up, so nothing else is processed meanwhile.
+	ChannelFull(channel string)
+	// Dropped is called when a message is discarded instead: when the channel is full under a drop policy (see DropPolicy), for connection events when Connection is full, and for updates received before the field names once too many are held back ("Pending").
+	Dropped(channel string)
+}
+
+// DropPolicy selects what happens to a message when its output channel is full.
+type DropPolicy int
+
+const (
+	// PolicyBlock waits for the consumer, stalling the read goroutine (and everything else it delivers) until there is room. This is the default.
+	PolicyBlock DropPolicy = iota
+	// PolicyDropNewest discards the message that doesn't fit.
+	PolicyDropNewest
+	// PolicyDropOldest discards the oldest message waiting in the channel to make room.
+	PolicyDropOldest
+)
+
+// dropPolicy returns the policy of the named channel, from DropPolicies or else DropPolicy.
+func (c *IQC) dropPolicy(channel string) DropPolicy {
+	if p, ok := c.DropPolicies[channel]; ok {
+		return p
+	}
+	return c.DropPolicy
+}
+
+// NopMetrics discards every metric, it is used when Metrics isn't set.
+type NopMetrics struct{}
+
+func (NopMetrics) MessageReceived(msgType byte, bytes int) {}
+func (NopMetrics) ParseError(msgType byte, line []byte)    {}
+func (NopMetrics) ChannelFull(channel string)              {}
+func (NopMetrics) Dropped(channel string)                  {}
+
+// metrics returns the configured Metrics or NopMetrics.
+func (c *IQC) metrics() Metrics {
+	if c.Metrics != nil {
+		return c.Metrics
+	}
+	return NopMetrics{}
+}
+
+// overflow is called before every send on an output channel, it applies the channel's DropPolicy when the channel is full and reports whether the message has to be dropped.
+// Unbuffered channels are never considered full, there is no way to tell whether a consumer is waiting.
+func (c *IQC) overflow(channel string, ch interface{}) bool {
+	v := reflect.ValueOf(ch)
+	if v.Cap() == 0 || v.Len() < v.Cap() {
+		return false
+	}
+	switch c.dropPolicy(channel) {
+	case PolicyDropNewest:
+		c.metrics().Dropped(channel)
+		return true
+	case PolicyDropOldest:
+		if _, ok := v.TryRecv(); ok {
+			c.metrics().Dropped(channel)
+		}
+		return false
+	}
+	c.metrics().ChannelFull(channel)
+	return false
+}
+
+// ChannelDepths returns how many messages are waiting in each output channel, keyed by the channel's field name. Poll it to graph how far behind the consumers are.
+func (c *IQC) ChannelDepths() map[string]int {
+	return map[string]int{
+		"System":      len(c.System),
+		"News":        len(c.News),
+		"Errors":      len(c.Errors),
+		"Fundamental": len(c.Fundamental),
+		"Regional":    len(c.Regional),
+		"Time":        len(c.Time),
+		"Updates":     len(c.Updates),
+		"Quotes":      len(c.Quotes),
+		"Merged":      len(c.Merged),
+		"Connection":  len(c.Connection),
+		"Depth":       len(c.Depth),
+		"Stats":       len(c.Stats),
+		"Bars":        len(c.Bars),
+		"Messages":    len(c.Messages),
+	}
+}
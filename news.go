@@ -1,6 +1,7 @@
 package iqfeed
 
 import (
+	"fmt"
 	"strings"
 	"time"
 )
@@ -10,18 +11,112 @@ type NewsMsg struct {
 	DistributorCode string    // Distributor type code
 	StoryID         int       // Numerical Story ID
 	SymbolList      []string  // List of symbols associated with news story.
-	DateTime        time.Time // Format is in YYYYMMDD HHMMSS
+	DateTime        time.Time // Format is in YYYYMMDD HHMMSS or YYYYMMDDHHMMSS depending on the protocol, in the feed's timezone.
 	Headline        string    // The text headline
+
+	Received
 }
 
 // UnMarshall sends the data into the usable struct for consumption by the application.
+// A quoted headline may contain commas, as may an unquoted one which is rebuilt from the remaining fields. Missing fields are left empty.
+// The symbols are a single colon delimited field (ex: :AAPL:MSFT:), split on the colons with the empty entries dropped.
 func (n *NewsMsg) UnMarshall(d []byte, loc *time.Location) {
-	items := strings.Split(string(d), ",")
+	items := splitFields(string(d))
+	// Drop the empty field left by a trailing comma so it isn't joined into the headline.
+	for len(items) > 5 && items[len(items)-1] == "" {
+		items = items[:len(items)-1]
+	}
+	for len(items) < 5 {
+		items = append(items, "")
+	}
 	n.DistributorCode = items[0]
 	n.StoryID = GetIntFromStr(items[1])
-	n.SymbolList = strings.Split(items[2], ":")
-	t, _ := time.ParseInLocation("20060102 150405", items[3], loc)
-	n.DateTime = t
-	n.Headline = items[4]
+	n.SymbolList = newsSymbols(items[2])
+	n.DateTime = newsTime(items[3], loc)
+	n.Headline = strings.Join(items[4:], ",")
+}
+
+// inLoc converts the story timestamp to loc.
+func (n *NewsMsg) inLoc(loc *time.Location) {
+	inLoc(loc, &n.DateTime)
+}
+
+// newsSymbols splits the colon delimited symbol list of a story.
+func newsSymbols(d string) []string {
+	var out []string
+	for _, s := range strings.Split(d, ":") {
+		if s = strings.TrimSpace(s); s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// newsTime parses the timestamp of a story, sent with or without a space between the date and the time.
+func newsTime(d string, loc *time.Location) time.Time {
+	t, err := time.ParseInLocation("20060102150405", d, loc)
+	if err != nil {
+		t, _ = time.ParseInLocation("20060102 150405", d, loc)
+	}
+	return t
+}
+
+// NewsHeadline is a headline returned by a news headline lookup, the full story can be fetched with GetNewsStory.
+type NewsHeadline struct {
+	DistributorCode string    // Distributor type code
+	StoryID         string    // Story ID to pass to GetNewsStory.
+	SymbolList      []string  // List of symbols associated with news story.
+	DateTime        time.Time // Format is in YYYYMMDDHHMMSS
+	Headline        string    // The text headline
+}
+
+// UnMarshall populates the headline from the fields following the N marker of a headline row.
+func (n *NewsHeadline) UnMarshall(items []string, loc *time.Location) {
+	for len(items) < 5 {
+		items = append(items, "")
+	}
+	n.DistributorCode = items[0]
+	n.StoryID = items[1]
+	n.SymbolList = newsSymbols(items[2])
+	n.DateTime = newsTime(items[3], loc)
+	// Headlines may contain commas of their own.
+	n.Headline = strings.Join(items[4:], ",")
+}
+
+// RequestNewsHeadlines returns up to limit of the latest headlines from the lookup port (the NHL command), filtered by distributor sources and symbols when they are not empty.
+func (c *IQC) RequestNewsHeadlines(sources []string, symbols []string, limit int) ([]NewsHeadline, error) {
+	id := c.incr()
+	loc, out := c.lookupLoc(), c.outputLoc()
+	cmd := fmt.Sprintf("NHL,%s,%s,t,%d,,%s\r\n", strings.Join(sources, ":"), strings.Join(symbols, ":"), limit, id)
+	var headlines []NewsHeadline
+	err := c.lookup(cmd, id, func(items []string) error {
+		if items[0] != "N" {
+			return nil
+		}
+		var h NewsHeadline
+		h.UnMarshall(items[1:], loc)
+		if out != nil {
+			inLoc(out, &h.DateTime)
+		}
+		headlines = append(headlines, h)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return headlines, nil
+}
 
+// GetNewsStory returns the text of a news story from the lookup port (the NSY command), storyID is the StoryID of a NewsMsg or NewsHeadline.
+func (c *IQC) GetNewsStory(storyID string) (string, error) {
+	id := c.incr()
+	var lines []string
+	err := c.lookup(fmt.Sprintf("NSY,%s,t,,%s\r\n", storyID, id), id, func(items []string) error {
+		lines = append(lines, strings.Join(items, ","))
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(lines, "\n"), nil
 }
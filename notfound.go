@@ -0,0 +1,38 @@
+package iqfeed
+
+import "time"
+
+// rememberNotFound records that the feed reported symbol as not found.
+func (c *IQC) rememberNotFound(symbol string) {
+	if c.NotFoundTTL <= 0 {
+		return
+	}
+	c.notFoundMu.Lock()
+	defer c.notFoundMu.Unlock()
+	if c.notFound == nil {
+		c.notFound = make(map[string]time.Time)
+	}
+	c.notFound[symbol] = time.Now().Add(c.NotFoundTTL)
+}
+
+// knownNotFound reports whether symbol was reported as not found within NotFoundTTL.
+func (c *IQC) knownNotFound(symbol string) bool {
+	c.notFoundMu.Lock()
+	defer c.notFoundMu.Unlock()
+	exp, ok := c.notFound[symbol]
+	if !ok {
+		return false
+	}
+	if time.Now().After(exp) {
+		delete(c.notFound, symbol)
+		return false
+	}
+	return true
+}
+
+// ClearNotFound empties the cache of symbols reported as not found, so they are sent to the feed again on the next watch.
+func (c *IQC) ClearNotFound() {
+	c.notFoundMu.Lock()
+	c.notFound = nil
+	c.notFoundMu.Unlock()
+}
@@ -0,0 +1,78 @@
+package iqfeed
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OptionSymbol is an equity option symbol split into its components, see ParseOptionSymbol.
+type OptionSymbol struct {
+	Underlying string
+	Expiry     time.Time // Expiration date, at midnight UTC.
+	Strike     float64
+	Call       bool // False for a put.
+}
+
+// BuildOptionSymbol returns the IQFeed symbol of an equity option, ex: MSFT1220J30.5 for the MSFT October 20 2012 30.5 call, ready to be passed to WatchSymbol.
+// The symbol is the root, the 2 digit expiration year and day, the month code (A to L for calls, M to X for puts) and the strike without trailing zeros.
+func (c *IQC) BuildOptionSymbol(underlying string, expiry time.Time, strike float64, isCall bool) string {
+	code, _ := OptionMonthCode(expiry.Month(), isCall)
+	return underlying + expiry.Format("0602") + string(code) + strconv.FormatFloat(strike, 'f', -1, 64)
+}
+
+// optionMonthCodes are the month codes of equity options, the calls for January to December followed by the puts.
+const optionMonthCodes = "ABCDEFGHIJKLMNOPQRSTUVWX"
+
+// OptionMonthCode returns the letter an equity option symbol uses for an expiration in month, A to L for calls and M to X for puts.
+func OptionMonthCode(month time.Month, isCall bool) (byte, error) {
+	if month < time.January || month > time.December {
+		return 0, fmt.Errorf("iqfeed: invalid month %d", month)
+	}
+	i := int(month - time.January)
+	if !isCall {
+		i += 12
+	}
+	return optionMonthCodes[i], nil
+}
+
+// MonthFromOptionCode is the inverse of OptionMonthCode, it returns the expiration month of an equity option month code and whether it is a call.
+func MonthFromOptionCode(code byte) (month time.Month, isCall bool, err error) {
+	i := strings.IndexByte(optionMonthCodes, code)
+	if i < 0 {
+		return 0, false, fmt.Errorf("iqfeed: invalid option month code %q", code)
+	}
+	return time.Month(i%12) + time.January, i < 12, nil
+}
+
+// ParseOptionSymbol splits an IQFeed equity option symbol built like BuildOptionSymbol does back into its components.
+func ParseOptionSymbol(symbol string) (OptionSymbol, error) {
+	var o OptionSymbol
+	i := len(symbol)
+	for i > 0 && (symbol[i-1] == '.' || symbol[i-1] >= '0' && symbol[i-1] <= '9') {
+		i--
+	}
+	// The root needs at least one character before the year, day and month code.
+	if i < 6 || i == len(symbol) {
+		return o, fmt.Errorf("iqfeed: invalid option symbol %q", symbol)
+	}
+	strike, err := strconv.ParseFloat(symbol[i:], 64)
+	if err != nil {
+		return o, fmt.Errorf("iqfeed: invalid strike in option symbol %q", symbol)
+	}
+	month, call, err := MonthFromOptionCode(symbol[i-1])
+	if err != nil {
+		return o, fmt.Errorf("iqfeed: invalid month code in option symbol %q", symbol)
+	}
+	o.Call = call
+	yy, errY := strconv.Atoi(symbol[i-5 : i-3])
+	dd, errD := strconv.Atoi(symbol[i-3 : i-1])
+	if errY != nil || errD != nil || dd < 1 || dd > 31 {
+		return o, fmt.Errorf("iqfeed: invalid expiration in option symbol %q", symbol)
+	}
+	o.Underlying = symbol[:i-5]
+	o.Expiry = time.Date(2000+yy, month, dd, 0, 0, 0, 0, time.UTC)
+	o.Strike = strike
+	return o, nil
+}
@@ -0,0 +1,134 @@
+package iqfeed
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// PriceFormat is the price format code sent in fundamental messages (FormatCode), it tells whether prices are shown as decimals or as fractions such as the 32nds of treasuries.
+// See: Price Format Codes http://www.iqfeed.net/dev/api/docs/PriceFormatCodes.cfm.
+type PriceFormat int
+
+// Denominator returns the fraction prices are quoted in for the fractional codes 1 to 8 (halves up to 256ths), 0 for decimal codes.
+func (p PriceFormat) Denominator() int {
+	if p >= 1 && p <= 8 {
+		return 1 << uint(p)
+	}
+	return 0
+}
+
+// Decimals returns the number of decimal digits of the decimal codes 10 to 19, false for the other codes.
+func (p PriceFormat) Decimals() (int, bool) {
+	if p >= 10 && p <= 19 {
+		return int(p - 10), true
+	}
+	return 0, false
+}
+
+// Format renders price in the format, false when the code is neither decimal nor fractional.
+// Prices in halves up to 16ths are shown as a whole number and a reduced fraction (ex: 12 3/8), finer fractions as the whole number and the count of 32nds, 64ths... (ex: 125-05).
+func (p PriceFormat) Format(price float64) (string, bool) {
+	if d, ok := p.Decimals(); ok {
+		return strconv.FormatFloat(price, 'f', d, 64), true
+	}
+	den := p.Denominator()
+	if den == 0 {
+		return "", false
+	}
+	sign := ""
+	if price < 0 {
+		sign, price = "-", -price
+	}
+	whole, frac := math.Modf(price)
+	n := int(math.Round(frac * float64(den)))
+	if n == den {
+		whole, n = whole+1, 0
+	}
+	w := strconv.FormatFloat(whole, 'f', 0, 64)
+	if den >= 32 {
+		width := len(strconv.Itoa(den - 1))
+		ticks := strconv.Itoa(n)
+		return sign + w + "-" + strings.Repeat("0", width-len(ticks)) + ticks, true
+	}
+	if n == 0 {
+		return sign + w, true
+	}
+	for n%2 == 0 {
+		n, den = n/2, den/2
+	}
+	return sign + w + " " + strconv.Itoa(n) + "/" + strconv.Itoa(den), true
+}
+
+// Precision returns the number of decimal digits the feed reported for the message's prices (the Decimal Precision field), false when it wasn't sent.
+func (u *UpdSummaryMsg) Precision() (int, bool) {
+	p, err := strconv.Atoi(strings.TrimSpace(u.DecPrecision))
+	if err != nil || p < 0 {
+		return 0, false
+	}
+	return p, true
+}
+
+// Precision returns the number of decimal digits prices of symbol should be shown with, as last reported in its fundamental, summary or update messages.
+// The boolean is false until one of them has been received.
+func (c *IQC) Precision(symbol string) (int, bool) {
+	c.precisionMu.Lock()
+	defer c.precisionMu.Unlock()
+	p, ok := c.precisions[symbol]
+	return p, ok
+}
+
+// PriceFormat returns the price format code of symbol as last sent in its fundamental message, false until one has been received.
+func (c *IQC) PriceFormat(symbol string) (PriceFormat, bool) {
+	c.precisionMu.Lock()
+	defer c.precisionMu.Unlock()
+	f, ok := c.priceFormats[symbol]
+	return f, ok
+}
+
+// FormatPrice formats price for display. Symbols whose price format code (see PriceFormat) is fractional are shown in fractions as described in PriceFormat.Format,
+// the others with the precision of symbol (see Precision), with as many digits as needed when it isn't known yet.
+func (c *IQC) FormatPrice(symbol string, price float64) string {
+	if f, ok := c.PriceFormat(symbol); ok && f.Denominator() > 0 {
+		s, _ := f.Format(price)
+		return s
+	}
+	p, ok := c.Precision(symbol)
+	if !ok {
+		p = -1
+	}
+	return strconv.FormatFloat(price, 'f', p, 64)
+}
+
+// setPrecision remembers the precision of symbol for Precision.
+func (c *IQC) setPrecision(symbol string, p int) {
+	if symbol == "" {
+		return
+	}
+	c.precisionMu.Lock()
+	defer c.precisionMu.Unlock()
+	if c.precisions == nil {
+		c.precisions = make(map[string]int)
+	}
+	c.precisions[symbol] = p
+}
+
+// setPriceFormat remembers the price format code of symbol for FormatPrice.
+func (c *IQC) setPriceFormat(symbol string, f PriceFormat) {
+	if symbol == "" {
+		return
+	}
+	c.precisionMu.Lock()
+	defer c.precisionMu.Unlock()
+	if c.priceFormats == nil {
+		c.priceFormats = make(map[string]PriceFormat)
+	}
+	c.priceFormats[symbol] = f
+}
+
+// updatePrecision remembers the precision sent in a summary or update message, if any.
+func (c *IQC) updatePrecision(u *UpdSummaryMsg) {
+	if p, ok := u.Precision(); ok {
+		c.setPrecision(u.Symbol, p)
+	}
+}
@@ -0,0 +1,77 @@
+package iqfeed
+
+import "time"
+
+// Quote is a complete, current picture of a symbol built by merging successive summary and update messages, fields left empty by an update keep their previous value.
+type Quote struct {
+	Symbol   string    // The Symbol ID to match with watch request
+	Bid      float64   // The highest price a market maker or broker is willing to pay for a security.
+	BidSize  int       // The share size available for the bid price.
+	Ask      float64   // The lowest price a market maker or broker is willing to accept for a security.
+	AskSize  int       // The share size available for the ask price.
+	Last     float64   // Price of the most recent trade.
+	LastSize int       // Size of the most recent trade.
+	Volume   int       // Today's cumulative volume in number of shares.
+	Time     time.Time // Time of the most recent trade, bid or ask that changed the quote.
+
+	Received
+}
+
+// mergeQuote applies the non empty fields of a summary / update line to the symbol's quote and returns a copy of the result.
+func (c *IQC) mergeQuote(u *UpdSummaryMsg, items []string, fields map[int]string) *Quote {
+	if c.quotes == nil {
+		c.quotes = make(map[string]*Quote)
+	}
+	q, ok := c.quotes[u.Symbol]
+	if !ok {
+		q = &Quote{Symbol: u.Symbol}
+		c.quotes[u.Symbol] = q
+	}
+	// The latest of the update's trade, bid and ask times becomes the quote time, whatever their order in the layout.
+	var latest time.Time
+	for k, v := range items {
+		if v == "" {
+			continue
+		}
+		switch fields[k] {
+		case "Bid":
+			q.Bid = u.Bid
+		case "Bid Size":
+			q.BidSize = u.BidSize
+		case "Ask":
+			q.Ask = u.Ask
+		case "Ask Size":
+			q.AskSize = u.AskSize
+		case "Last":
+			q.Last = u.Last
+		case "Most Recent Trade":
+			q.Last = u.MostRecentTrade
+		case "Most Recent Trade Size":
+			q.LastSize = u.MostRecentTradeSize
+		case "Total Volume":
+			q.Volume = u.TotalVol
+		case "Most Recent Trade Time", "Most Recent Trade TimeMS":
+			latest = laterTime(latest, u.MostRecentTradeTime)
+		case "Trade Time":
+			latest = laterTime(latest, u.TradeTime)
+		case "Bid Time":
+			latest = laterTime(latest, u.BidTime)
+		case "Ask Time":
+			latest = laterTime(latest, u.AskTime)
+		}
+	}
+	if !latest.IsZero() {
+		q.Time = latest
+	}
+	q.Received = u.Received
+	m := *q
+	return &m
+}
+
+// laterTime returns the later of a and b, a zero time counts as unset. Times of day parsed without a date are before the zero time so it can't simply be compared.
+func laterTime(a, b time.Time) time.Time {
+	if a.IsZero() || (!b.IsZero() && b.After(a)) {
+		return b
+	}
+	return a
+}
@@ -0,0 +1,447 @@
+package iqfeed
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// cannedConn is an io.ReadWriteCloser serving a fixed feed and recording the commands written to it.
+type cannedConn struct {
+	r  *strings.Reader
+	mu sync.Mutex
+	w  bytes.Buffer
+}
+
+func (c *cannedConn) Read(b []byte) (int, error) { return c.r.Read(b) }
+
+func (c *cannedConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.w.Write(b)
+}
+
+func (c *cannedConn) Close() error { return nil }
+
+func (c *cannedConn) written() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.w.String()
+}
+
+// startCanned starts a client on a canned feed and waits for the feed to be consumed.
+func startCanned(t *testing.T, lines ...string) (*IQC, *cannedConn) {
+	t.Helper()
+	return startCannedClient(t, &IQC{TimeZone: "UTC", Logger: NopLogger{}}, lines...)
+}
+
+// startCannedClient is startCanned with the client's options set up by the caller.
+func startCannedClient(t *testing.T, c *IQC, lines ...string) (*IQC, *cannedConn) {
+	t.Helper()
+	conn := &cannedConn{r: strings.NewReader(strings.Join(lines, "\r\n") + "\r\n")}
+	if _, err := c.StartConn(conn, 64); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-c.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the canned feed was not consumed")
+	}
+	return c, conn
+}
+
+func TestProcessReceiverRouting(t *testing.T) {
+	c, conn := startCanned(t,
+		"S,SERVER CONNECTED",
+		"S,CURRENT UPDATE FIELDNAMES,Symbol,Most Recent Trade,Most Recent Trade Size",
+		"P,AAPL,95.02,100",
+		"Q,AAPL,95.03,200",
+		"T,20160314 09:30:00",
+		"R,AAPL,,95.01,300,09:30:00,95.04,400,09:30:01,14,4,5",
+		"F,AAPL"+strings.Repeat(",", 54),
+		"N,DTN,12345,AAPL:MSFT,20160314 093000,Apple headline",
+		"n,ZZZZ",
+		"E,!SYNTAX_ERROR!",
+		"X,ignored",
+		"",
+	)
+	defer c.Stop()
+
+	if !strings.Contains(conn.written(), "S,REQUEST CURRENT UPDATE FIELDNAMES\r\n") {
+		t.Errorf("expected the field names to be requested, wrote %q", conn.written())
+	}
+
+	if s := <-c.System; s.Type != "SERVER CONNECTED" {
+		t.Errorf("unexpected system message %+v", s)
+	}
+	// Field name messages update the layout and are reported on System.
+	if f := c.UpdateFieldNames(); len(f) != 3 || f[1] != "Most Recent Trade" {
+		t.Errorf("unexpected field names %v", f)
+	}
+	if s := <-c.System; s.Type != "CURRENT UPDATE FIELDNAMES" || !s.FieldNames.Current || !reflect.DeepEqual(s.FieldNames.Names, []string{"Symbol", "Most Recent Trade", "Most Recent Trade Size"}) {
+		t.Errorf("unexpected field names message %+v", s.FieldNames)
+	}
+	if u := <-c.Updates; u.Kind != KindSummary || u.Symbol != "AAPL" || u.MostRecentTrade != 95.02 || u.MostRecentTradeSize != 100 {
+		t.Errorf("unexpected summary %+v", u)
+	}
+	if u := <-c.Updates; u.Kind == KindSummary || u.MostRecentTrade != 95.03 || u.MostRecentTradeSize != 200 {
+		t.Errorf("unexpected update %+v", u)
+	}
+	if tm := <-c.Time; !tm.TimeStamp.Equal(time.Date(2016, 3, 14, 9, 30, 0, 0, time.UTC)) {
+		t.Errorf("unexpected time %v", tm.TimeStamp)
+	}
+	if r := <-c.Regional; r.Symbol != "AAPL" || r.RegBid != 95.01 || r.RegAskSize != 400 || r.MarketCenter != 5 {
+		t.Errorf("unexpected regional %+v", r)
+	}
+	if f := <-c.Fundamental; f.Symbol != "AAPL" {
+		t.Errorf("unexpected fundamental %+v", f)
+	}
+	if n := <-c.News; n.StoryID != 12345 || len(n.SymbolList) != 2 || n.Headline != "Apple headline" {
+		t.Errorf("unexpected news %+v", n)
+	}
+	if e := <-c.Errors; e.Code != 404 || e.Symbol != "ZZZZ" {
+		t.Errorf("unexpected not found %+v", e)
+	}
+	if e := <-c.Errors; e.Code != 500 || e.Err != ErrSyntaxError {
+		t.Errorf("unexpected error %+v", e)
+	}
+
+	// Nothing else may have been produced by the unknown and empty lines.
+	if n := len(c.System) + len(c.Updates) + len(c.Time) + len(c.Regional) + len(c.Fundamental) + len(c.News) + len(c.Errors); n != 0 {
+		t.Errorf("%d unexpected messages left", n)
+	}
+}
+
+func TestStartConnIgnoresReconnect(t *testing.T) {
+	conn := &cannedConn{r: strings.NewReader(fieldsLine + "T,20160314 09:30:00\r\n")}
+	c := &IQC{TimeZone: "UTC", Logger: NopLogger{}, ReconnectEnabled: true}
+	if _, err := c.StartConn(conn, 4); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-c.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the reader to exit at the end of the stream instead of re-dialling")
+	}
+	c.Stop()
+	if len(c.Connection) != 0 {
+		t.Errorf("unexpected connection events")
+	}
+}
+
+// recordMetrics counts every metric it receives.
+type recordMetrics struct {
+	mu       sync.Mutex
+	received map[byte]int
+	bytes    int
+	parse    int
+	full     map[string]int
+	dropped  map[string]int
+}
+
+func newRecordMetrics() *recordMetrics {
+	return &recordMetrics{received: map[byte]int{}, full: map[string]int{}, dropped: map[string]int{}}
+}
+
+func (m *recordMetrics) MessageReceived(msgType byte, bytes int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.received[msgType]++
+	m.bytes += bytes
+}
+
+func (m *recordMetrics) ParseError(msgType byte, line []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.parse++
+}
+
+func (m *recordMetrics) ChannelFull(channel string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.full[channel]++
+}
+
+func (m *recordMetrics) Dropped(channel string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.dropped[channel]++
+}
+
+func TestMetrics(t *testing.T) {
+	m := newRecordMetrics()
+	conn := &cannedConn{r: strings.NewReader(fieldsLine + "T,20160314 09:30:00\r\nT,20160314 09:30:01\r\nX,unknown\r\n")}
+	c := &IQC{TimeZone: "UTC", Logger: NopLogger{}, Metrics: m}
+	if _, err := c.StartConn(conn, 1); err != nil {
+		t.Fatal(err)
+	}
+	// The second time message has to wait for the first to be read.
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		m.mu.Lock()
+		full := m.full["Time"]
+		m.mu.Unlock()
+		if full > 0 || time.Now().After(deadline) {
+			break
+		}
+	}
+	<-c.Time
+	<-c.Time
+	<-c.done
+	c.Stop()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.received['T'] != 2 || m.received['X'] != 1 || m.bytes != len(fieldsLine)+2*21+11 {
+		t.Errorf("unexpected received counts %v, %d bytes", m.received, m.bytes)
+	}
+	if m.parse != 1 {
+		t.Errorf("expected 1 parse error, got %d", m.parse)
+	}
+	if m.full["Time"] != 1 {
+		t.Errorf("expected the Time channel to be reported full once, got %v", m.full)
+	}
+	if depths := c.ChannelDepths(); depths["Time"] != 0 || len(depths) != 14 {
+		t.Errorf("unexpected depths %v", depths)
+	}
+}
+
+func TestDropPolicies(t *testing.T) {
+	m := newRecordMetrics()
+	c := newTestClient()
+	c.Metrics = m
+	c.Time = make(chan *TimeMsg, 2)
+	c.Errors = make(chan *ErrorMsg, 1)
+	c.DropPolicy = PolicyDropNewest
+	c.DropPolicies = map[string]DropPolicy{"Errors": PolicyDropOldest}
+
+	for _, ts := range []string{"09:30:00", "09:30:01", "09:30:02"} {
+		c.processReceiver([]byte("T,20160314 " + ts))
+	}
+	c.processReceiver([]byte("n,AAAA"))
+	c.processReceiver([]byte("n,BBBB"))
+
+	if a, b := <-c.Time, <-c.Time; a.TimeStamp.Second() != 0 || b.TimeStamp.Second() != 1 || len(c.Time) != 0 {
+		t.Errorf("expected the newest time message to be dropped, kept %v %v", a.TimeStamp, b.TimeStamp)
+	}
+	if e := <-c.Errors; e.Symbol != "BBBB" {
+		t.Errorf("expected the oldest error to be dropped, kept %s", e.Symbol)
+	}
+	if m.dropped["Time"] != 1 || m.dropped["Errors"] != 1 || len(m.full) != 0 {
+		t.Errorf("unexpected drops %v, full %v", m.dropped, m.full)
+	}
+}
+
+func TestUnifiedMessages(t *testing.T) {
+	conn := &cannedConn{r: strings.NewReader(strings.Join([]string{
+		"S,CURRENT UPDATE FIELDNAMES,Symbol,Most Recent Trade",
+		"T,20160314 09:30:00",
+		"Q,AAPL,95.03",
+		"n,ZZZZ",
+		"T,20160314 09:30:01",
+		"S,SERVER CONNECTED",
+	}, "\r\n") + "\r\n")}
+	c := &IQC{TimeZone: "UTC", Logger: NopLogger{}, UnifiedMessages: true}
+	if _, err := c.StartConn(conn, 16); err != nil {
+		t.Fatal(err)
+	}
+	<-c.done
+	c.Stop()
+
+	var got []string
+	for m := range c.Messages {
+		got = append(got, fmt.Sprintf("%T", m))
+	}
+	want := []string{"*iqfeed.SystemMessage", "*iqfeed.TimeMsg", "*iqfeed.UpdSummaryMsg", "*iqfeed.ErrorMsg", "*iqfeed.TimeMsg", "*iqfeed.SystemMessage"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("messages = %v, want %v", got, want)
+	}
+	if len(c.Time)+len(c.Updates)+len(c.Errors)+len(c.System) != 0 {
+		t.Error("expected nothing on the typed channels")
+	}
+}
+
+func TestMessagePrefix(t *testing.T) {
+	c := newTestClient()
+	m := newRecordMetrics()
+	c.Metrics = m
+	c.setDynFields([]string{"Symbol", "Last"})
+	for _, tc := range []struct {
+		line string
+		n    func() int
+	}{
+		{"S,SERVER CONNECTED", func() int { return len(c.System) }},
+		{"P,AAPL,95.02", func() int { return len(c.Updates) }},
+		{"Q,AAPL,95.03", func() int { return len(c.Updates) }},
+		{"T,20160314 09:30:00", func() int { return len(c.Time) }},
+		{"R,AAPL,,95.01,300,09:30:00,95.04,400,09:30:01,14,4,5", func() int { return len(c.Regional) }},
+		{"F,AAPL" + strings.Repeat(",", 54), func() int { return len(c.Fundamental) }},
+		{"N,DTN,1,AAPL,20160314 093000,Headline", func() int { return len(c.News) }},
+		{"n,ZZZZ", func() int { return len(c.Errors) }},
+		{"E,!SYNTAX_ERROR!", func() int { return len(c.Errors) }},
+	} {
+		before := tc.n()
+		c.processReceiver([]byte(tc.line))
+		if tc.n() != before+1 {
+			t.Errorf("%q was not routed", tc.line)
+		}
+	}
+	if m.parse != 0 {
+		t.Fatalf("%d parse errors", m.parse)
+	}
+	// A first field longer than one letter is not taken for the letter it starts with.
+	for _, line := range []string{"SX,SERVER CONNECTED", "TIME,20160314 09:30:00", "Qx"} {
+		c.processReceiver([]byte(line))
+	}
+	if m.parse != 3 || len(c.System) != 1 || len(c.Time) != 1 || len(c.Updates) != 2 {
+		t.Errorf("%d parse errors, %d system, %d time, %d updates", m.parse, len(c.System), len(c.Time), len(c.Updates))
+	}
+}
+
+func TestNewsMsgUnMarshall(t *testing.T) {
+	for _, line := range []string{
+		"DTN,22809170690,:AAPL: MSFT:,20160314093000,Apple, Microsoft and others,",
+		"DTN,22809170690,AAPL:MSFT,20160314 093000,Apple, Microsoft and others",
+	} {
+		n := &NewsMsg{}
+		n.UnMarshall([]byte(line), time.UTC)
+		if n.DistributorCode != "DTN" || n.StoryID != 22809170690 || !reflect.DeepEqual(n.SymbolList, []string{"AAPL", "MSFT"}) {
+			t.Errorf("%q: got %+v", line, n)
+		}
+		if !n.DateTime.Equal(time.Date(2016, 3, 14, 9, 30, 0, 0, time.UTC)) || n.Headline != "Apple, Microsoft and others" {
+			t.Errorf("%q: got time %v headline %q", line, n.DateTime, n.Headline)
+		}
+	}
+}
+
+func TestMalformedMessages(t *testing.T) {
+	c := newTestClient()
+	m := newRecordMetrics()
+	c.Metrics = m
+	for _, line := range []string{
+		`N,DTN,1,AAPL:,20160314 093000,Apple, Inc. beats estimates,`,
+		`N,DTN,2,AAPL,20160314 093000,"Apple, Inc. ""beats"" estimates"`,
+		`N,DTN,3,AAPL`,
+	} {
+		c.processReceiver([]byte(line))
+	}
+	for _, want := range []string{`Apple, Inc. beats estimates`, `Apple, Inc. "beats" estimates`} {
+		n := <-c.News
+		if n.Headline != want || len(n.SymbolList) != 1 || n.SymbolList[0] != "AAPL" {
+			t.Errorf("headline %q symbols %q, want %q", n.Headline, n.SymbolList, want)
+		}
+	}
+	if len(c.News) != 0 {
+		t.Errorf("the truncated news line was delivered")
+	}
+	e := <-c.Errors
+	if !errors.Is(e, ErrMalformedMessage) || e.Raw != "N,DTN,3,AAPL" {
+		t.Errorf("got error %v raw %q", e, e.Raw)
+	}
+	if m.parse != 1 {
+		t.Errorf("got %d parse errors, want 1", m.parse)
+	}
+}
+
+func TestProcessReceiverRecovers(t *testing.T) {
+	c := newTestClient()
+	l := &recordLogger{}
+	c.Logger = l
+	// Parsing a time without a location panics.
+	c.TimeLoc = nil
+	c.processReceiver([]byte("T,20160314 09:30:00"))
+	e := <-c.Errors
+	if !errors.Is(e, ErrMalformedMessage) || e.Raw != "T,20160314 09:30:00" {
+		t.Errorf("got error %v raw %q", e, e.Raw)
+	}
+	if len(l.msgs) != 1 || !strings.Contains(l.msgs[0], "T,20160314 09:30:00") {
+		t.Errorf("logged %q", l.msgs)
+	}
+
+	c.TimeLoc = time.UTC
+	c.processReceiver([]byte("T,20160314 09:30:00"))
+	if tm := <-c.Time; tm.TimeStamp.Hour() != 9 {
+		t.Errorf("the next line was not processed: %v", tm.TimeStamp)
+	}
+}
+
+func TestStartStreams(t *testing.T) {
+	r, feed := io.Pipe()
+	w := &recordConn{}
+	go feed.Write([]byte(fieldsLine + "T,20160314 09:30:00\r\n"))
+	c, err := (&IQC{TimeZone: "UTC", Logger: NopLogger{}}).StartStreams(r, w, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tm := <-c.Time; tm.TimeStamp.Hour() != 9 {
+		t.Errorf("unexpected time %v", tm.TimeStamp)
+	}
+	if err := c.WatchSymbol("AAPL"); err != nil {
+		t.Fatal(err)
+	}
+	if got := w.String(); got != "S,REQUEST CURRENT UPDATE FIELDNAMES\r\nwAAPL\r\n" {
+		t.Errorf("commands = %q", got)
+	}
+	// Stop closes the reader so the blocked read returns.
+	c.Stop()
+	if _, err := feed.Write([]byte("T,20160314 09:30:01\r\n")); err == nil {
+		t.Error("expected the feed pipe to be closed")
+	}
+}
+
+func TestReadBufferSize(t *testing.T) {
+	if r := (&IQC{}).newReader(nil); r.Size() != defaultReadBufferSize {
+		t.Errorf("default reader size = %d, want %d", r.Size(), defaultReadBufferSize)
+	}
+	headline := strings.Repeat("Apple unveils new devices, ", 400)
+	c, _ := startCannedClient(t, &IQC{TimeZone: "UTC", Logger: NopLogger{}, ReadBufferSize: 16},
+		"S,CURRENT UPDATE FIELDNAMES,Symbol,Last",
+		"N,DTN,22110963127,AAPL:,20160314 093000,"+headline,
+	)
+	defer c.Stop()
+	if r := c.newReader(nil); r.Size() != 16 {
+		t.Errorf("reader size = %d, want 16", r.Size())
+	}
+	// Lines longer than the buffer are still delivered whole.
+	if n := <-c.News; n.Headline != headline {
+		t.Errorf("headline was truncated to %d bytes", len(n.Headline))
+	}
+}
+
+func TestLogErrors(t *testing.T) {
+	// More errors than Errors can hold, nothing reads them but the feed is still consumed.
+	lines := []string{"S,CURRENT UPDATE FIELDNAMES,Symbol,Last"}
+	for i := 0; i < 100; i++ {
+		lines = append(lines, fmt.Sprintf("n,SYM%d", i))
+	}
+	logs := &recordLogger{}
+	c, _ := startCannedClient(t, &IQC{TimeZone: "UTC", Logger: logs, LogErrors: true}, lines...)
+	c.Stop()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		var logged []string
+		logs.mu.Lock()
+		for _, m := range logs.msgs {
+			if strings.HasPrefix(m, "warn: iqfeed: Symbol not found") {
+				logged = append(logged, m)
+			}
+		}
+		logs.mu.Unlock()
+		if len(logged) == 100 {
+			if logged[0] != "warn: iqfeed: Symbol not found: SYM0" {
+				t.Errorf("first logged error = %q", logged[0])
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("only %d errors logged", len(logged))
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
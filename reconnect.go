@@ -0,0 +1,225 @@
+package iqfeed
+
+import (
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Defaults used by the reconnect logic when the corresponding IQC fields aren't set.
+const (
+	defaultInitialBackoff = time.Second
+	defaultMaxBackoff     = time.Minute
+)
+
+// ConnectionState describes a change in the state of the connection to IQFeed.
+type ConnectionState int
+
+const (
+	// StateDisconnected is sent when the connection to IQFeed is lost.
+	StateDisconnected ConnectionState = iota
+	// StateReconnecting is sent before every reconnection attempt.
+	StateReconnecting
+	// StateReconnected is sent once the connection is re-established and the field selection and watches have been replayed.
+	StateReconnected
+	// StateReconnectFailed is sent when MaxReconnectAttempts is exhausted and the client gives up.
+	StateReconnectFailed
+	// StateStale is sent when nothing has been received for StaleTimeout, see the watchdog.
+	StateStale
+	// StateServerDisconnected is sent when IQConnect reports S,SERVER DISCONNECTED: the socket is up but IQConnect lost its link to the DTN servers, so the data is stale.
+	StateServerDisconnected
+	// StateServerConnected is sent when IQConnect reports S,SERVER CONNECTED, it is connected to the DTN servers again.
+	StateServerConnected
+	// StateServerReconnectFailed is sent when IQConnect reports S,SERVER RECONNECT FAILED, it gave up reconnecting to the DTN servers.
+	StateServerReconnectFailed
+)
+
+// String returns a readable name for the state.
+func (s ConnectionState) String() string {
+	switch s {
+	case StateDisconnected:
+		return "disconnected"
+	case StateReconnecting:
+		return "reconnecting"
+	case StateReconnected:
+		return "reconnected"
+	case StateReconnectFailed:
+		return "reconnect failed"
+	case StateStale:
+		return "stale"
+	case StateServerDisconnected:
+		return "server disconnected"
+	case StateServerConnected:
+		return "server connected"
+	case StateServerReconnectFailed:
+		return "server reconnect failed"
+	}
+	return "unknown"
+}
+
+// ConnectionEvent is sent on the Connection channel whenever the connection state changes.
+type ConnectionEvent struct {
+	State   ConnectionState // The new state of the connection.
+	Attempt int             // The reconnection attempt number, 0 for events that aren't part of an attempt.
+	Err     error           // The error that caused the state change, if any.
+	Time    time.Time       // Local time the event occurred at.
+}
+
+// event sends a connection event without blocking, events are dropped when nobody is draining the Connection channel so an unread channel can't stall the feed.
+func (c *IQC) event(state ConnectionState, attempt int, err error) {
+	e := &ConnectionEvent{State: state, Attempt: attempt, Err: err, Time: time.Now()}
+	select {
+	case c.Connection <- e:
+	default:
+		if c.Connection != nil {
+			c.metrics().Dropped("Connection")
+		}
+	}
+}
+
+// reconnect re-dials IQFeed with exponential backoff after the connection was lost with cause, replaying the field selection and watches on success.
+// It returns false when the client is stopped or MaxReconnectAttempts is exhausted.
+func (c *IQC) reconnect(cause error) (net.Conn, bool) {
+	c.conn().Close()
+	c.event(StateDisconnected, 0, cause)
+
+	backoff := c.InitialBackoff
+	if backoff <= 0 {
+		backoff = defaultInitialBackoff
+	}
+	maxBackoff := c.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = defaultMaxBackoff
+	}
+	lastErr := cause
+	attempt := 1
+	for ; c.MaxReconnectAttempts <= 0 || attempt <= c.MaxReconnectAttempts; attempt++ {
+		c.event(StateReconnecting, attempt, lastErr)
+		select {
+		case <-time.After(backoff):
+		case <-c.stop:
+			return nil, false
+		}
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+
+		conn, err := c.dial()
+		if err != nil {
+			c.log().Warnf("Reconnect attempt %d failed: %s", attempt, err)
+			lastErr = err
+			continue
+		}
+		if !c.setConn(conn) {
+			return nil, false
+		}
+		c.touch()
+		atomic.StoreInt32(&c.connected, 1)
+		c.resubscribe()
+		c.event(StateReconnected, attempt, nil)
+		return conn, true
+	}
+	c.event(StateReconnectFailed, attempt-1, lastErr)
+	return nil, false
+}
+
+// resubscribe replays the protocol version, the field selection, every watched symbol and the regional watches on a new connection.
+func (c *IQC) resubscribe() {
+	// The reader is blocked in here so the confirmation can't be waited for, the version is sent first so the field names come back in its format.
+	if v := c.Protocol(); v != "" {
+		c.send("S,SET PROTOCOL," + v + "\r\n")
+	}
+	if atomic.LoadInt32(&c.timestampsOff) == 1 {
+		c.send("S,TIMESTAMPSOFF\r\n")
+	}
+	if name, _ := c.clientName.Load().(string); name != "" {
+		c.send("S,SET CLIENT NAME," + name + "\r\n")
+	}
+	c.fieldsMu.Lock()
+	fields := c.selectedFields
+	c.fieldsMu.Unlock()
+	if len(fields) > 0 {
+		c.send("S,SELECT UPDATE FIELDS," + strings.Join(fields, ",") + "\r\n")
+	} else {
+		c.send("S,REQUEST CURRENT UPDATE FIELDNAMES\r\n")
+	}
+
+	c.watchMu.Lock()
+	watched := make(map[string]WatchMode, len(c.watched))
+	for s, m := range c.watched {
+		watched[s] = m
+	}
+	regional := make([]string, 0, len(c.regional))
+	for s := range c.regional {
+		regional = append(regional, s)
+	}
+	c.watchMu.Unlock()
+	for s, m := range watched {
+		cmd := "w"
+		if m == WatchModeTrades {
+			cmd = "t"
+		}
+		c.send(cmd + s + "\r\n")
+	}
+	for _, s := range regional {
+		c.send("S,REGON," + s + "\r\n")
+	}
+}
+
+// conn returns the current connection.
+func (c *IQC) conn() net.Conn {
+	c.connMu.RLock()
+	defer c.connMu.RUnlock()
+	return c.Conn
+}
+
+// ConnectString returns the address the client was started with, with the default host and port filled in as it is dialled, ex: 127.0.0.1:5009. It is empty before Start.
+func (c *IQC) ConnectString() string {
+	return c.connectString
+}
+
+// RemoteAddr returns the address of the feed the current connection is to, as resolved when it was dialled. It follows reconnects, which may resolve a host name to another address, and is empty before Start.
+func (c *IQC) RemoteAddr() string {
+	conn := c.conn()
+	if conn == nil || conn.RemoteAddr() == nil {
+		return ""
+	}
+	return conn.RemoteAddr().String()
+}
+
+// setConn swaps in a new connection, returning false (and closing it) when the client has been stopped in the meantime.
+func (c *IQC) setConn(conn net.Conn) bool {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+	select {
+	case <-c.stop:
+		conn.Close()
+		return false
+	default:
+	}
+	c.Conn = conn
+	return true
+}
+
+// serverState records a change in IQConnect's link to the DTN servers reported by a system message.
+func (c *IQC) serverState(state ConnectionState) {
+	var err error
+	down := int32(1)
+	switch state {
+	case StateServerConnected:
+		down = 0
+	case StateServerDisconnected:
+		err = ErrServerDisconnected
+	case StateServerReconnectFailed:
+		err = ErrServerReconnectFailed
+	}
+	atomic.StoreInt32(&c.serverDown, down)
+	c.event(state, 0, err)
+}
+
+// ServerConnected reports whether IQConnect is connected to the DTN servers, it is false from an S,SERVER DISCONNECTED message until the next S,SERVER CONNECTED.
+// Data received while it is false is stale.
+func (c *IQC) ServerConnected() bool {
+	return atomic.LoadInt32(&c.serverDown) == 0
+}
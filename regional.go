@@ -18,20 +18,37 @@ type RegionalMsg struct {
 	FractionDispCode int       // Display formatting code see Price Format Codes (http://www.iqfeed.net/dev/api/docs/PriceFormatCodes.cfm).
 	DecPrecision     int       // Last Precision used.
 	MarketCenter     int       // The regional exchange that the updae occurred at. See the Listed Markets Codes for a list of possible values.(http://www.iqfeed.net/dev/api/docs/ListedMarkets.cfm).
+	MarketCenterName string    // Short name of MarketCenter (ex: NYSE) from the listed markets table, empty until RequestListedMarkets has been called.
+
+	Received
 }
 
 // UnMarshall sends the data into the usable struct for consumption by the application.
 func (r *RegionalMsg) UnMarshall(d []byte, loc *time.Location) {
 	items := strings.Split(string(d), ",")
+	for len(items) < 11 {
+		items = append(items, "")
+	}
 	r.Symbol = items[0]
 	r.Exchange = items[1]
 	r.RegBid = GetFloatFromStr(items[2])
 	r.RegBidSize = GetIntFromStr(items[3])
-	r.RegBidTime = GetTimeInHMS(items[4], loc)
+	r.RegBidTime = getRegionalTime(items[4], loc)
 	r.RegAsk = GetFloatFromStr(items[5])
 	r.RegAskSize = GetIntFromStr(items[6])
-	r.RegAskTime = GetTimeInHMS(items[7], loc)
+	r.RegAskTime = getRegionalTime(items[7], loc)
 	r.FractionDispCode = GetIntFromStr(items[8])
 	r.DecPrecision = GetIntFromStr(items[9])
 	r.MarketCenter = GetIntFromStr(items[10])
 }
+
+// getRegionalTime parses a regional bid or ask time, sent with or without fractional seconds depending on the protocol. Only the time of day is sent so the date is left at zero.
+func getRegionalTime(d string, loc *time.Location) time.Time {
+	t, _ := time.ParseInLocation("15:04:05.999999", d, loc)
+	return t
+}
+
+// inLoc converts the regional bid and ask times to loc, taking them to be on day.
+func (r *RegionalMsg) inLoc(day time.Time, loc *time.Location) {
+	inLocClock(day, loc, &r.RegBidTime, &r.RegAskTime)
+}
@@ -0,0 +1,101 @@
+package iqfeed
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// ReplayFile feeds a backup file written with CreateBackup through the same parser as a live connection, populating the output channels in the order the lines were captured.
+// Rotated segments compressed with CompressBackups can be replayed as they are. The file is replayed as fast as the channels are drained unless RealTimeReplay is set, in which case the output is paced using the timestamp messages in the file,
+// sped up or slowed down by ReplaySpeed. ReplayStartTime seeks to the interesting part of a long capture.
+// Commands such as WatchSymbol are accepted but discarded. Once the end of the file is reached the client stops itself and closes the output channels like Stop.
+func (c *IQC) ReplayFile(path string, bufferSize int) (*IQC, error) {
+	if err := c.prepare(); err != nil {
+		return nil, err
+	}
+	f, err := openBackupFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("iqfeed: could not open replay file %s: %w", path, err)
+	}
+	c.Conn = &streamConn{rwc: replayFile{f}, name: path}
+	c.makeChannels(bufferSize)
+	c.startReader(c.replay)
+	return c, nil
+}
+
+// replay is the ReplayFile counterpart of read, it processes every line of the file and then stops the client.
+func (c *IQC) replay() {
+	r := c.newReader(c.conn())
+	var last time.Time
+	seeking := !c.ReplayStartTime.IsZero()
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			if err != io.EOF && !c.stopped() {
+				c.log().Errorf("Replay failed: %s", err)
+			}
+			break
+		}
+		c.touch()
+		if len(line) > 0 {
+			c.metrics().MessageReceived(line[0], len(line)+2)
+		}
+		if seeking {
+			if t, ok := c.lineTime(line); !ok || t.Before(c.ReplayStartTime) {
+				// The field layout and protocol are still needed to parse what follows.
+				if len(line) > 0 && line[0] == 'S' {
+					c.processReceiver(line)
+				}
+				continue
+			}
+			seeking = false
+		}
+		if c.RealTimeReplay && !c.pace(line, &last) {
+			break
+		}
+		c.processReceiver(line)
+	}
+	close(c.done)
+	c.Stop()
+}
+
+// pace sleeps for the time elapsed in the feed between the previous timestamp message and line, when line is one, divided by ReplaySpeed. It returns false if the client was stopped while waiting.
+func (c *IQC) pace(line []byte, last *time.Time) bool {
+	t, ok := c.lineTime(line)
+	if !ok {
+		return true
+	}
+	prev := *last
+	*last = t
+	if prev.IsZero() || !t.After(prev) {
+		return true
+	}
+	wait := t.Sub(prev)
+	if c.ReplaySpeed > 0 {
+		wait = time.Duration(float64(wait) / c.ReplaySpeed)
+	}
+	select {
+	case <-time.After(wait):
+		return true
+	case <-c.stop:
+		return false
+	}
+}
+
+// lineTime returns the timestamp of line when it is a timestamp message that can be parsed.
+func (c *IQC) lineTime(line []byte) (time.Time, bool) {
+	if len(line) < 3 || line[0] != 'T' {
+		return time.Time{}, false
+	}
+	var tm TimeMsg
+	tm.UnMarshall(line[2:], c.TimeLoc)
+	return tm.TimeStamp, !tm.TimeStamp.IsZero()
+}
+
+// replayFile stands in for the IQFeed connection during a replay, reads come from the backup file and commands written to it are discarded.
+type replayFile struct {
+	io.ReadCloser
+}
+
+func (r replayFile) Write(b []byte) (int, error) { return len(b), nil }
@@ -0,0 +1,69 @@
+package iqfeed
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SymbolMatch is a symbol returned by one of the symbol search lookups.
+type SymbolMatch struct {
+	Symbol         string
+	MarketID       int    // Listed market of the symbol, see RequestListedMarkets.
+	Market         string // Short name of MarketID, see MarketName.
+	SecurityTypeID int    // Security type of the symbol, see AssetClass and SecurityTypeName.
+	SIC            int    // SIC code, only set by SearchBySIC.
+	NAICS          int    // NAICS code, only set by SearchByNAICS.
+	Description    string // Company or contract description.
+}
+
+// SearchSymbols searches the symbol database on the lookup port (the SBF command).
+// field is s to search symbols or d to search descriptions, filterType is e to filter on space separated listed market ids or t for security type ids and may be left empty along with filterValue.
+// A search without matches fails with ErrNoData.
+func (c *IQC) SearchSymbols(field string, searchString string, filterType string, filterValue string) ([]SymbolMatch, error) {
+	id := c.incr()
+	cmd := fmt.Sprintf("SBF,%s,%s,%s,%s,%s\r\n", field, searchString, filterType, filterValue, id)
+	return c.searchSymbols(cmd, id, func(m *SymbolMatch, code int) {})
+}
+
+// SearchBySIC returns the symbols whose SIC code starts with prefix (the SBS command).
+func (c *IQC) SearchBySIC(prefix string) ([]SymbolMatch, error) {
+	id := c.incr()
+	return c.searchSymbols(fmt.Sprintf("SBS,%s,%s\r\n", prefix, id), id, func(m *SymbolMatch, code int) { m.SIC = code })
+}
+
+// SearchByNAICS returns the symbols whose NAICS code starts with prefix (the SBN command).
+func (c *IQC) SearchByNAICS(prefix string) ([]SymbolMatch, error) {
+	id := c.incr()
+	return c.searchSymbols(fmt.Sprintf("SBN,%s,%s\r\n", prefix, id), id, func(m *SymbolMatch, code int) { m.NAICS = code })
+}
+
+// searchSymbols runs a symbol search, setCode is called with the industry code column when the command returns one.
+func (c *IQC) searchSymbols(cmd, id string, setCode func(m *SymbolMatch, code int)) ([]SymbolMatch, error) {
+	// SBS and SBN rows carry the industry code between the security type and the description.
+	withCode := !strings.HasPrefix(cmd, "SBF,")
+	var matches []SymbolMatch
+	err := c.lookup(cmd, id, func(items []string) error {
+		cols := 4
+		if withCode {
+			cols = 5
+		}
+		for len(items) < cols {
+			items = append(items, "")
+		}
+		m := SymbolMatch{Symbol: items[0], MarketID: GetIntFromStr(items[1]), SecurityTypeID: GetIntFromStr(items[2])}
+		m.Market, _ = c.MarketName(m.MarketID)
+		desc := items[3:]
+		if withCode {
+			setCode(&m, GetIntFromStr(items[3]))
+			desc = items[4:]
+		}
+		// Descriptions may contain commas of their own.
+		m.Description = strings.TrimSpace(strings.Join(desc, ","))
+		matches = append(matches, m)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return matches, nil
+}
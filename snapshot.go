@@ -0,0 +1,95 @@
+package iqfeed
+
+import "time"
+
+// snapshotResult answers a Snapshot call, with the summary or the error that ended it.
+type snapshotResult struct {
+	summary *UpdSummaryMsg
+	err     error
+}
+
+// Snapshot returns the current summary of symbol without subscribing to it: the symbol is watched until its first summary (P) message arrives and then unwatched again.
+// A symbol that is already watched stays watched and its summary is delivered on Updates as usual, otherwise the summary is only returned here. Concurrent snapshots of the same symbol share one watch.
+// It fails with the feed's *ErrorMsg for an unknown symbol and with ErrTimeout when no summary arrives within ConfirmTimeout. The symbol is checked as the watch methods do, see UppercaseSymbols.
+func (c *IQC) Snapshot(symbol string) (*UpdSummaryMsg, error) {
+	symbol, err := c.normalizeSymbol(symbol)
+	if err != nil {
+		return nil, err
+	}
+	if c.stop == nil {
+		return nil, ErrNotStarted
+	}
+	if c.stopped() {
+		return nil, c.stopErr(ErrClientStopped)
+	}
+	if c.knownNotFound(symbol) {
+		return nil, &ErrorMsg{Symbol: symbol, Message: "Symbol not found", Code: 404, Err: ErrSymbolNotFound}
+	}
+	ch := make(chan snapshotResult, 1)
+	c.watchMu.Lock()
+	if c.snapshotWaiters == nil {
+		c.snapshotWaiters = make(map[string][]chan snapshotResult)
+	}
+	c.snapshotWaiters[symbol] = append(c.snapshotWaiters[symbol], ch)
+	c.watchMu.Unlock()
+	// An unknown symbol isn't watched by the feed so it doesn't need unwatching.
+	watching := true
+	defer func() { c.endSnapshot(symbol, ch, watching) }()
+
+	// Watching a symbol again makes the feed send a fresh summary even when it is already watched.
+	if err := c.send("w" + symbol + "\r\n"); err != nil {
+		return nil, err
+	}
+	select {
+	case r := <-ch:
+		watching = r.err == nil
+		return r.summary, r.err
+	case <-time.After(c.confirmTimeout()):
+		return nil, ErrTimeout
+	case <-c.stop:
+		return nil, c.stopErr(ErrClientStopped)
+	}
+}
+
+// endSnapshot deregisters ch and, when watching is set, unwatches symbol if nothing else needs it.
+func (c *IQC) endSnapshot(symbol string, ch chan snapshotResult, watching bool) {
+	c.watchMu.Lock()
+	waiters := c.snapshotWaiters[symbol]
+	for i, w := range waiters {
+		if w == ch {
+			waiters = append(waiters[:i:i], waiters[i+1:]...)
+			break
+		}
+	}
+	if len(waiters) == 0 {
+		delete(c.snapshotWaiters, symbol)
+	} else {
+		c.snapshotWaiters[symbol] = waiters
+	}
+	_, watched := c.watched[symbol]
+	unwatch := watching && !watched && len(waiters) == 0
+	c.watchMu.Unlock()
+	if unwatch && !c.stopped() {
+		c.send("r" + symbol + "\r\n")
+	}
+}
+
+// deliverSnapshot hands a summary or not found error for symbol to the Snapshot calls waiting for it.
+// It reports whether the symbol is only watched for them, in which case the message isn't sent on the output channels.
+func (c *IQC) deliverSnapshot(symbol string, r snapshotResult) bool {
+	c.watchMu.Lock()
+	defer c.watchMu.Unlock()
+	waiters := c.snapshotWaiters[symbol]
+	if len(waiters) == 0 {
+		return false
+	}
+	for _, w := range waiters {
+		select {
+		case w <- r:
+		default:
+			// Already answered, only the first summary counts.
+		}
+	}
+	_, watched := c.watched[symbol]
+	return !watched
+}
@@ -0,0 +1,87 @@
+package iqfeed
+
+// symbolState is the merged state of a symbol kept for LastQuote.
+type symbolState struct {
+	values map[string]string // The last non empty raw value of every field, keyed by field name.
+	kind   UpdateKind        // The Kind of the last message merged.
+}
+
+// mergeState applies a summary or update message to the cached state of its symbol, see KeepState. A summary replaces the state, an update only overwrites the fields it carries.
+func (c *IQC) mergeState(u *UpdSummaryMsg) {
+	if !c.KeepState && !c.EmitMerged {
+		return
+	}
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	if c.states == nil {
+		c.states = make(map[string]*symbolState)
+	}
+	s, ok := c.states[u.Symbol]
+	if !ok || u.Kind == KindSummary {
+		s = &symbolState{values: make(map[string]string, len(u.Raw))}
+		c.states[u.Symbol] = s
+	}
+	s.kind = u.Kind
+	for k, v := range u.Raw {
+		if name := u.fields[k]; v != "" && name != "" {
+			s.values[name] = v
+		}
+	}
+}
+
+// LastQuote returns the current state of symbol, the last summary message with every update received since merged on top of it so fields an update doesn't repeat keep their last known value.
+// The message is parsed in the current field layout and has the Kind of the last message merged into it. The boolean is false unless KeepState or EmitMerged is set and a message for the symbol has been received since it was watched.
+func (c *IQC) LastQuote(symbol string) (*UpdSummaryMsg, bool) {
+	c.stateMu.Lock()
+	s, ok := c.states[symbol]
+	if !ok {
+		c.stateMu.Unlock()
+		return nil, false
+	}
+	fields, version := c.dynLayout()
+	items := make([]string, len(fields))
+	for k, name := range fields {
+		if k < len(items) {
+			items[k] = s.values[name]
+		}
+	}
+	kind := s.kind
+	c.stateMu.Unlock()
+
+	u := &UpdSummaryMsg{FieldsVersion: version}
+	u.UnMarshall(items, fields, c.TimeLoc)
+	u.Kind = kind
+	c.markHalt(u)
+	c.classify(u)
+	if loc := c.outputLoc(); loc != nil {
+		u.inLoc(c.feedDay(), loc)
+	}
+	return u, true
+}
+
+// deliverMerged sends the current state of the symbol of u, the message just merged, on Merged when EmitMerged is set.
+func (c *IQC) deliverMerged(u *UpdSummaryMsg) {
+	if !c.EmitMerged {
+		return
+	}
+	m, ok := c.LastQuote(u.Symbol)
+	if !ok {
+		return
+	}
+	m.Received = u.Received
+	if !c.divert("Merged", c.Merged, m) {
+		select {
+		case c.Merged <- m:
+		case <-c.stop:
+		}
+	}
+}
+
+// dropState forgets the cached state of symbols that are no longer watched.
+func (c *IQC) dropState(symbols ...string) {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	for _, s := range symbols {
+		delete(c.states, s)
+	}
+}
@@ -0,0 +1,134 @@
+package iqfeed
+
+import "sync"
+
+// subscription is a channel handed out by Subscribe.
+type subscription struct {
+	ch     chan *UpdSummaryMsg
+	done   chan struct{} // Closed by cancel to unblock a send in progress.
+	mu     sync.Mutex    // Held while sending so ch isn't closed under a sender.
+	closed bool
+	once   sync.Once
+}
+
+// subWatch is the watch the first Subscribe of a symbol makes for all of its subscribers.
+type subWatch struct {
+	done  chan struct{} // Closed once the watch has been made, or has failed with err.
+	err   error
+	owned bool // Subscribe watched the symbol itself, it is unwatched when the last subscription is cancelled.
+}
+
+// Subscribe watches symbol in full (like WatchSymbol) and returns a channel delivering only its summary and update messages, along with a func cancelling the subscription.
+// Messages of a subscribed symbol are sent to its subscribers instead of Updates, every subscriber of the symbol gets each of them. The channel holds SubscribeBufferSize messages and is sent to like Updates, see DropPolicy.
+// Concurrent calls for a symbol not subscribed to yet wait for the watch made by the first of them and fail with its error.
+// Cancelling closes the channel and unwatches the symbol once it has no subscribers left, unless it was already watched when it was first subscribed to. It is safe to call more than once. Stop closes the channels of the subscriptions still open.
+func (c *IQC) Subscribe(symbol string) (<-chan *UpdSummaryMsg, func(), error) {
+	symbol, err := c.normalizeSymbol(symbol)
+	if err != nil {
+		return nil, nil, err
+	}
+	size := c.SubscribeBufferSize
+	if size <= 0 {
+		size = defaultSubscribeBufferSize
+	}
+	sub := &subscription{ch: make(chan *UpdSummaryMsg, size), done: make(chan struct{})}
+	c.subsMu.Lock()
+	if c.subs == nil {
+		c.subs = make(map[string][]*subscription)
+		c.subWatches = make(map[string]*subWatch)
+	}
+	w, first := c.subWatches[symbol], false
+	if w == nil {
+		w, first = &subWatch{done: make(chan struct{})}, true
+		c.subWatches[symbol] = w
+	}
+	c.subs[symbol] = append(c.subs[symbol], sub)
+	c.subsMu.Unlock()
+
+	if first {
+		if _, watched := c.WatchModeOf(symbol); !watched {
+			w.err = c.WatchSymbol(symbol)
+			w.owned = w.err == nil
+		}
+		close(w.done)
+	}
+	<-w.done
+	if w.err != nil {
+		c.unsubscribe(symbol, sub, false)
+		return nil, nil, w.err
+	}
+	return sub.ch, func() { c.unsubscribe(symbol, sub, true) }, nil
+}
+
+// unsubscribe removes sub from the subscribers of symbol and closes its channel, unwatching symbol when it was the last one, unwatch is set and Subscribe watched it.
+func (c *IQC) unsubscribe(symbol string, sub *subscription, unwatch bool) {
+	sub.once.Do(func() {
+		c.subsMu.Lock()
+		subs := c.subs[symbol]
+		for i, s := range subs {
+			if s == sub {
+				subs = append(subs[:i:i], subs[i+1:]...)
+				break
+			}
+		}
+		owned := false
+		if len(subs) == 0 {
+			delete(c.subs, symbol)
+			if w := c.subWatches[symbol]; w != nil {
+				owned = w.owned
+			}
+			delete(c.subWatches, symbol)
+		} else {
+			c.subs[symbol] = subs
+		}
+		c.subsMu.Unlock()
+
+		sub.close()
+		if unwatch && owned && !c.stopped() {
+			c.UnwatchSymbol(symbol)
+		}
+	})
+}
+
+// close closes the channel of the subscription once no send to it is in progress.
+func (s *subscription) close() {
+	close(s.done)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.closed {
+		s.closed = true
+		close(s.ch)
+	}
+}
+
+// toSubscribers sends u to the subscribers of its symbol, it reports whether there were any in which case u must not be sent on Updates.
+func (c *IQC) toSubscribers(u *UpdSummaryMsg) bool {
+	c.subsMu.Lock()
+	subs := c.subs[u.Symbol]
+	c.subsMu.Unlock()
+	for _, s := range subs {
+		s.mu.Lock()
+		if !s.closed && !c.overflow("Subscribe", s.ch) {
+			select {
+			case s.ch <- u:
+			case <-s.done:
+			case <-c.stop:
+			}
+		}
+		s.mu.Unlock()
+	}
+	return len(subs) > 0
+}
+
+// closeSubscriptions closes the channel of every subscription still open, Stop calls it once nothing sends to them anymore.
+func (c *IQC) closeSubscriptions() {
+	c.subsMu.Lock()
+	subs := c.subs
+	c.subs, c.subWatches = nil, nil
+	c.subsMu.Unlock()
+	for _, ss := range subs {
+		for _, s := range ss {
+			s.once.Do(s.close)
+		}
+	}
+}
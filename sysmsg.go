@@ -1,11 +1,34 @@
 package iqfeed
 
-import "time"
+import (
+	"strings"
+	"time"
+)
 
 // SystemMessage is the main system message that will be returned and set by the client.
 type SystemMessage struct {
-	Customer CustomerData
-	Stats    SystemStats
+	Type       string // The system message type, the first field after S, (ex: CUST, STATS, KEY).
+	Protocol   string // The negotiated protocol version, set on CURRENT PROTOCOL messages.
+	Symbol     string // The symbol that was dropped, set on SYMBOL LIMIT REACHED messages.
+	Customer   CustomerData
+	Stats      SystemStats
+	FieldNames FieldNamesEvent // The field layout, set on UPDATE FIELDNAMES and CURRENT UPDATE FIELDNAMES messages.
+
+	Received
+}
+
+// FieldNamesEvent is a summary / update field layout reported by the feed, it is sent on System every time the layout is (re)negotiated.
+type FieldNamesEvent struct {
+	Names   []string // The field names in order, as used to parse summary and update messages from then on.
+	Current bool     // True for a CURRENT UPDATE FIELDNAMES message (the layout selected on this connection), false for UPDATE FIELDNAMES.
+}
+
+// newFieldNamesEvent builds the event for a field names message of type typ, without the empty field left by a trailing comma.
+func newFieldNamesEvent(typ string, names []string) FieldNamesEvent {
+	for len(names) > 0 && names[len(names)-1] == "" {
+		names = names[:len(names)-1]
+	}
+	return FieldNamesEvent{Names: append([]string(nil), names...), Current: typ == "CURRENT UPDATE FIELDNAMES"}
 }
 
 // CustomerData is a subset of SystemMessage which is returned when requesting customer data.
@@ -45,10 +68,127 @@ type SystemStats struct {
 	TotalKBsSent           float32   // Found in the “Local Bandwidth” section of the IQFeed Connection Manager. Formula: total bytes sent / 1024
 	KBsPerSecSent          float32   // Found in the “Local Bandwidth” section of the IQFeed Connection Manager. Formula: bytes sent in the past second / 1024
 	AvgKBsPerSecSent       float32   // Found in the “Local Bandwidth” section of the IQFeed Connection Manager. Formula: total KB's sent / total seconds
+
+	Received
 }
 
 // UnMarshall sends the data into the usable struct for consumption by the application.
 func (f *SystemMessage) UnMarshall(d []byte, loc *time.Location) {
-	//fmt.Printf("System Message: %s", string(d))
-	return
+	items := strings.Split(string(d), ",")
+	f.Type = items[0]
+	switch f.Type {
+	case "CUST":
+		f.Customer.UnMarshall(items[1:])
+	case "CURRENT PROTOCOL":
+		if len(items) > 1 {
+			f.Protocol = items[1]
+		}
+	case "STATS":
+		f.Stats.UnMarshall(items[1:], loc)
+	case "SYMBOL LIMIT REACHED":
+		if len(items) > 1 {
+			f.Symbol = items[1]
+		}
+	}
+}
+
+// ServerConnected reports whether IQConnect is connected to the DTN servers (Status is "Connected").
+// The stats don't say whether the market is open, see UpdSummaryMsg.MarketOpen for that.
+func (st *SystemStats) ServerConnected() bool {
+	return st.Status == "Connected"
+}
+
+// UnMarshall populates the stats from the fields following S,STATS.
+func (st *SystemStats) UnMarshall(items []string, loc *time.Location) {
+	for len(items) < 19 {
+		items = append(items, "")
+	}
+	st.ServerIP = items[0]                                // 66.112.156.225,
+	st.ServerPort = GetIntFromStr(items[1])               // 60004,
+	st.MaxSymbols = GetIntFromStr(items[2])               // 500,
+	st.NumberOfSymbols = GetIntFromStr(items[3])          // 3,
+	st.ClientsConnected = GetIntFromStr(items[4])         // 1,
+	st.SecondsSinceLastUpdate = GetIntFromStr(items[5])   // 0,
+	st.Reconnections = GetIntFromStr(items[6])            // 0,
+	st.AttemptedReconnections = GetIntFromStr(items[7])   // 0,
+	st.StartTime = getStatsTime(items[8], loc)            // Mar 14 9:29AM,
+	st.MarketTime = getStatsTime(items[9], loc)           // Mar 14 9:30AM,
+	st.Status = items[10]                                 // Connected,
+	st.IQFeedVersion = items[11]                          // 6.1.0.20,
+	st.LoginID = items[12]                                // 123456,
+	st.TotalKBsRecv = float32(GetFloatFromStr(items[13])) // 1024.51,
+	st.KBsPerSecRecv = float32(GetFloatFromStr(items[14]))
+	st.AvgKBsPerSecRecv = float32(GetFloatFromStr(items[15]))
+	st.TotalKBsSent = float32(GetFloatFromStr(items[16]))
+	st.KBsPerSecSent = float32(GetFloatFromStr(items[17]))
+	st.AvgKBsPerSecSent = float32(GetFloatFromStr(items[18]))
+}
+
+// getStatsTime parses the [short month] [day] [hour]:[minute][AM/PM] times of the stats message, which carry no year so the current one is assumed.
+func getStatsTime(d string, loc *time.Location) time.Time {
+	t, err := time.ParseInLocation("Jan 2 3:04PM", d, loc)
+	if err != nil {
+		return time.Time{}
+	}
+	return t.AddDate(time.Now().In(loc).Year(), 0, 0)
+}
+
+// UnMarshall populates the customer data from the fields following S,CUST.
+func (cd *CustomerData) UnMarshall(items []string) {
+	// Pad out short messages so a truncated line leaves the trailing fields empty rather than panicking.
+	for len(items) < 12 {
+		items = append(items, "")
+	}
+	cd.ServiceType = items[0]                // real_time,
+	cd.IP = items[1]                         // 66.112.156.228,
+	cd.Port = GetIntFromStr(items[2])        // 60002,
+	cd.Token = items[3]                      // 0,
+	cd.Version = items[4]                    // 6.1.0.20,
+	cd.Deprecated1 = GetIntFromStr(items[5]) // 0,
+	cd.VerboseExchanges = items[6]           // AMEX NASDAQ NYSE OPRA ,
+	cd.Deprecated2 = items[7]                // ,
+	cd.MaxSymbols = GetIntFromStr(items[8])  // 500,
+	cd.Flags = items[9]                      // QT_API,
+	cd.Deprecated3 = items[10]               // ,
+	cd.Deprecated4 = items[11]               // ,
+}
+
+// Capabilities describes what the connected account is entitled to, as reported by the S,CUST message during the handshake.
+type Capabilities struct {
+	Known      bool     // Known is false until the S,CUST message has been received.
+	RealTime   bool     // True for real_time service, false when the account only receives delayed data.
+	Exchanges  []string // Exchanges the account receives in real time.
+	MaxSymbols int      // Max number of symbols that can be watched at a time.
+	Flags      []string // Special account flags (ie: NO_EOD / BETA).
+}
+
+// newCapabilities derives the account capabilities from the customer data.
+func newCapabilities(cd CustomerData) Capabilities {
+	return Capabilities{
+		Known:      true,
+		RealTime:   cd.ServiceType == "real_time",
+		Exchanges:  strings.Fields(cd.VerboseExchanges),
+		MaxSymbols: cd.MaxSymbols,
+		Flags:      strings.Fields(cd.Flags),
+	}
+}
+
+// HasExchange reports whether the account receives the named exchange (ex: NYSE, OPRA) in real time.
+func (cp Capabilities) HasExchange(exchange string) bool {
+	for _, e := range cp.Exchanges {
+		if e == exchange {
+			return true
+		}
+	}
+	return false
+}
+
+// HasFlag reports whether the account has the given special flag set (ex: NO_EOD).
+func (cp Capabilities) HasFlag(flag string) bool {
+	for _, f := range cp.Flags {
+		if f == flag {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,140 @@
+package iqfeed
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// symbolThrottle tracks the delivery rate limit for a single symbol.
+type symbolThrottle struct {
+	interval time.Duration
+	last     time.Time
+	items    []string       // The updates suppressed since the last delivery, coalesced into one line.
+	fields   map[int]string // The layout items was parsed with.
+	version  uint64         // The version of fields.
+	quote    *Quote         // The merged quote as of the last suppressed update, when EmitQuotes is set.
+	received Received       // When the last suppressed update was read.
+	timer    *time.Timer    // Set while a trailing delivery of the suppressed updates is scheduled or in progress.
+}
+
+// SetSymbolThrottle limits update (Q) messages for symbol to at most one every minInterval. Updates arriving sooner after the last delivered one are held back and coalesced,
+// the non empty fields of each overwriting the previous ones, and delivered as a single update once the interval has passed so the latest values are never lost.
+// Merged quotes (see EmitQuotes) are throttled the same way, their state still takes every update into account. Summary (P) messages are never throttled. A minInterval of 0 removes the throttle.
+func (c *IQC) SetSymbolThrottle(symbol string, minInterval time.Duration) {
+	c.throttleMu.Lock()
+	defer c.throttleMu.Unlock()
+	if minInterval <= 0 {
+		delete(c.throttles, symbol)
+		return
+	}
+	if c.throttles == nil {
+		c.throttles = make(map[string]*symbolThrottle)
+	}
+	c.throttles[symbol] = &symbolThrottle{interval: minInterval}
+}
+
+// ThrottledUpdates returns the number of updates held back by per symbol throttles.
+func (c *IQC) ThrottledUpdates() uint64 {
+	return atomic.LoadUint64(&c.throttled)
+}
+
+// throttle reports whether the update u received at now should be held back rather than delivered, recording it as delivered otherwise.
+// Held back updates are coalesced and a trailing delivery is scheduled for when the interval expires.
+func (c *IQC) throttle(u *UpdSummaryMsg, items []string, fields map[int]string, version uint64, q *Quote, now time.Time) bool {
+	c.throttleMu.Lock()
+	defer c.throttleMu.Unlock()
+	t, ok := c.throttles[u.Symbol]
+	if !ok {
+		return false
+	}
+	if t.timer == nil && (t.last.IsZero() || now.Sub(t.last) >= t.interval) {
+		t.last = now
+		return false
+	}
+	atomic.AddUint64(&c.throttled, 1)
+	t.coalesce(items, fields, version)
+	t.received = u.Received
+	if q != nil {
+		t.quote = q
+	}
+	if t.timer == nil {
+		c.workers.Add(1)
+		t.timer = time.AfterFunc(t.last.Add(t.interval).Sub(now), func() { c.flushThrottle(t) })
+	}
+	return true
+}
+
+// coalesce overlays the non empty fields of items onto the held back update. When the layout changed the held back fields are first moved to their position in the new one, fields it no longer has are dropped.
+func (t *symbolThrottle) coalesce(items []string, fields map[int]string, version uint64) {
+	if t.items == nil {
+		t.items = append([]string(nil), items...)
+		t.fields, t.version = fields, version
+		return
+	}
+	if t.version != version {
+		t.items = remapItems(t.items, t.fields, fields)
+		t.fields, t.version = fields, version
+	}
+	for len(t.items) < len(items) {
+		t.items = append(t.items, "")
+	}
+	for i, v := range items {
+		if v != "" {
+			t.items[i] = v
+		}
+	}
+}
+
+// remapItems moves the values of a line parsed with the layout from to their positions in the layout to.
+func remapItems(items []string, from, to map[int]string) []string {
+	byName := make(map[string]string, len(items))
+	for i, v := range items {
+		if name, ok := from[i]; ok {
+			byName[name] = v
+		}
+	}
+	out := make([]string, len(to))
+	for i, name := range to {
+		if i >= 0 && i < len(out) {
+			out[i] = byName[name]
+		}
+	}
+	return out
+}
+
+// flushThrottle delivers the updates held back by t as one update, it runs on the throttle's timer.
+func (c *IQC) flushThrottle(t *symbolThrottle) {
+	defer c.workers.Done()
+	c.throttleMu.Lock()
+	items, fields, version, q, received := t.items, t.fields, t.version, t.quote, t.received
+	t.items, t.fields, t.quote = nil, nil, nil
+	c.throttleMu.Unlock()
+
+	if items != nil && !c.stopped() {
+		u := c.parseUpdate(items, fields, version)
+		u.Received = received
+		c.deliverUpdate(u, q)
+	}
+
+	c.throttleMu.Lock()
+	defer c.throttleMu.Unlock()
+	t.last = time.Now()
+	t.timer = nil
+	if t.items != nil && !c.stopped() {
+		// More updates were held back while delivering.
+		c.workers.Add(1)
+		t.timer = time.AfterFunc(t.interval, func() { c.flushThrottle(t) })
+	}
+}
+
+// stopThrottles cancels the scheduled trailing deliveries, Stop calls it so it doesn't have to wait for them.
+func (c *IQC) stopThrottles() {
+	c.throttleMu.Lock()
+	defer c.throttleMu.Unlock()
+	for _, t := range c.throttles {
+		if t.timer != nil && t.timer.Stop() {
+			t.timer = nil
+			c.workers.Done()
+		}
+	}
+}
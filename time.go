@@ -4,11 +4,20 @@ import "time"
 
 // TimeMsg represents a current timestamp from the network.
 type TimeMsg struct {
-	TimeStamp time.Time
+	TimeStamp time.Time // The feed's clock, parsed in TimeLoc (or OutputLoc, see NormalizeToUTC) so it can be compared with time.Now directly. Zero when the message couldn't be parsed.
+	Raw       string    // The timestamp as sent (CCYYMMDD HH:MM:SS).
+
+	Received
 }
 
 // UnMarshall sends the data into the usable struct for consumption by the application.
 func (tm *TimeMsg) UnMarshall(d []byte, loc *time.Location) {
-	t, _ := time.ParseInLocation("20060102 15:04:05", string(d), loc)
+	tm.Raw = string(d)
+	t, _ := time.ParseInLocation("20060102 15:04:05", tm.Raw, loc)
 	tm.TimeStamp = t
 }
+
+// inLoc converts the timestamp on the message to loc.
+func (tm *TimeMsg) inLoc(loc *time.Location) {
+	inLoc(loc, &tm.TimeStamp)
+}
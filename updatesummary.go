@@ -1,6 +1,9 @@
 package iqfeed
 
-import "time"
+import (
+	"strings"
+	"time"
+)
 
 // UpdSummaryMsg is the main struct for both update and summary messages.
 type UpdSummaryMsg struct {
@@ -57,7 +60,7 @@ type UpdSummaryMsg struct {
 	NetAssetValue2         float64   // Undocumented
 	NumTradesToday         int       // The number of trades for the current day.
 	Open                   float64   // The opening price of the day. For commodities this will be the first TRADE of the session.
-	OpenInterest           int       // IEOptions, Futures, FutureOptions, and SSFutures only.
+	OpenInterest           int       // IEOptions, Futures, FutureOptions, and SSFutures only. Always 0 for the other classes once their AssetClass is known.
 	OpenRange1             float64   // For commodities only. Range value for opening trades that aren’t reported individually.
 	OpenRange2             float64   // For commodities only. Range value for opening trades that aren’t reported individually.
 	PcntChange             float64   // (Change / Close)
@@ -66,8 +69,8 @@ type UpdSummaryMsg struct {
 	PERatio                float64   // Real-time calculated PE (Today's Last / Earnings Per Share)
 	Range                  float64   // Trading range for the current day (high - low).
 	RestrictedCode         string    // Short Sale Restricted flag - "N" for Not restricted or "R" for Restricted.
-	Settle                 float64   // Futures or FutureOptions only.
-	SettleDate             time.Time // The date that the Settle is valid for.
+	Settle                 float64   // Futures or FutureOptions only. Always 0 for the other classes once their AssetClass is known.
+	SettleDate             time.Time // The date that the Settle is valid for, zero along with Settle.
 	Spread                 float64   // The difference between Bid and Ask prices
 	Strike                 float64   // The strike price for the option
 	Symbol                 string    // The Symbol ID to match with watch request
@@ -84,6 +87,80 @@ type UpdSummaryMsg struct {
 	Regions                string    // Undocumented
 	TradeTime              time.Time // TradeTime
 
+	// Kind is whether the message is a summary, a trade or a quote update, derived from the message type and Message Contents.
+	Kind UpdateKind
+	// Conditions and Contents are MostRecntTradeCond and MsgContents decoded.
+	Conditions []TradeCondition
+	Contents   MessageContents
+	// MarketOpen is the Market Open field decoded, true while the market is open. Like MktOpen it is only sent for futures and future options.
+	// Halted is set when the Most Recent Trade Conditions contain one of the client's halt conditions, see SetHaltConditions.
+	MarketOpen bool
+	Halted     bool
+	// AssetClass is the security type of the symbol, from its fundamental message. AssetUnknown until that has been received, see AssetClassOf.
+	AssetClass AssetClass
+	// Extra holds the fields of the layout that aren't mapped to one of the fields above, keyed by field name, so fields added to the feed can be read without a package update. Nil when there are none.
+	Extra map[string]string
+	// FieldsVersion identifies the field layout the message was parsed with, it goes up every time the feed sends new field names. Messages are always parsed against the layout in effect when they arrived.
+	FieldsVersion uint64
+	// Raw is every field of the message as sent, in the order of the field layout. Use RawValue or DecimalValue to read a field without float rounding.
+	Raw    []string
+	fields map[int]string // The layout Raw was parsed with, the client replaces layouts rather than modifying them so it is safe to keep.
+
+	Received
+}
+
+// UpdateKind classifies summary and update messages so trades can be told apart from quote churn without re-parsing.
+type UpdateKind int
+
+const (
+	// KindOther is an update that is neither a trade nor a quote change (ex: only the volume or open interest changed), or one received without the Message Contents field.
+	KindOther UpdateKind = iota
+	// KindSummary is a summary message (P), sent when a symbol is first watched or refreshed.
+	KindSummary
+	// KindTrade is an update caused by a trade (Message Contents C, E or O).
+	KindTrade
+	// KindQuote is an update caused by a bid or ask change without a trade (Message Contents b or a).
+	KindQuote
+)
+
+// String returns a readable name for the kind.
+func (k UpdateKind) String() string {
+	switch k {
+	case KindSummary:
+		return "summary"
+	case KindTrade:
+		return "trade"
+	case KindQuote:
+		return "quote"
+	}
+	return "other"
+}
+
+// classifyContents derives the kind of an update from its Message Contents codes, a trade takes precedence over a quote change in the same message.
+func classifyContents(contents string) UpdateKind {
+	switch {
+	case strings.ContainsAny(contents, "CEO"):
+		return KindTrade
+	case strings.ContainsAny(contents, "ba"):
+		return KindQuote
+	}
+	return KindOther
+}
+
+// inLoc converts every timestamp on the message to loc, the time of day only fields are taken to be on day.
+func (u *UpdSummaryMsg) inLoc(day time.Time, loc *time.Location) {
+	inLocClock(day, loc, &u.AskTime, &u.BidTime, &u.ExtendedTrdDate, &u.ExtendedTrdTime, &u.LastDate, &u.LastTime, &u.LastTrdDate,
+		&u.MostRecntTradeDate, &u.MostRecentTradeTime, &u.SettleDate, &u.ExpirationDate, &u.TradeTime)
+}
+
+// ExtendedHours reports whether the most recent trade was an extended hours (Form T) trade. The regular session fields (Last, LastSize, LastTime...) are left as they were by those,
+// only the Most Recent Trade and Extended Trade fields follow them. It is read from Message Contents, messages without it are classified by comparing the most recent trade time to the extended and last ones.
+func (u *UpdSummaryMsg) ExtendedHours() bool {
+	if u.MsgContents != "" {
+		return u.Contents.Extended
+	}
+	t := u.MostRecentTradeTime
+	return !t.IsZero() && t.Equal(u.ExtendedTrdTime) && !t.Equal(u.LastTime)
 }
 
 // UnMarshall sends the data into the usable struct for consumption by the application.
@@ -93,6 +170,7 @@ func (u *UpdSummaryMsg) UnMarshall(items []string, fields map[int]string, loc *t
 	//fmt.Printf("Dyn: %#v\nItems: %#v\n", fields, items)
 	//time.Sleep(50 * time.Millisecond)
 	//fmt.Printf("Unmarshall: %#v\n", items)
+	u.Raw, u.fields = items, fields
 	for k, v := range items {
 
 		switch fields[k] {
@@ -158,8 +236,25 @@ func (u *UpdSummaryMsg) UnMarshall(items []string, fields map[int]string, loc *t
 			u.LastTrdDate = GetDateMMDDCCYY(v, loc)
 		case "(Reserved)":
 			u.Reserved1 = v
-		case "Extended Trading Last":
+		case "Extended Trading Last", "Extended Trade":
 			u.ExtendedTrdLast = GetFloatFromStr(v)
+		case "Extended Trade Size":
+			u.ExtendedTrdSize = GetIntFromStr(v)
+		case "Extended Trade Time":
+			// Sent with microseconds, which the layout without a fraction accepts.
+			u.ExtendedTrdTime = GetTimeInHMS(v, loc)
+		case "Extended Trade Market Center":
+			u.ExtendedTrdMktCntr = GetIntFromStr(v)
+		case "Extended Trade Date":
+			u.ExtendedTrdDate = GetDateMMDDCCYY(v, loc)
+		case "Last Size":
+			u.LastSize = GetIntFromStr(v)
+		case "Last Time":
+			u.LastTime = GetTimeInHMS(v, loc)
+		case "Last Market Center":
+			u.LastMktCntr = GetIntFromStr(v)
+		case "Last Date":
+			u.LastDate = GetDateMMDDCCYY(v, loc)
 		case "Expiration Date":
 			u.ExpirationDate = GetDateMMDDCCYY(v, loc)
 		case "Regional Volume":
@@ -182,6 +277,7 @@ func (u *UpdSummaryMsg) UnMarshall(items []string, fields map[int]string, loc *t
 			u.ChangeFrmOpen = GetFloatFromStr(v)
 		case "Market Open":
 			u.MktOpen = GetIntFromStr(v)
+			u.MarketOpen = u.MktOpen == 1
 		case "Volatility":
 			u.Volatility = GetFloatFromStr(v)
 		case "Market Capitalization":
@@ -230,6 +326,120 @@ func (u *UpdSummaryMsg) UnMarshall(items []string, fields map[int]string, loc *t
 			u.AvailRegions = v
 		case "Type":
 			u.Type = v
+		case "Most Recent Trade":
+			u.MostRecentTrade = GetFloatFromStr(v)
+		case "Most Recent Trade Size":
+			u.MostRecentTradeSize = GetIntFromStr(v)
+		case "Most Recent Trade TimeMS":
+			u.MostRecentTradeTime = GetTimeInHMSmicro(v, loc)
+		case "Most Recent Trade Time":
+			u.MostRecentTradeTime = GetTimeInHMS(v, loc)
+		case "Most Recent Trade Date":
+			u.MostRecntTradeDate = GetDateMMDDCCYY(v, loc)
+		case "Most Recent Trade Market Center":
+			u.MostRecentTradeMktCntr = GetIntFromStr(v)
+		case "Most Recent Trade Conditions":
+			u.MostRecntTradeCond = v
+			u.Conditions = parseTradeConditions(v)
+		case "Message Contents":
+			u.MsgContents = v
+			u.Contents = parseMessageContents(v)
+		default:
+			if name, ok := fields[k]; ok && name != "" {
+				if u.Extra == nil {
+					u.Extra = make(map[string]string)
+				}
+				u.Extra[name] = v
+			}
 		}
 	}
+	u.Kind = classifyContents(u.MsgContents)
+}
+
+// knownUpdateFields is every summary / update field name UnMarshall understands, SelectUpdateFields warns about the others (see StrictFields).
+var knownUpdateFields = map[string]bool{
+	"Symbol":                          true,
+	"Exchange ID":                     true,
+	"Last":                            true,
+	"Change":                          true,
+	"Percent Change":                  true,
+	"Total Volume":                    true,
+	"Incremental Volume":              true,
+	"High":                            true,
+	"Low":                             true,
+	"Bid":                             true,
+	"Ask":                             true,
+	"Bid Size":                        true,
+	"Ask Size":                        true,
+	"Tick":                            true,
+	"Bid Tick":                        true,
+	"Range":                           true,
+	"Last Trade Time":                 true,
+	"Open Interest":                   true,
+	"Open":                            true,
+	"Close":                           true,
+	"Spread":                          true,
+	"Strike":                          true,
+	"Settle":                          true,
+	"Delay":                           true,
+	"Market Center":                   true,
+	"Restricted Code":                 true,
+	"Net Asset Value":                 true,
+	"Average Maturity":                true,
+	"7 Day Yield":                     true,
+	"Last Trade Date":                 true,
+	"(Reserved)":                      true,
+	"Extended Trading Last":           true,
+	"Extended Trade":                  true,
+	"Extended Trade Size":             true,
+	"Extended Trade Time":             true,
+	"Extended Trade Market Center":    true,
+	"Extended Trade Date":             true,
+	"Last Size":                       true,
+	"Last Time":                       true,
+	"Last Market Center":              true,
+	"Last Date":                       true,
+	"Expiration Date":                 true,
+	"Regional Volume":                 true,
+	"Net Asset Value 2":               true,
+	"Extended Trading Change":         true,
+	"Extended Trading Difference":     true,
+	"Price-Earnings Ratio":            true,
+	"Percent Off Average Volume":      true,
+	"Bid Change":                      true,
+	"Ask Change":                      true,
+	"Change From Open":                true,
+	"Market Open":                     true,
+	"Volatility":                      true,
+	"Market Capitalization":           true,
+	"Fraction Display Code":           true,
+	"Decimal Precision":               true,
+	"Days to Expiration":              true,
+	"Previous Day Volume":             true,
+	"Regions":                         true,
+	"Open Range 1":                    true,
+	"Close Range 1":                   true,
+	"Open Range 2":                    true,
+	"Close Range 2":                   true,
+	"Number of Trades Today":          true,
+	"Bid Time":                        true,
+	"Ask Time":                        true,
+	"VWAP":                            true,
+	"TickID":                          true,
+	"Financial Status Indicator":      true,
+	"Settlement Date":                 true,
+	"Trade Market Center":             true,
+	"Bid Market Center":               true,
+	"Ask Market Center":               true,
+	"Trade Time":                      true,
+	"Available Regions":               true,
+	"Type":                            true,
+	"Most Recent Trade":               true,
+	"Most Recent Trade Size":          true,
+	"Most Recent Trade TimeMS":        true,
+	"Most Recent Trade Time":          true,
+	"Most Recent Trade Date":          true,
+	"Most Recent Trade Market Center": true,
+	"Most Recent Trade Conditions":    true,
+	"Message Contents":                true,
 }
@@ -0,0 +1,149 @@
+package iqfeed
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// VerifyDiff describes a line of a backup file that no longer parses the way the golden output recorded it.
+type VerifyDiff struct {
+	Line int    // 1 based line number in the backup file.
+	Raw  string // The raw feed line.
+	Want string // The parsed output stored in the golden file.
+	Got  string // The output of the current parser.
+}
+
+// WriteGolden replays a backup file (as written with CreateBackup) through the parser and stores the parsed output of every line in goldenPath, for later use with Verify.
+// Parsing uses the client's TimeLoc, NormalizeToUTC, OutputLoc and EmitQuotes settings.
+func (c *IQC) WriteGolden(backupPath, goldenPath string) error {
+	parsed, err := c.parseBackup(backupPath)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(goldenPath)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, p := range parsed {
+		fmt.Fprintln(w, p.out)
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Verify replays a backup file through the current parser and reports every line whose parsed output differs from the golden file written by WriteGolden.
+func (c *IQC) Verify(backupPath, goldenPath string) ([]VerifyDiff, error) {
+	parsed, err := c.parseBackup(backupPath)
+	if err != nil {
+		return nil, err
+	}
+	golden, err := readLines(goldenPath)
+	if err != nil {
+		return nil, err
+	}
+	var diffs []VerifyDiff
+	for i, p := range parsed {
+		want := ""
+		if i < len(golden) {
+			want = golden[i]
+		}
+		if p.out != want {
+			diffs = append(diffs, VerifyDiff{Line: i + 1, Raw: p.raw, Want: want, Got: p.out})
+		}
+	}
+	return diffs, nil
+}
+
+// parsedLine is a raw backup line along with the formatted messages it produced.
+type parsedLine struct {
+	raw string
+	out string
+}
+
+// parseBackup runs every line of a backup file through an offline copy of the client and formats the resulting messages.
+func (c *IQC) parseBackup(path string) ([]parsedLine, error) {
+	lines, err := readLines(path)
+	if err != nil {
+		return nil, err
+	}
+	o := c.offline()
+	parsed := make([]parsedLine, 0, len(lines))
+	for _, l := range lines {
+		o.processReceiver([]byte(l))
+		parsed = append(parsed, parsedLine{raw: l, out: o.drain()})
+	}
+	return parsed, nil
+}
+
+// offline returns a client sharing the parse settings of c which isn't connected to anything, with channels large enough to hold everything a single line can produce.
+func (c *IQC) offline() *IQC {
+	loc := c.TimeLoc
+	if loc == nil {
+		loc = time.UTC
+	}
+	o := &IQC{TimeLoc: loc, NormalizeToUTC: c.NormalizeToUTC, OutputLoc: c.OutputLoc, EmitQuotes: c.EmitQuotes, DynFields: make(map[int]string), unstamped: true}
+	size := maxPendingUpdates + 1
+	o.System = make(chan *SystemMessage, size)
+	o.News = make(chan *NewsMsg, size)
+	o.Errors = make(chan *ErrorMsg, size)
+	o.Fundamental = make(chan *FundamentalMsg, size)
+	o.Regional = make(chan *RegionalMsg, size)
+	o.Time = make(chan *TimeMsg, size)
+	o.Updates = make(chan *UpdSummaryMsg, size)
+	o.Quotes = make(chan *Quote, size)
+	return o
+}
+
+// drain empties every channel of an offline client and formats the messages found on a single line, separated by tabs.
+func (c *IQC) drain() string {
+	var out []string
+	add := func(v interface{}) { out = append(out, fmt.Sprintf("%T%+v", v, v)) }
+	for len(c.System) > 0 {
+		add(<-c.System)
+	}
+	for len(c.News) > 0 {
+		add(<-c.News)
+	}
+	for len(c.Errors) > 0 {
+		add(<-c.Errors)
+	}
+	for len(c.Fundamental) > 0 {
+		add(<-c.Fundamental)
+	}
+	for len(c.Regional) > 0 {
+		add(<-c.Regional)
+	}
+	for len(c.Time) > 0 {
+		add(<-c.Time)
+	}
+	for len(c.Updates) > 0 {
+		add(<-c.Updates)
+	}
+	for len(c.Quotes) > 0 {
+		add(<-c.Quotes)
+	}
+	return strings.Join(out, "\t")
+}
+
+// readLines returns the lines of a file without their line terminators, gzipped files are decompressed.
+func readLines(path string) ([]string, error) {
+	f, err := openBackupFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var lines []string
+	s := bufio.NewScanner(f)
+	s.Buffer(make([]byte, 64*1024), 1024*1024)
+	for s.Scan() {
+		lines = append(lines, strings.TrimRight(s.Text(), "\r"))
+	}
+	return lines, s.Err()
+}
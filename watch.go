@@ -0,0 +1,397 @@
+package iqfeed
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// WatchMode selects how much data a watched symbol streams, see WatchWith.
+type WatchMode int
+
+const (
+	// WatchModeFull streams every summary and update message for the symbol (the w command).
+	WatchModeFull WatchMode = iota
+	// WatchModeTrades streams trade updates only (the t command).
+	WatchModeTrades
+	// WatchModeSnapshotThenTrades watches the symbol in full until the fundamental and first summary have arrived, then downgrades to trades only.
+	WatchModeSnapshotThenTrades
+	// WatchModeSnapshot watches the symbol until the fundamental and first summary have arrived, then unwatches it. Use it for symbols only needed for reference data.
+	WatchModeSnapshot
+)
+
+// snapshotState tracks which of the initial messages have arrived for a symbol watched with one of the snapshot modes.
+type snapshotState struct {
+	mode        WatchMode // The snapshot mode, set when the symbol is registered since the initial messages may arrive before it is marked as watched.
+	fundamental bool
+	summary     bool
+}
+
+// WatchWith watches a symbol in the given mode. The mode is remembered per symbol so the subscription can be replayed.
+func (c *IQC) WatchWith(symbol string, mode WatchMode) error {
+	return c.watch(symbol, mode)
+}
+
+// WatchSymbolContents watches a symbol in full like WatchSymbol but only delivers its update messages that have at least one of the flags set in contents (ex: MessageContents{LastQualified: true, Extended: true, OtherTrade: true} for trades only),
+// the others are dropped before they reach Updates, Quotes or the symbol's state. Summary messages are always delivered. A zero contents removes the filter.
+// Requires Message Contents in the field selection, without it every update is dropped. The filter is removed when the symbol is watched again some other way or unwatched, Pause included.
+func (c *IQC) WatchSymbolContents(symbol string, contents MessageContents) error {
+	symbol, err := c.normalizeSymbol(symbol)
+	if err != nil {
+		return err
+	}
+	if err := c.watch(symbol, WatchModeFull); err != nil {
+		return err
+	}
+	c.watchMu.Lock()
+	defer c.watchMu.Unlock()
+	if contents == (MessageContents{}) {
+		delete(c.contentFilters, symbol)
+		return nil
+	}
+	if c.contentFilters == nil {
+		c.contentFilters = make(map[string]MessageContents)
+	}
+	c.contentFilters[symbol] = contents
+	return nil
+}
+
+// contentsWanted reports whether the update u passes the content filter of its symbol, see WatchSymbolContents.
+func (c *IQC) contentsWanted(u *UpdSummaryMsg) bool {
+	c.watchMu.Lock()
+	want, ok := c.contentFilters[u.Symbol]
+	c.watchMu.Unlock()
+	return !ok || u.Contents.Any(want)
+}
+
+// normalizeSymbol trims the spaces around symbol, upper cases it when UppercaseSymbols is set and checks it can be sent in a command.
+// IQFeed symbols are case and whitespace sensitive, a symbol that doesn't match exactly is silently never streamed, so anything that can't be one fails with ErrInvalidSymbol.
+func (c *IQC) normalizeSymbol(symbol string) (string, error) {
+	s := strings.TrimSpace(symbol)
+	if c.UppercaseSymbols {
+		s = strings.ToUpper(s)
+	}
+	if s == "" || strings.ContainsAny(s, ", \t\r\n") {
+		return "", fmt.Errorf("%w: %q", ErrInvalidSymbol, symbol)
+	}
+	return s, nil
+}
+
+// watch sends the watch command for mode and records the symbol as watched, see normalizeSymbol for the checks made on symbol first.
+func (c *IQC) watch(symbol string, mode WatchMode) error {
+	symbol, err := c.normalizeSymbol(symbol)
+	if err != nil {
+		return err
+	}
+	if c.knownNotFound(symbol) {
+		return &ErrorMsg{Symbol: symbol, Message: "Symbol not found", Code: 404, Err: ErrSymbolNotFound}
+	}
+	if err := c.checkSymbolLimit(symbol); err != nil {
+		return err
+	}
+	cmd := "w"
+	if mode == WatchModeTrades {
+		cmd = "t"
+	}
+	snapshot := mode == WatchModeSnapshot || mode == WatchModeSnapshotThenTrades
+	if snapshot {
+		// Registered before sending so the initial messages can't beat us to it.
+		c.watchMu.Lock()
+		if c.snapshots == nil {
+			c.snapshots = make(map[string]*snapshotState)
+		}
+		c.snapshots[symbol] = &snapshotState{mode: mode}
+		c.watchMu.Unlock()
+	}
+	if err := c.send(cmd + symbol + "\r\n"); err != nil {
+		if snapshot {
+			c.watchMu.Lock()
+			delete(c.snapshots, symbol)
+			c.watchMu.Unlock()
+		}
+		return err
+	}
+	c.markWatched(mode, symbol)
+	return nil
+}
+
+// WatchSymbols watches every symbol in full (like WatchSymbol) with a single write, skipping duplicates and symbols that are already watched or cached as not found.
+// The commands are pipelined: nothing waits on the feed between them, so subscribing to a large universe costs no more than one round trip. Nothing is watched when one of the symbols is invalid.
+func (c *IQC) WatchSymbols(symbols []string) error {
+	normalized := make([]string, 0, len(symbols))
+	for _, s := range symbols {
+		n, err := c.normalizeSymbol(s)
+		if err != nil {
+			return err
+		}
+		normalized = append(normalized, n)
+	}
+	c.watchMu.Lock()
+	seen := make(map[string]bool, len(normalized))
+	batch := make([]string, 0, len(normalized))
+	for _, s := range normalized {
+		if _, ok := c.watched[s]; ok || seen[s] {
+			continue
+		}
+		seen[s] = true
+		batch = append(batch, s)
+	}
+	c.watchMu.Unlock()
+
+	var cmds strings.Builder
+	added := batch[:0]
+	for _, s := range batch {
+		if c.knownNotFound(s) {
+			continue
+		}
+		cmds.WriteString("w" + s + "\r\n")
+		added = append(added, s)
+	}
+	if len(added) == 0 {
+		return nil
+	}
+	if err := c.checkSymbolLimit(added...); err != nil {
+		return err
+	}
+	if err := c.send(cmds.String()); err != nil {
+		return err
+	}
+	c.markWatched(WatchModeFull, added...)
+	return nil
+}
+
+// UnwatchAll unwatches every symbol in the watched set with a single write of their r commands, the set is cleared even when the write fails.
+// Unlike UnwatchAllSymbols it only touches the symbols this client watched.
+func (c *IQC) UnwatchAll() error {
+	c.watchMu.Lock()
+	symbols := make([]string, 0, len(c.watched))
+	for s := range c.watched {
+		symbols = append(symbols, s)
+	}
+	c.watchMu.Unlock()
+	if len(symbols) == 0 {
+		return nil
+	}
+
+	sort.Strings(symbols)
+	var cmds strings.Builder
+	for _, s := range symbols {
+		cmds.WriteString("r" + s + "\r\n")
+	}
+	err := c.send(cmds.String())
+	c.markUnwatched(symbols...)
+	return err
+}
+
+// checkSymbolLimit returns an ErrSymbolLimit error when watching the symbols would take the watched set past MaxSymbols, symbols already watched don't count.
+// Concurrent watches are checked independently so they may still overshoot the limit by the number of watches in flight.
+func (c *IQC) checkSymbolLimit(symbols ...string) error {
+	if c.MaxSymbols <= 0 {
+		return nil
+	}
+	c.watchMu.Lock()
+	n := len(c.watched)
+	for _, s := range symbols {
+		if _, ok := c.watched[s]; !ok {
+			n++
+		}
+	}
+	c.watchMu.Unlock()
+	if n <= c.MaxSymbols {
+		return nil
+	}
+	e := &ErrorMsg{Message: fmt.Sprintf("Symbol limit of %d reached", c.MaxSymbols), Code: 429, Err: ErrSymbolLimit}
+	if len(symbols) == 1 {
+		e.Symbol = symbols[0]
+	}
+	return e
+}
+
+// symbolLimitMsg handles the feed dropping a watch because the plan's symbol limit was reached, the symbol is reported on Errors and removed from the watched set.
+func (c *IQC) symbolLimitMsg(symbol, raw string) {
+	c.markUnwatched(symbol)
+	e := &ErrorMsg{Symbol: symbol, Message: "Symbol limit reached", Code: 429, Err: ErrSymbolLimit, Raw: raw, Received: c.received()}
+	if !c.divert("Errors", c.Errors, e) {
+		select {
+		case c.Errors <- e:
+		case <-c.stop:
+		}
+	}
+}
+
+// WatchModeOf returns the mode a symbol is currently watched in, the boolean is false when it isn't watched.
+func (c *IQC) WatchModeOf(symbol string) (WatchMode, bool) {
+	c.watchMu.Lock()
+	defer c.watchMu.Unlock()
+	m, ok := c.watched[symbol]
+	return m, ok
+}
+
+// WatchedSymbols returns a sorted snapshot of the symbols currently watched on Level 1, in any mode. These are the symbols subscribed again after a reconnect.
+func (c *IQC) WatchedSymbols() []string {
+	c.watchMu.Lock()
+	symbols := make([]string, 0, len(c.watched))
+	for s := range c.watched {
+		symbols = append(symbols, s)
+	}
+	c.watchMu.Unlock()
+	sort.Strings(symbols)
+	return symbols
+}
+
+// snapshotReceived records the arrival of a fundamental or summary message for a symbol watched in a snapshot mode and downgrades its subscription once both have arrived.
+func (c *IQC) snapshotReceived(symbol string, fundamental bool) {
+	c.watchMu.Lock()
+	s, ok := c.snapshots[symbol]
+	if !ok {
+		c.watchMu.Unlock()
+		return
+	}
+	if fundamental {
+		s.fundamental = true
+	} else {
+		s.summary = true
+	}
+	done := s.fundamental && s.summary
+	if done {
+		delete(c.snapshots, symbol)
+	}
+	c.watchMu.Unlock()
+	if !done {
+		return
+	}
+
+	if s.mode == WatchModeSnapshotThenTrades {
+		c.TradeOnlyWatch(symbol)
+		return
+	}
+	c.UnwatchSymbol(symbol)
+}
+
+// markWatched records the symbols as watched in mode and reports the ones that were not already watched to OnWatchChange.
+func (c *IQC) markWatched(mode WatchMode, symbols ...string) {
+	c.watchMu.Lock()
+	if c.watched == nil {
+		c.watched = make(map[string]WatchMode)
+	}
+	var added []string
+	for _, s := range symbols {
+		if _, waiting := c.snapshots[s]; !waiting && (mode == WatchModeSnapshot || mode == WatchModeSnapshotThenTrades) {
+			// The initial messages beat the watch to it, the symbol was already unwatched or downgraded to trades only.
+			continue
+		}
+		if _, ok := c.watched[s]; !ok {
+			added = append(added, s)
+		}
+		c.watched[s] = mode
+		delete(c.contentFilters, s)
+		if mode == WatchModeFull || mode == WatchModeTrades {
+			delete(c.snapshots, s)
+		}
+	}
+	cb := c.OnWatchChange
+	c.watchMu.Unlock()
+
+	// The callback runs outside of the lock so it is free to call back into the client.
+	if cb != nil && len(added) > 0 {
+		cb(added, nil)
+	}
+}
+
+// markUnwatched removes the symbols from the watched set and reports the ones that were actually watched to OnWatchChange.
+func (c *IQC) markUnwatched(symbols ...string) {
+	c.watchMu.Lock()
+	var removed []string
+	for _, s := range symbols {
+		delete(c.snapshots, s)
+		delete(c.contentFilters, s)
+		delete(c.refreshes, s)
+		if _, ok := c.watched[s]; ok {
+			delete(c.watched, s)
+			removed = append(removed, s)
+		}
+	}
+	cb := c.OnWatchChange
+	c.watchMu.Unlock()
+
+	c.dropState(symbols...)
+	if cb != nil && len(removed) > 0 {
+		cb(nil, removed)
+	}
+}
+
+// markAllUnwatched clears the watched set, reporting every symbol that was removed (in sorted order) to OnWatchChange.
+func (c *IQC) markAllUnwatched() {
+	c.watchMu.Lock()
+	removed := make([]string, 0, len(c.watched))
+	for s := range c.watched {
+		removed = append(removed, s)
+	}
+	c.watched = nil
+	c.snapshots = nil
+	c.contentFilters = nil
+	c.refreshes = nil
+	cb := c.OnWatchChange
+	c.watchMu.Unlock()
+
+	sort.Strings(removed)
+	c.dropState(removed...)
+	if cb != nil && len(removed) > 0 {
+		cb(nil, removed)
+	}
+}
+
+// Pause unwatches every watched symbol while keeping them, with their watch mode, in a paused set so Resume can watch them again without the caller tracking its watchlist.
+// Paused symbols are no longer in the watched set, so they stay paused rather than being subscribed again if the connection is re-established.
+func (c *IQC) Pause() error {
+	c.watchMu.Lock()
+	if c.paused == nil {
+		c.paused = make(map[string]WatchMode)
+	}
+	for s, m := range c.watched {
+		c.paused[s] = m
+	}
+	c.watchMu.Unlock()
+
+	if err := c.send("S,UNWATCH ALL\r\n"); err != nil {
+		return err
+	}
+	c.markAllUnwatched()
+	return nil
+}
+
+// Resume watches every symbol unwatched by Pause again in its original watch mode.
+func (c *IQC) Resume() error {
+	c.watchMu.Lock()
+	paused := c.paused
+	c.paused = nil
+	c.watchMu.Unlock()
+
+	symbols := make([]string, 0, len(paused))
+	for s := range paused {
+		symbols = append(symbols, s)
+	}
+	sort.Strings(symbols)
+	for i, s := range symbols {
+		if err := c.watch(s, paused[s]); err != nil {
+			// Keep whatever wasn't watched again paused so a later Resume can retry it.
+			c.watchMu.Lock()
+			if c.paused == nil {
+				c.paused = make(map[string]WatchMode)
+			}
+			for _, r := range symbols[i:] {
+				c.paused[r] = paused[r]
+			}
+			c.watchMu.Unlock()
+			return err
+		}
+	}
+	return nil
+}
+
+// Paused reports whether there are symbols paused by Pause waiting for Resume.
+func (c *IQC) Paused() bool {
+	c.watchMu.Lock()
+	defer c.watchMu.Unlock()
+	return len(c.paused) > 0
+}
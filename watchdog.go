@@ -0,0 +1,90 @@
+package iqfeed
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// minWatchdogInterval bounds how often the watchdog checks the feed when StaleTimeout is very short.
+const minWatchdogInterval = 10 * time.Millisecond
+
+// touch records that something was just received from the feed.
+func (c *IQC) touch() {
+	atomic.StoreInt64(&c.lastRecv, time.Now().UnixNano())
+}
+
+// LastReceived returns when the last message of any type was received, the zero time before the first one.
+func (c *IQC) LastReceived() time.Time {
+	n := atomic.LoadInt64(&c.lastRecv)
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n)
+}
+
+// pingInterval is how often Ping checks whether the feed has answered.
+const pingInterval = 5 * time.Millisecond
+
+// Connected reports whether the connection to IQFeed is up, it is false before Start, while reconnecting and once stopped.
+// See ServerConnected for IQConnect's own link to the DTN servers.
+func (c *IQC) Connected() bool {
+	return atomic.LoadInt32(&c.connected) == 1 && !c.stopped()
+}
+
+// Ping checks that the feed is alive by requesting a timestamp (the T command) and waiting up to timeout for anything to be received, without subscribing to a symbol.
+// It returns ErrTimeout when nothing arrives in time. The timestamp is delivered on Time as usual.
+func (c *IQC) Ping(timeout time.Duration) error {
+	if c.stop == nil {
+		return ErrNotStarted
+	}
+	sent := time.Now()
+	if err := c.send("T\r\n"); err != nil {
+		return err
+	}
+	t := time.NewTicker(pingInterval)
+	defer t.Stop()
+	deadline := time.After(timeout)
+	for !c.LastReceived().After(sent) {
+		select {
+		case <-t.C:
+		case <-deadline:
+			return ErrTimeout
+		case <-c.stop:
+			return c.stopErr(ErrClientStopped)
+		}
+	}
+	return nil
+}
+
+// watchdog reports the feed as stale with a StateStale event when nothing, not even the once per second timestamp, has been received for StaleTimeout.
+// With ReconnectOnStale the connection is also closed so the read goroutine reconnects it when ReconnectEnabled is set.
+// The event is sent once per stale period, it is sent again only after data has been received in between.
+func (c *IQC) watchdog() {
+	defer c.workers.Done()
+	interval := c.StaleTimeout / 4
+	if interval < minWatchdogInterval {
+		interval = minWatchdogInterval
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	reported := int64(-1)
+	for {
+		select {
+		case <-t.C:
+		case <-c.done:
+			return
+		}
+		last := atomic.LoadInt64(&c.lastRecv)
+		since := time.Since(time.Unix(0, last))
+		if since < c.StaleTimeout || last == reported {
+			continue
+		}
+		reported = last
+		c.log().Warnf("No data received for %s", since.Truncate(time.Millisecond))
+		c.event(StateStale, 0, fmt.Errorf("iqfeed: no data received for %s", since.Truncate(time.Millisecond)))
+		if c.ReconnectOnStale {
+			c.conn().Close()
+		}
+	}
+}
@@ -2,86 +2,133 @@ package iqfeed
 
 import (
 	"fmt"
-	"math"
-	"os"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
-// Write performs a write on the channel data which will be picked up by the writer concurrently and written to iqfeed.
+// Write writes a command to iqfeed, it is safe to call from multiple goroutines.
 func (c *IQC) Write(data string) {
-	c.Conn.Write([]byte(data))
+	c.send(data)
 }
 
-// WriteBackup does as the name suggests and write the []byte data directly to a file for re-use later.
-func (c *IQC) writeBackup(d []byte) {
-	if !c.CreateBackup {
-		return
-	}
-	if _, err := os.Stat(c.BackupFile); os.IsNotExist(err) {
-		os.Create(c.BackupFile)
-	}
-	f, err := os.OpenFile(c.BackupFile, os.O_APPEND|os.O_RDWR, 0644)
-	if err != nil {
-		fmt.Printf("Could not open file for writing: %s\n", err.Error())
-		return
-	}
-	defer f.Close()
-	_, err = f.Write(d)
-	if err != nil {
-		fmt.Printf("Could not write data to file: %s\n", err.Error())
-		return
+// send writes a command to the feed and returns any error from the connection.
+// Every outbound command goes through here, writes are serialized so commands sent from several goroutines can't interleave on the socket.
+func (c *IQC) send(data string) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.lastCommand.Store(strings.TrimRight(data, "\r\n"))
+	_, err := c.conn().Write([]byte(data))
+	return err
+}
+
+// SendRaw writes a raw command to the feed, the line terminator is added when missing. Malformed commands are reported on the Errors channel as ErrSyntaxError.
+func (c *IQC) SendRaw(cmd string) {
+	if !strings.HasSuffix(cmd, "\r\n") {
+		cmd += "\r\n"
 	}
+	c.Write(cmd)
 }
 
-// SetProtocol Changes the current connection's protocol (ex: 5.2).
-func (c *IQC) SetProtocol(protocol string) {
-	c.Write("S,SET PROTOCOL," + protocol + "\r\n")
+// SetProtocol Changes the current connection's protocol (ex: 6.2).
+// It blocks until the feed confirms the version with a CURRENT PROTOCOL message or returns ErrTimeout after ConfirmTimeout.
+func (c *IQC) SetProtocol(protocol string) error {
+	w, done := c.awaitProtocol()
+	defer done()
+	if err := c.send("S,SET PROTOCOL," + protocol + "\r\n"); err != nil {
+		return err
+	}
+
+	timeout := time.After(c.confirmTimeout())
+	for {
+		select {
+		case v := <-w:
+			if v == protocol {
+				return nil
+			}
+		case <-timeout:
+			return ErrTimeout
+		case <-c.stop:
+			return c.stopErr(ErrClientStopped)
+		}
+	}
 }
 
 // SetClientName does as the name implies and sets the client message which will also be available in stats.
+// The name identifies the connection in IQConnect's diagnostics, it is sent again after a reconnect. See ClientName to set it when starting.
 func (c *IQC) SetClientName(name string) {
+	c.clientName.Store(name)
 	c.Write("S,SET CLIENT NAME," + name + "\r\n")
 }
 
 // WatchSymbol will issue a command to start watching a symbol, this will return a fundamental and update message with the quotes.
-func (c *IQC) WatchSymbol(symbol string) {
-	c.Write("w" + symbol + "\r\n")
+// A symbol recently reported as not found fails with ErrSymbolNotFound without being sent, see NotFoundTTL. Spaces around symbol are trimmed (see UppercaseSymbols for its case),
+// an empty symbol or one containing spaces or commas fails with ErrInvalidSymbol.
+func (c *IQC) WatchSymbol(symbol string) error {
+	return c.watch(symbol, WatchModeFull)
 }
 
 // WatchOptionSymbol tracks a new symbol based on contract date (for option chains), contractDate indicates the date for the option contract and isCall indicates whether it is a call / put contract.
-func (c *IQC) WatchOptionSymbol(symbol string, value float64, contractDate time.Time, isCall bool) string {
-	// Final format should be something like: MSFT1220J30.5
-	ydm := contractDate.Format("0602")
-	_, rem := math.Modf(value)
-	pv := fmt.Sprintf("%.0f", value)
-	if rem > 0 {
-		pv = fmt.Sprintf("%.1f", value)
-	}
-
-	if isCall {
-		ydm = ydm + c.getCallChar(contractDate)
-	} else {
-		ydm = ydm + c.getPutChar(contractDate)
-	}
-	tSym := fmt.Sprintf("%s%s%s", symbol, ydm, pv)
-	c.WatchSymbol(tSym)
-	return tSym
+// The symbol watched is built by BuildOptionSymbol and returned along with the error of WatchSymbol.
+func (c *IQC) WatchOptionSymbol(symbol string, value float64, contractDate time.Time, isCall bool) (string, error) {
+	tSym := c.BuildOptionSymbol(symbol, contractDate, value, isCall)
+	return tSym, c.WatchSymbol(tSym)
 }
 
 // TradeOnlyWatch Begins a trades only watch on a symbol for Level 1 updates.
-func (c *IQC) TradeOnlyWatch(symbol string) {
-	c.Write("t" + symbol + "\r\n")
+func (c *IQC) TradeOnlyWatch(symbol string) error {
+	return c.watch(symbol, WatchModeTrades)
+}
+
+// WatchTradesOnly is the same as TradeOnlyWatch, it begins a trades only watch (the t command) on a symbol.
+func (c *IQC) WatchTradesOnly(symbol string) error {
+	return c.watch(symbol, WatchModeTrades)
 }
 
 // UnwatchSymbol Terminates Level 1 updates for the symbol specified.
-func (c *IQC) UnwatchSymbol(symbol string) {
-	c.Write("r" + symbol + "\r\n")
+// The symbol is removed from the watched set even when the write fails so it isn't subscribed again on reconnect.
+func (c *IQC) UnwatchSymbol(symbol string) error {
+	symbol, err := c.normalizeSymbol(symbol)
+	if err != nil {
+		return err
+	}
+	err = c.send("r" + symbol + "\r\n")
+	c.markUnwatched(symbol)
+	return err
 }
 
-// ForceRefresh Forces a refresh from the server for the symbol specified.
+// ForceRefresh Forces a refresh from the server for the symbol specified, see RefreshFundamental.
 func (c *IQC) ForceRefresh(symbol string) {
-	c.Write("f" + symbol + "\r\n")
+	c.RefreshFundamental(symbol)
+}
+
+// RefreshFundamental asks the feed to send the fundamental message of a watched symbol again (the f command), ex: after a corporate action. It arrives on Fundamental with Refreshed set,
+// followed by a fresh summary on Updates. The symbol is checked like WatchSymbol does.
+func (c *IQC) RefreshFundamental(symbol string) error {
+	symbol, err := c.normalizeSymbol(symbol)
+	if err != nil {
+		return err
+	}
+	c.watchMu.Lock()
+	if c.refreshes == nil {
+		c.refreshes = make(map[string]bool)
+	}
+	c.refreshes[symbol] = true
+	c.watchMu.Unlock()
+	if err := c.send("f" + symbol + "\r\n"); err != nil {
+		c.refreshed(symbol)
+		return err
+	}
+	return nil
+}
+
+// refreshed reports whether a fundamental message for symbol answers RefreshFundamental, clearing the pending refresh.
+func (c *IQC) refreshed(symbol string) bool {
+	c.watchMu.Lock()
+	defer c.watchMu.Unlock()
+	ok := c.refreshes[symbol]
+	delete(c.refreshes, symbol)
+	return ok
 }
 
 // RequestTime Requests a Time Stamp message be sent.
@@ -89,24 +136,73 @@ func (c *IQC) RequestTime() {
 	c.Write("T\r\n")
 }
 
-// DisableTSUpdates Disables once per second timestamps
+// DisableTSUpdates Disables once per second timestamps, see DisableTimestamps.
 func (c *IQC) DisableTSUpdates() {
-	c.Write("S,TIMESTAMPSOFF\r\n")
+	c.DisableTimestamps()
 }
 
 // EnableTSUpdates Timestamps default to on, but in the event you have stopped them manually, this will restart them into the stream.
 func (c *IQC) EnableTSUpdates() {
-	c.Write(fmt.Sprintf("S,TIMESTAMPSON,%s\r\n", c.incr()))
+	c.EnableTimestamps()
 }
 
-// RegionWatch Begins watching a symbol for Level 1 Regional updates.
+// DisableTimestamps stops the once per second T messages on the Time channel, the setting is kept across reconnects.
+// With timestamps off FeedTime is no longer updated every second and a quiet feed sends nothing at all, so anything detecting a stale connection has to rely on other traffic.
+func (c *IQC) DisableTimestamps() error {
+	if err := c.send("S,TIMESTAMPSOFF\r\n"); err != nil {
+		return err
+	}
+	atomic.StoreInt32(&c.timestampsOff, 1)
+	return nil
+}
+
+// EnableTimestamps restarts the once per second T messages after DisableTimestamps, they are on by default.
+func (c *IQC) EnableTimestamps() error {
+	if err := c.send("S,TIMESTAMPSON\r\n"); err != nil {
+		return err
+	}
+	atomic.StoreInt32(&c.timestampsOff, 0)
+	return nil
+}
+
+// RegionWatch Begins watching a symbol for Level 1 Regional updates, see WatchRegional.
 func (c *IQC) RegionWatch(symbol string) {
-	c.Write("S,REGON," + symbol + "\r\n")
+	c.WatchRegional(symbol)
 }
 
-// RegionWatchOff Stops watching a symbol for Level 1 Regional updates.
+// RegionWatchOff Stops watching a symbol for Level 1 Regional updates, see UnwatchRegional.
 func (c *IQC) RegionWatchOff(symbol string) {
-	c.Write("S,REGOFF," + symbol + "\r\n")
+	c.UnwatchRegional(symbol)
+}
+
+// WatchRegional begins sending the regional quotes of a symbol on the Regional channel (the S,REGON command). Regional watches are replayed on reconnect.
+func (c *IQC) WatchRegional(symbol string) error {
+	symbol, err := c.normalizeSymbol(symbol)
+	if err != nil {
+		return err
+	}
+	if err := c.send("S,REGON," + symbol + "\r\n"); err != nil {
+		return err
+	}
+	c.watchMu.Lock()
+	if c.regional == nil {
+		c.regional = make(map[string]bool)
+	}
+	c.regional[symbol] = true
+	c.watchMu.Unlock()
+	return nil
+}
+
+// UnwatchRegional stops the regional quotes of a symbol (the S,REGOFF command), it is forgotten even when the write fails.
+func (c *IQC) UnwatchRegional(symbol string) error {
+	symbol, err := c.normalizeSymbol(symbol)
+	if err != nil {
+		return err
+	}
+	c.watchMu.Lock()
+	delete(c.regional, symbol)
+	c.watchMu.Unlock()
+	return c.send("S,REGOFF," + symbol + "\r\n")
 }
 
 // NewsOn Turns on streaming news headlines.
@@ -119,7 +215,7 @@ func (c *IQC) NewsOff() {
 	c.Write("S,NEWSOFF\r\n")
 }
 
-// RequestStats Request a S,STATS message to give you information about the feed status.
+// RequestStats Request a S,STATS message to give you information about the feed status, the reply is sent on both System and Stats.
 func (c *IQC) RequestStats() {
 	c.Write(fmt.Sprintf("S,REQUEST STATS\r\n"))
 }
@@ -134,25 +230,99 @@ func (c *IQC) ReqAllUpdateFNames() {
 	c.Write("S,REQUEST ALL UPDATE FIELDNAMES\r\n")
 }
 
-// ReqCurrentUpdateFNames Request a list of field names in the current fieldset for this connection.\
+// ReqCurrentUpdateFNames Request a list of field names in the current fieldset for this connection.
 // Result: You will receive a S,CURRENT UPDATE FIELDNAMES,[FIELD 1 NAME],[FIELD 2 NAME],...[FIELD N NAME],<LF> message that contains currently selected summary/update fields.
-func (c *IQC) ReqCurrentUpdateFNames() {
-	c.Write("S,REQUEST CURRENT UPDATE FIELDNAMES\r\n")
+// It blocks until the layout has arrived and summary / update messages can be parsed against it, or returns ErrTimeout after ConfirmTimeout.
+func (c *IQC) ReqCurrentUpdateFNames() error {
+	w, done := c.awaitFields()
+	defer done()
+	return c.requestFields(w)
 }
 
-// SelectUpdateFields Change your fieldset for this connection. This fieldset applies to all summary and update messages you receive on this connection. (Comma seperated list of field names).
-func (c *IQC) SelectUpdateFields(fields ...string) {
-	c.Write("S,SELECT UPDATE FIELDS," + strings.Join(fields, ",") + "\r\n")
+// requestFields asks for the current field names and waits for a layout on w, registered with awaitFields beforehand.
+func (c *IQC) requestFields(w chan []string) error {
+	if err := c.send("S,REQUEST CURRENT UPDATE FIELDNAMES\r\n"); err != nil {
+		return err
+	}
+
+	select {
+	case <-w:
+		return nil
+	case <-time.After(c.confirmTimeout()):
+		return ErrTimeout
+	case <-c.stop:
+		// The layout may have been the last thing read before the feed ended.
+		select {
+		case <-w:
+			return nil
+		default:
+		}
+		return c.stopErr(ErrClientStopped)
+	}
 }
 
+// SelectUpdateFields Change your fieldset for this connection. This fieldset applies to all summary and update messages you receive on this connection. (Comma seperated list of field names).
+// It blocks until the feed confirms the new layout with a CURRENT UPDATE FIELDNAMES message, so messages for symbols watched afterwards are parsed against it, or returns ErrTimeout after ConfirmTimeout.
+// Field names the parser doesn't know, such as fields added to the feed after this package, are selected all the same and logged, their values are read from Extra. With StrictFields they fail with ErrUnknownField before anything is sent.
+func (c *IQC) SelectUpdateFields(fields ...string) error {
+	for _, f := range fields {
+		if knownUpdateFields[f] {
+			continue
+		}
+		if c.StrictFields {
+			return fmt.Errorf("%w: %q", ErrUnknownField, f)
+		}
+		c.log().Warnf("Update field %q is not known, its values will be in Extra", f)
+	}
+	w, done := c.awaitFields()
+	defer done()
+	if err := c.send("S,SELECT UPDATE FIELDS," + strings.Join(fields, ",") + "\r\n"); err != nil {
+		return err
+	}
 
-func (c *IQC) SearchSymbol(symbol string) {
-	c.Write(fmt.Sprintf("SBF,s,%s,e,%s,%s\r\n",symbol,"1 5 6 7",c.incr()))
+	timeout := time.After(c.confirmTimeout())
+	for {
+		select {
+		case names := <-w:
+			if sameFields(names, fields) {
+				// Remembered once confirmed so the selection can be replayed after a reconnect.
+				c.fieldsMu.Lock()
+				c.selectedFields = append([]string(nil), fields...)
+				c.fieldsMu.Unlock()
+				return nil
+			}
+		case <-timeout:
+			return ErrTimeout
+		case <-c.stop:
+			return c.stopErr(ErrClientStopped)
+		}
+	}
 }
 
-// RequestListedMarkets will request a list of all the listed markets from the feed.
-func (c *IQC) RequestListedMarkets() {
-	c.Write(fmt.Sprintf("SLM,%s\r\n", c.incr()))
+// sameFields reports whether the layout sent by the feed matches the requested fields, the feed always puts Symbol first whether requested or not.
+func sameFields(got, want []string) bool {
+	for len(got) > 0 && got[len(got)-1] == "" {
+		got = got[:len(got)-1]
+	}
+	if len(got) > 0 && got[0] == "Symbol" && (len(want) == 0 || want[0] != "Symbol") {
+		got = got[1:]
+	}
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// SearchSymbol sends a symbol search for symbol on the streaming connection without waiting for the results.
+//
+// Deprecated: symbol searches are answered on the lookup port, use SearchSymbols instead.
+func (c *IQC) SearchSymbol(symbol string) {
+	c.Write(fmt.Sprintf("SBF,s,%s,e,%s,%s\r\n", symbol, "1 5 6 7", c.incr()))
 }
 
 // SetLogLevels Change the logging levels for IQFeed. Level Docs: http://www.iqfeed.net/dev/api/docs/IQConnectLogging.cfm.
@@ -168,6 +338,7 @@ func (c *IQC) RequestWatches() {
 // UnwatchAllSymbols Unwatch all currently watched symbols.
 func (c *IQC) UnwatchAllSymbols() {
 	c.Write("S,UNWATCH ALL\r\n")
+	c.markAllUnwatched()
 }
 
 // Connect Tells IQFeed to initiate a connection to the Level 1 server. This happens automatically upon launching the feed unless the ProductID and/or Product version have not been set. This message is ignored if the feed is already connected.